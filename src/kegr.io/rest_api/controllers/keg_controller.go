@@ -2,15 +2,22 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 
 	"kegr.io/protobuf/model/storage/keg"
 	"kegr.io/protobuf/server/storage"
 
 	"github.com/gin-gonic/gin"
 	"kegr.io/storage_client"
+	"kegr.io/storage_controller/archive"
 )
 
+const archiveChunkSize = 1 << 20
+
 // KegController is the controller responsible for the Keg endpoints
 type KegController struct {
 	c storage_client.IClient
@@ -32,6 +39,11 @@ func (kc *KegController) Register(router *gin.Engine) {
 		group.GET("/", kc.getKegs)
 		group.GET("/:kegID", kc.get)
 		group.GET("/:kegID/liquid", kc.getLiquids)
+		group.POST("/:kegID/archive", kc.uploadArchive)
+		group.GET("/:kegID/archive", kc.downloadArchive)
+		group.POST("/:kegID/snapshot", kc.createSnapshot)
+		group.GET("/:kegID/snapshot", kc.getSnapshots)
+		group.POST("/:kegID/snapshot/:snapshotID/restore", kc.restoreSnapshot)
 		group.PUT("/:kegID", kc.update)
 		group.DELETE("/:kegID", kc.delete)
 		// group.GET("/:kegID/liquids", kc.getLiquids)
@@ -117,9 +129,169 @@ func (kc *KegController) delete(ctx *gin.Context) {
 }
 
 func (kc *KegController) getLiquids(ctx *gin.Context) {
-	res, err := kc.c.Get().GetKegLiquids(
+	pageSize, _ := strconv.ParseInt(ctx.Query("pageSize"), 10, 64)
+
+	res, err := kc.c.Get().ListLiquids(
 		context.Background(),
-		&storage.GetKegLiquidsRequest{
+		&storage.ListLiquidsRequest{
+			KegId:     ctx.Param("kegID"),
+			Prefix:    ctx.Query("prefix"),
+			Delimiter: ctx.Query("delimiter"),
+			PageToken: ctx.Query("pageToken"),
+			PageSize:  pageSize,
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"liquids":        res.Liquids,
+		"commonPrefixes": res.CommonPrefixes,
+		"nextPageToken":  res.NextPageToken,
+	})
+}
+
+func (kc *KegController) uploadArchive(ctx *gin.Context) {
+	info, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := info.Open()
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+
+	format := ctx.PostForm("format")
+	if len(format) == 0 {
+		format = archive.FormatFromName(info.Filename)
+	}
+	replace, _ := strconv.ParseBool(ctx.PostForm("replace"))
+
+	stream, err := kc.c.Get().UploadArchive(context.Background())
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := &storage.UploadArchiveRequest{
+		KegId:   ctx.Param("kegID"),
+		Format:  format,
+		Replace: replace,
+	}
+	chunk := make([]byte, archiveChunkSize)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			req.Chunk = chunk[:n]
+			if err := stream.Send(req); err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			req = &storage.UploadArchiveRequest{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// An empty archive still has to tell the server which keg it is for
+	if len(req.KegId) > 0 {
+		if err := stream.Send(req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"results": res.Results,
+		"deleted": res.Deleted,
+	})
+}
+
+func (kc *KegController) downloadArchive(ctx *gin.Context) {
+	kegID := ctx.Param("kegID")
+	format := ctx.DefaultQuery("format", archive.Zip)
+
+	stream, err := kc.c.Get().DownloadArchive(
+		context.Background(),
+		&storage.DownloadArchiveRequest{
+			KegId:  kegID,
+			Format: format,
+			Prefix: ctx.Query("prefix"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	started := false
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Once the archive has started there is no way to report the
+			// error other than cutting it short
+			if !started {
+				ctx.String(http.StatusBadRequest, err.Error())
+			} else {
+				log.Printf("archive download of keg %v failed: %v", kegID, err)
+			}
+			return
+		}
+
+		if !started {
+			ctx.Header("Content-Type", archive.ContentType(format))
+			ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", kegID, format))
+			ctx.Status(http.StatusOK)
+			started = true
+		}
+		if _, err = ctx.Writer.Write(res.GetChunk()); err != nil {
+			return
+		}
+		ctx.Writer.Flush()
+	}
+
+	if !started {
+		ctx.Status(http.StatusOK)
+	}
+}
+
+func (kc *KegController) createSnapshot(ctx *gin.Context) {
+	res, err := kc.c.Get().CreateKegSnapshot(
+		context.Background(),
+		&storage.CreateKegSnapshotRequest{
+			KegId:       ctx.Param("kegID"),
+			Description: ctx.PostForm("description"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusCreated, res.Snapshot)
+}
+
+func (kc *KegController) getSnapshots(ctx *gin.Context) {
+	res, err := kc.c.Get().ListKegSnapshots(
+		context.Background(),
+		&storage.ListKegSnapshotsRequest{
 			KegId: ctx.Param("kegID"),
 		},
 	)
@@ -127,5 +299,23 @@ func (kc *KegController) getLiquids(ctx *gin.Context) {
 		ctx.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	ctx.JSON(http.StatusOK, res.Liquids)
+	ctx.JSON(http.StatusOK, res.Snapshots)
+}
+
+func (kc *KegController) restoreSnapshot(ctx *gin.Context) {
+	res, err := kc.c.Get().RestoreKegSnapshot(
+		context.Background(),
+		&storage.RestoreKegSnapshotRequest{
+			KegId:      ctx.Param("kegID"),
+			SnapshotId: ctx.Param("snapshotID"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"updated": res.Updated,
+		"deleted": res.Deleted,
+	})
 }
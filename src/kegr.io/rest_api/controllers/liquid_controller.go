@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"kegr.io/protobuf/model/storage/keg"
 	"kegr.io/protobuf/model/storage/liquid"
 	"kegr.io/protobuf/server/storage"
 	"kegr.io/storage_client"
@@ -45,9 +46,10 @@ func (fc *LiquidController) update(ctx *gin.Context) {
 	_, err := fc.c.Get().UpdateLiquidOptions(
 		context.Background(),
 		&storage.UpdateLiquidOptionsRequest{
-			KegId:    ctx.Param("kegId"),
-			LiquidId: ctx.Param("liquidId"),
-			Options:  options,
+			KegId:       ctx.Param("kegId"),
+			LiquidId:    ctx.Param("liquidId"),
+			Options:     options,
+			Consistency: consistency(ctx),
 		},
 	)
 
@@ -62,8 +64,9 @@ func (fc *LiquidController) delete(ctx *gin.Context) {
 	_, err := fc.c.Get().DeleteLiquid(
 		context.Background(),
 		&storage.DeleteLiquidRequest{
-			KegId:    ctx.Param("kegId"),
-			LiquidId: ctx.Param("liquidId"),
+			KegId:       ctx.Param("kegId"),
+			LiquidId:    ctx.Param("liquidId"),
+			Consistency: consistency(ctx),
 		},
 	)
 
@@ -85,6 +88,11 @@ func (fc *LiquidController) create(ctx *gin.Context) {
 	sz := len(name)
 	name = strings.Replace(name, " ", "_", -1)[:sz-1]
 
+	// A name such as img/icons/a places the liquid under a prefix
+	if dir := strings.Trim(ctx.PostForm("prefix"), "/"); len(dir) > 0 {
+		name = dir + "/" + name
+	}
+
 	content := bytes.NewBuffer(nil)
 	_, err := io.Copy(content, file)
 	if err != nil {
@@ -92,7 +100,7 @@ func (fc *LiquidController) create(ctx *gin.Context) {
 		return
 	}
 
-	hash := util.GetFileHash(file)
+	hash := util.GetContentHash(content.Bytes())
 
 	size := info.Size
 	cache := int64(120)
@@ -114,8 +122,9 @@ func (fc *LiquidController) create(ctx *gin.Context) {
 	_, err = fc.c.Get().CreateLiquid(
 		context.Background(),
 		&storage.CreateLiquidRequest{
-			KegId:  ctx.PostForm("kegID"),
-			Liquid: liquid,
+			KegId:       ctx.PostForm("kegID"),
+			Liquid:      liquid,
+			Consistency: consistency(ctx),
 		},
 	)
 
@@ -126,3 +135,9 @@ func (fc *LiquidController) create(ctx *gin.Context) {
 
 	ctx.Status(http.StatusCreated)
 }
+
+// consistency reads the optional consistency level of a write, one, quorum
+// or all, leaving it to the keg's own when it's missing or unknown
+func consistency(ctx *gin.Context) keg.Consistency {
+	return keg.Consistency(keg.Consistency_value[strings.ToUpper(ctx.Query("consistency"))])
+}
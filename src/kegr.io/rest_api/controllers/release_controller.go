@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"kegr.io/protobuf/model/storage/liquid"
+	"kegr.io/protobuf/server/storage"
+	"kegr.io/storage_client"
+)
+
+// ReleaseController is the controller responsible for the Release endpoints
+type ReleaseController struct {
+	c storage_client.IClient
+	IController
+}
+
+// NewReleaseController creates a new instance of the ReleaseController struct
+func NewReleaseController(c storage_client.IClient) *ReleaseController {
+	return &ReleaseController{
+		c: c,
+	}
+}
+
+// Register registers the necessary API endpoints this controller serves
+func (rc *ReleaseController) Register(router *gin.Engine) {
+	group := router.Group("/keg/:kegID/release")
+	{
+		group.POST("/", rc.create)
+		group.GET("/", rc.getReleases)
+		group.POST("/:releaseID/liquid", rc.stageLiquid)
+		group.DELETE("/:releaseID/liquid/:liquidID", rc.stageDeletion)
+		group.POST("/:releaseID/commit", rc.commit)
+		group.POST("/:releaseID/revert", rc.revert)
+	}
+}
+
+func (rc *ReleaseController) create(ctx *gin.Context) {
+	res, err := rc.c.Get().CreateRelease(
+		context.Background(),
+		&storage.CreateReleaseRequest{
+			KegId:       ctx.Param("kegID"),
+			Description: ctx.PostForm("description"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.String(http.StatusCreated, res.GetDraftId())
+}
+
+func (rc *ReleaseController) getReleases(ctx *gin.Context) {
+	res, err := rc.c.Get().ListReleases(
+		context.Background(),
+		&storage.ListReleasesRequest{
+			KegId: ctx.Param("kegID"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, res.Releases)
+}
+
+// stageLiquid stages the uploaded file in a draft. Passing a liquidID form
+// value stages an update of that liquid instead of a new one.
+func (rc *ReleaseController) stageLiquid(ctx *gin.Context) {
+	info, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := info.Open()
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ext := path.Ext(info.Filename)
+	name := strings.Replace(strings.TrimSuffix(info.Filename, ext), " ", "_", -1)
+	if dir := strings.Trim(ctx.PostForm("prefix"), "/"); len(dir) > 0 {
+		name = dir + "/" + name
+	}
+	cache, err := strconv.ParseInt(ctx.DefaultPostForm("cache", "120"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res, err := rc.c.Get().StageLiquid(
+		context.Background(),
+		&storage.StageLiquidRequest{
+			KegId:   ctx.Param("kegID"),
+			DraftId: ctx.Param("releaseID"),
+			Liquid: &liquid.Liquid{
+				ID:      ctx.PostForm("liquidID"),
+				Content: content,
+				Options: &liquid.Options{
+					Name:  name,
+					Ext:   strings.TrimPrefix(ext, "."),
+					Cache: cache,
+				},
+			},
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.String(http.StatusCreated, res.GetLiquidId())
+}
+
+func (rc *ReleaseController) stageDeletion(ctx *gin.Context) {
+	_, err := rc.c.Get().StageLiquidDeletion(
+		context.Background(),
+		&storage.StageLiquidDeletionRequest{
+			KegId:    ctx.Param("kegID"),
+			DraftId:  ctx.Param("releaseID"),
+			LiquidId: ctx.Param("liquidID"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func (rc *ReleaseController) commit(ctx *gin.Context) {
+	res, err := rc.c.Get().CommitRelease(
+		context.Background(),
+		&storage.CommitReleaseRequest{
+			KegId:   ctx.Param("kegID"),
+			DraftId: ctx.Param("releaseID"),
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, res.Release)
+}
+
+func (rc *ReleaseController) revert(ctx *gin.Context) {
+	number, err := strconv.ParseInt(ctx.Param("releaseID"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res, err := rc.c.Get().RevertRelease(
+		context.Background(),
+		&storage.RevertReleaseRequest{
+			KegId:  ctx.Param("kegID"),
+			Number: number,
+		},
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, res.Release)
+}
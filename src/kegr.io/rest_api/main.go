@@ -1,15 +1,47 @@
 package main
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"kegr.io/rest_api/controllers"
 	"kegr.io/storage_client"
 )
 
 func main() {
-	client := storage_client.NewClient("localhost:24471")
+	// STORAGE_NODES lists the nodes to use, each as address@zone, with the
+	// ones in our ZONE preferred
+	nodes := storage_client.ParseNodes(os.Getenv("STORAGE_NODES"))
+	if len(nodes) == 0 {
+		nodes = []storage_client.Node{{Address: "localhost:24471"}}
+	}
+
+	client, err := storage_client.NewZonedClient(nodes, os.Getenv("ZONE"), storage_client.Credentials{
+		CAFile:   os.Getenv("STORAGE_CA_FILE"),
+		CertFile: os.Getenv("STORAGE_CERT_FILE"),
+		KeyFile:  os.Getenv("STORAGE_KEY_FILE"),
+		Token:    os.Getenv("STORAGE_TOKEN"),
+	})
+	if err != nil {
+		log.Fatalf("failed to connect to the storage controller: %v", err)
+	}
 
+	// Once shutting down, writes are turned away while reads in flight and
+	// new ones are still served until the server closes
+	var draining int32
 	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		if atomic.LoadInt32(&draining) == 1 && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Shutting down"})
+		}
+	})
 
 	kegController := controllers.NewKegController(client)
 	kegController.Register(r)
@@ -17,5 +49,43 @@ func main() {
 	liquidController := controllers.NewLiquidController(client)
 	liquidController.Register(r)
 
-	r.Run()
+	releaseController := controllers.NewReleaseController(client)
+	releaseController.Register(r)
+
+	srv := &http.Server{Addr: address(), Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	log.Println("shutting down")
+	atomic.StoreInt32(&draining, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down cleanly: %v\n", err)
+	}
+}
+
+// address is where gin would listen by default, on PORT or 8080
+func address() string {
+	if port := os.Getenv("PORT"); len(port) > 0 {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// shutdownTimeout is how long requests in flight get to finish, from
+// SHUTDOWN_TIMEOUT
+func shutdownTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		return timeout
+	}
+	return 30 * time.Second
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/security"
+)
+
+const usage = `usage: storage_admin [flags] <command>
+
+commands:
+  status        the health of every peer as the node sees it
+  sync <peer>   compare every keg with a peer's and apply what it's ahead on
+  evict <peer>  declare a peer dead, once it has been stopped
+  diff <peer>   what syncing with a peer would take from it
+  mirror        how far behind the mirror cluster is, or whether the node
+                is a mirror itself
+  promote       make a mirror node take writes, run on every node of the
+                mirror cluster to fail over to it
+  conflicts <keg>
+                the versions of a keg's liquids the node discarded for
+                concurrent edits
+  resolve <keg> <conflict> [restore]
+                forget a conflict, writing the discarded version back first
+                when restore is given
+
+The node's internal port is used, along with its certificates: TLS_CA_FILE,
+TLS_CERT_FILE and TLS_KEY_FILE.
+
+flags:
+`
+
+func main() {
+	address := flag.String("address", "localhost:23471", "internal address of the node")
+	timeout := flag.Duration("timeout", time.Minute, "how long to wait for the node")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := dial(*address)
+	if err != nil {
+		log.Fatalf("failed to connect to %v: %v", *address, err)
+	}
+	defer conn.Close()
+	client := pbServer.NewAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch {
+	case args[0] == "status":
+		err = status(ctx, client)
+	case args[0] == "sync" && len(args) == 2:
+		_, err = client.ForceSync(ctx, &pbServer.ForceSyncRequest{Peer: args[1]})
+	case args[0] == "evict" && len(args) == 2:
+		_, err = client.EvictPeer(ctx, &pbServer.EvictPeerRequest{Peer: args[1]})
+	case args[0] == "diff" && len(args) == 2:
+		err = diff(ctx, client, args[1])
+	case args[0] == "mirror":
+		err = mirror(ctx, client)
+	case args[0] == "promote":
+		_, err = client.PromoteMirror(ctx, &pbServer.PromoteMirrorRequest{})
+	case args[0] == "conflicts" && len(args) == 2:
+		err = conflicts(ctx, client, args[1])
+	case args[0] == "resolve" && (len(args) == 3 || len(args) == 4 && args[3] == "restore"):
+		_, err = client.ResolveConflict(ctx, &pbServer.ResolveConflictRequest{
+			KegId:      args[1],
+			ConflictId: args[2],
+			Restore:    len(args) == 4,
+		})
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%v failed: %v", args[0], err)
+	}
+}
+
+// dial connects with the node's certificates when a CA is configured
+func dial(address string) (*grpc.ClientConn, error) {
+	caFile := os.Getenv("TLS_CA_FILE")
+	if len(caFile) == 0 {
+		return grpc.Dial(address, grpc.WithInsecure())
+	}
+
+	config, err := security.ClientTLS(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"), caFile)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+}
+
+func status(ctx context.Context, client pbServer.AdminClient) error {
+	res, err := client.ClusterStatus(ctx, &pbServer.ClusterStatusRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("node %v, state %v\n\n", res.GetId(), short(res.GetState()))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tADDRESS\tZONE\tMEMBER\tSTATUS\tLAST PING\tSTATE\tLAST SYNC")
+	for _, peer := range res.GetPeers() {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			peer.GetId(),
+			peer.GetAddress(),
+			peer.GetZone(),
+			peer.GetMember(),
+			peer.GetStatus(),
+			ago(peer.GetLastPing()),
+			short(peer.GetState()),
+			ago(peer.GetLastSync()),
+		)
+	}
+	return w.Flush()
+}
+
+func diff(ctx context.Context, client pbServer.AdminClient, peer string) error {
+	res, err := client.DiffWithPeer(ctx, &pbServer.DiffWithPeerRequest{Peer: peer})
+	if err != nil {
+		return err
+	}
+
+	if len(res.GetKegs()) == 0 {
+		fmt.Printf("in sync with %v\n", peer)
+		return nil
+	}
+
+	for _, k := range res.GetKegs() {
+		fmt.Printf("keg %v at /%v\n", k.GetKegId(), k.GetPath())
+		switch {
+		case k.GetCreated():
+			fmt.Println("  new keg")
+		case k.GetDeleted():
+			fmt.Println("  deleted")
+		case k.GetOptions() != nil:
+			fmt.Println("  options changed")
+		}
+		if k.GetRelease() > 0 {
+			fmt.Printf("  release %v\n", k.GetRelease())
+		}
+		for _, content := range k.GetLiquids() {
+			fmt.Printf("  liquid %s\n", content.GetID())
+		}
+	}
+	return nil
+}
+
+func mirror(ctx context.Context, client pbServer.AdminClient) error {
+	res, err := client.MirrorStatus(ctx, &pbServer.MirrorStatusRequest{})
+	if err != nil {
+		return err
+	}
+
+	if len(res.GetTarget()) == 0 && len(res.GetMirrorOf()) == 0 {
+		fmt.Println("not mirroring")
+		return nil
+	}
+
+	if len(res.GetTarget()) > 0 {
+		kegs := "all kegs"
+		if len(res.GetKegs()) > 0 {
+			kegs = strings.Join(res.GetKegs(), ", ")
+		}
+		fmt.Printf("mirroring %v to %v\n", kegs, res.GetTarget())
+		fmt.Printf("  pushed %v of %v events, %vs behind\n", res.GetPushed(), res.GetSequence(), res.GetLag())
+		fmt.Printf("  last push %v\n", ago(res.GetLastPush()))
+		if len(res.GetLastError()) > 0 {
+			fmt.Printf("  last error: %v\n", res.GetLastError())
+		}
+	}
+
+	if len(res.GetMirrorOf()) > 0 {
+		state := "read-only"
+		if res.GetPromoted() {
+			state = "promoted"
+		}
+		fmt.Printf("mirror of %v, %v\n", res.GetMirrorOf(), state)
+		fmt.Printf("  last applied %v\n", ago(res.GetLastApplied()))
+	}
+	return nil
+}
+
+func conflicts(ctx context.Context, client pbServer.AdminClient, kegID string) error {
+	res, err := client.ListConflicts(ctx, &pbServer.ListConflictsRequest{KegId: kegID})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLIQUID\tDETECTED\tKEPT\tDISCARDED")
+	for _, c := range res.GetConflicts() {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+			c.GetId(),
+			c.GetLiquidId(),
+			ago(c.GetDetected()),
+			version(c.GetKept().GetUpdatedBy(), c.GetKept().GetDeleted()),
+			version(c.GetDiscarded().GetUpdatedBy(), c.GetDiscarded().GetDeleted()),
+		)
+	}
+	return w.Flush()
+}
+
+// version describes a version of a liquid by the node that wrote it
+func version(updatedBy string, deleted bool) string {
+	if deleted {
+		return "deleted by " + updatedBy
+	}
+	return "by " + updatedBy
+}
+
+// short abbreviates a hash for display
+func short(hash []byte) string {
+	if len(hash) > 4 {
+		hash = hash[:4]
+	}
+	return hex.EncodeToString(hash)
+}
+
+func ago(unix int64) string {
+	if unix == 0 {
+		return "never"
+	}
+	return time.Since(time.Unix(unix, 0)).Round(time.Second).String() + " ago"
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	pbServer "kegr.io/protobuf/server/storage"
 )
 
@@ -19,11 +20,17 @@ type SingleClient struct {
 type ISingleClient interface {
 	Shutdown()
 	Get() pbServer.ExternalClient
+	IsHealthy() bool
 }
 
-// NewSingleClientClient initialises connection to the remote cerberus instance
-func NewSingleClientClient(address string) *SingleClient {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+// NewSingleClientClient initialises connection to the remote cerberus instance,
+// in plaintext unless dial options with credentials are given
+func NewSingleClientClient(address string, opts ...grpc.DialOption) *SingleClient {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+
+	conn, err := grpc.Dial(address, opts...)
 	if err != nil {
 		log.Printf("fail to dial: %v", err)
 	}
@@ -40,6 +47,16 @@ func (c *SingleClient) Get() pbServer.ExternalClient {
 	return c.client
 }
 
+// IsHealthy reports whether the connection is usable, or may be once it
+// has connected
+func (c *SingleClient) IsHealthy() bool {
+	if c.conn == nil {
+		return false
+	}
+	state := c.conn.GetState()
+	return state != connectivity.TransientFailure && state != connectivity.Shutdown
+}
+
 // Shutdown closes the connection with that server
 func (c *SingleClient) Shutdown() {
 	c.conn.Close()
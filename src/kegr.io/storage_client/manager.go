@@ -1,10 +1,20 @@
 package storage_client
 
-import pbServer "kegr.io/protobuf/server/storage"
+import (
+	"errors"
+	"strings"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/security"
+)
 
 // Client handles the connection to a storage controller cluster
 type Client struct {
 	clients map[string]ISingleClient
+	// order is the addresses of the nodes in order of preference
+	order []string
 }
 
 // IClient is the Client interface
@@ -19,10 +29,121 @@ func NewClient(address string) *Client {
 		clients: make(map[string]ISingleClient),
 	}
 	cli.clients["temp"] = NewSingleClientClient(address)
+	cli.order = []string{"temp"}
 	return cli
 }
 
-// Get returns the best storage controller client to use
+// Credentials configures how a client authenticates to a cluster. The
+// connection is plaintext without a CA file, and the token is only sent
+// over TLS then.
+type Credentials struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Token    string
+}
+
+// NewSecureClient is NewClient connecting with credentials
+func NewSecureClient(address string, creds Credentials) (*Client, error) {
+	opts, err := creds.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cli := &Client{
+		clients: make(map[string]ISingleClient),
+	}
+	cli.clients["temp"] = NewSingleClientClient(address, opts...)
+	cli.order = []string{"temp"}
+	return cli, nil
+}
+
+// Node is a storage controller of the cluster and the zone it runs in
+type Node struct {
+	Address string
+	Zone    string
+}
+
+// ParseNodes reads a comma separated list of nodes, each an address
+// optionally followed by @ and its zone, as in "10.0.0.1:24471@rack-1"
+func ParseNodes(list string) []Node {
+	var nodes []Node
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		node := Node{Address: entry}
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			node = Node{Address: entry[:i], Zone: entry[i+1:]}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// NewZonedClient connects to several nodes of a cluster, preferring the
+// ones in the caller's zone. Any node serves any keg, passing reads on to
+// the keg's replicas in its own zone first, so reads stay within the zone
+// while it has a replica. The other zones' nodes are only used once none
+// in ours is reachable.
+func NewZonedClient(nodes []Node, zone string, creds Credentials) (*Client, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("No storage controller nodes given")
+	}
+
+	opts, err := creds.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cli := &Client{
+		clients: make(map[string]ISingleClient),
+	}
+	var remote []string
+	for _, node := range nodes {
+		cli.clients[node.Address] = NewSingleClientClient(node.Address, opts...)
+		if node.Zone == zone {
+			cli.order = append(cli.order, node.Address)
+		} else {
+			remote = append(remote, node.Address)
+		}
+	}
+	cli.order = append(cli.order, remote...)
+	return cli, nil
+}
+
+func (c Credentials) dialOptions() ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+
+	secure := len(c.CAFile) > 0
+	if secure {
+		config, err := security.ClientTLS(c.CertFile, c.KeyFile, c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	if len(c.Token) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(security.TokenCredentials{
+			Token:  c.Token,
+			Secure: secure,
+		}))
+	}
+	return opts, nil
+}
+
+// Get returns the best storage controller client to use, the first
+// healthy one in order of preference
 func (c *Client) Get() pbServer.ExternalClient {
-	return c.clients["temp"].Get()
+	for _, address := range c.order {
+		if client := c.clients[address]; client.IsHealthy() {
+			return client.Get()
+		}
+	}
+	return c.clients[c.order[0]].Get()
 }
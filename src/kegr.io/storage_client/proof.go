@@ -0,0 +1,77 @@
+package storage_client
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+
+	pbLiquid "kegr.io/protobuf/model/storage/liquid"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/security"
+	"kegr.io/storage_controller/util"
+)
+
+// VerifyLiquid checks a liquid fetched from any node is the version the
+// cluster holds, against a proof fetched from the cluster. The proof has
+// to lead from the liquid to the root of its keg's tree, and the root to
+// be signed by a node whose certificate chains up to one of the roots. A
+// nil roots only checks the path, for clusters running without
+// certificates.
+func VerifyLiquid(kegID string, l *pbLiquid.Liquid, res *pbServer.GetLiquidProofResponse, roots *x509.CertPool) error {
+	model := liquid.FromProto(l)
+	info := model.GetLiquidInfo()
+
+	// The tree's leaf hashes the liquid's info, which holds the hash of its
+	// content the cluster computed when it was written, so the content is
+	// checked against that one and the info against the leaf
+	if !info.IsDeleted() && !bytes.Equal(util.GetContentHash(model.GetContent()), info.GetFileHash()) {
+		return errors.New("Liquid content doesn't match its hash")
+	}
+
+	content, err := liquid.NewMerkleTreeLiquid(info)
+	if err != nil {
+		return err
+	}
+	if err = merkle.VerifyProof(content.GetHash(), res.GetProof()); err != nil {
+		return err
+	}
+
+	root := res.GetRoot()
+	if root.GetKegId() != kegID || !bytes.Equal(root.GetHash(), res.GetProof().GetRoot()) {
+		return errors.New("Proof isn't for the keg's root")
+	}
+	if roots == nil {
+		return nil
+	}
+	return security.VerifySignature(merkle.RootMessage(root.GetKegId(), root.GetHash(), root.GetSigned()), root.GetSignature(), root.GetCertificates(), roots)
+}
+
+// GetVerifiedLiquid fetches a liquid along with a proof of it and verifies
+// one against the other. The proof only holds for the version of the
+// liquid the cluster has at the time, so a liquid updated in between fails
+// the check and is best fetched again.
+func (c *Client) GetVerifiedLiquid(ctx context.Context, kegID, liquidID string, roots *x509.CertPool) (*pbLiquid.Liquid, error) {
+	res, err := c.Get().GetLiquid(ctx, &pbServer.GetLiquidRequest{
+		KegId:    kegID,
+		LiquidId: liquidID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := c.Get().GetLiquidProof(ctx, &pbServer.GetLiquidProofRequest{
+		KegId:    kegID,
+		LiquidId: liquidID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = VerifyLiquid(kegID, res.GetLiquid(), proof, roots); err != nil {
+		return nil, err
+	}
+	return res.GetLiquid(), nil
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+var testEntries = map[string]string{
+	"index.html":      "<html></html>",
+	"js/app.js":       "console.log(1)",
+	"img/icons/a.png": "png",
+}
+
+func writeZip(t *testing.T, w io.Writer) {
+	zw := zip.NewWriter(w)
+	for name, content := range testEntries {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	zw.Close()
+}
+
+func writeTarGz(t *testing.T, w io.Writer) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "js/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range testEntries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+}
+
+func testWalk(t *testing.T, write func(*testing.T, io.Writer)) {
+	file, err := ioutil.TempFile("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	write(t, file)
+	file.Seek(0, io.SeekStart)
+
+	seen := make(map[string]string)
+	err = Walk(file, "", func(name string, content io.Reader) error {
+		b, err := ioutil.ReadAll(content)
+		seen[name] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != len(testEntries) {
+		t.Errorf("expected %v entries got %v", len(testEntries), len(seen))
+	}
+	for name, content := range testEntries {
+		if seen[name] != content {
+			t.Errorf("entry %v: expected %q got %q", name, content, seen[name])
+		}
+	}
+}
+
+func TestWalkZip(t *testing.T) {
+	testWalk(t, writeZip)
+}
+
+func TestWalkTarGz(t *testing.T) {
+	testWalk(t, writeTarGz)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	for _, format := range []string{Zip, Tar, TarGz} {
+		testWalk(t, func(t *testing.T, w io.Writer) {
+			aw, err := NewWriter(w, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range testEntries {
+				if err := aw.Add(name, time.Now(), []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := aw.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	cases := map[string]string{
+		"build.zip":    Zip,
+		"build.tar":    Tar,
+		"build.tar.gz": TarGz,
+		"build.TGZ":    TarGz,
+		"build.rar":    "",
+	}
+	for name, expected := range cases {
+		if format := FormatFromName(name); format != expected {
+			t.Errorf("%v: expected %q got %q", name, expected, format)
+		}
+	}
+}
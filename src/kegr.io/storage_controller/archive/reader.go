@@ -0,0 +1,130 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Supported archive formats
+const (
+	Zip   = "zip"
+	Tar   = "tar"
+	TarGz = "tar.gz"
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// FormatFromName guesses the archive format from a file name
+func FormatFromName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return Zip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGz
+	case strings.HasSuffix(name, ".tar"):
+		return Tar
+	}
+	return ""
+}
+
+// Walk calls fn for every regular file in the archive, in archive order.
+// When format is empty it is detected from the file contents.
+func Walk(file *os.File, format string, fn func(name string, content io.Reader) error) error {
+	if len(format) == 0 {
+		var err error
+		if format, err = detect(file); err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case Zip:
+		return walkZip(file, fn)
+	case Tar:
+		return walkTar(file, fn)
+	case TarGz:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	}
+	return errors.New("Unsupported archive format")
+}
+
+func detect(file *os.File) (string, error) {
+	header := make([]byte, 4)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(header[:n], zipMagic):
+		return Zip, nil
+	case bytes.HasPrefix(header[:n], gzipMagic):
+		return TarGz, nil
+	}
+	return Tar, nil
+}
+
+func walkZip(file *os.File, fn func(name string, content io.Reader) error) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	r, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		content, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(name string, content io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		if err = fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
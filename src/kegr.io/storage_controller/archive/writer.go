@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"time"
+)
+
+// Writer adds files one at a time to an archive written to an
+// underlying stream
+type Writer interface {
+	Add(name string, modTime time.Time, content []byte) error
+	Close() error
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+// NewWriter returns a Writer producing an archive of the given format
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case Zip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	case Tar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	case TarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(gz), gz: gz}, nil
+	}
+	return nil, errors.New("Unsupported archive format")
+}
+
+// ContentType returns the mime type of an archive format
+func ContentType(format string) string {
+	switch format {
+	case Zip:
+		return "application/zip"
+	case Tar:
+		return "application/x-tar"
+	case TarGz:
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
+
+// Add writes a file to the zip archive
+func (w *zipWriter) Add(name string, modTime time.Time, content []byte) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	f, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
+
+// Close writes the zip central directory
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// Add writes a file to the tar archive
+func (w *tarWriter) Add(name string, modTime time.Time, content []byte) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(content)
+	return err
+}
+
+// Close finishes the tar stream and the gzip stream around it
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package clock
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	logicalBits = 16
+
+	// MaxOffset is how far ahead of our own clock a remote timestamp may be
+	// before we stop following it
+	MaxOffset = time.Minute
+)
+
+// Clock is a hybrid logical clock. Timestamps pack the wall clock in
+// milliseconds into the high bits of an int64 and a logical counter into the
+// low 16 bits, so they order like plain integers, never go backwards and
+// always move past any timestamp the node has observed from its peers.
+type Clock struct {
+	mu   sync.Mutex
+	last int64
+	now  func() time.Time
+}
+
+var (
+	global = New(time.Now)
+	node   string
+)
+
+// New returns a clock reading the wall time from now
+func New(now func() time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns a timestamp greater than every one returned or observed so far
+func (c *Clock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	physical := FromTime(c.now())
+	if physical > c.last {
+		c.last = physical
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+// Observe merges a timestamp received from another node, so the next local
+// timestamp orders after it
+func (c *Clock) Observe(remote int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remote <= c.last {
+		return
+	}
+
+	if ahead := ToTime(remote).Sub(c.now()); ahead > MaxOffset {
+		log.Printf("ignoring timestamp %v ahead of the local clock\n", ahead)
+		return
+	}
+	c.last = remote
+}
+
+// Now returns a new timestamp from the process wide clock
+func Now() int64 {
+	return global.Now()
+}
+
+// Observe merges a remote timestamp into the process wide clock
+func Observe(remote int64) {
+	global.Observe(remote)
+}
+
+// SetNode sets the id of this node, which is recorded alongside every
+// timestamp it hands out to break ties
+func SetNode(id string) {
+	node = id
+}
+
+// Node returns the id of this node
+func Node() string {
+	return node
+}
+
+// FromTime returns the earliest timestamp at the given wall time
+func FromTime(t time.Time) int64 {
+	return (t.UnixNano() / int64(time.Millisecond)) << logicalBits
+}
+
+// ToTime returns the wall time part of a timestamp
+func ToTime(ts int64) time.Time {
+	ms := ts >> logicalBits
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Newer reports whether the version written at one timestamp by one node
+// wins over a version written at another timestamp by another node. Equal
+// timestamps are broken by node id so every node picks the same winner.
+func Newer(ts int64, node string, otherTs int64, otherNode string) bool {
+	if ts != otherTs {
+		return ts > otherTs
+	}
+	return node > otherNode
+}
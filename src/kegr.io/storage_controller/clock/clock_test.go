@@ -0,0 +1,75 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func frozen(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func TestNowMonotonicWithFrozenWallClock(t *testing.T) {
+	c := New(frozen(time.Unix(1000, 0)))
+
+	prev := c.Now()
+	for i := 0; i < 100; i++ {
+		next := c.Now()
+		if next <= prev {
+			t.Fatalf("timestamp went backwards: %v after %v", next, prev)
+		}
+		prev = next
+	}
+}
+
+func TestNowMonotonicWhenWallClockGoesBack(t *testing.T) {
+	wall := time.Unix(1000, 0)
+	c := New(func() time.Time { return wall })
+
+	before := c.Now()
+	wall = wall.Add(-10 * time.Second)
+	if after := c.Now(); after <= before {
+		t.Errorf("timestamp went backwards: %v after %v", after, before)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	wall := time.Unix(1000, 0)
+	c := New(frozen(wall))
+
+	remote := FromTime(wall.Add(time.Second))
+	c.Observe(remote)
+	if now := c.Now(); now <= remote {
+		t.Errorf("expected a timestamp after %v, got %v", remote, now)
+	}
+}
+
+func TestObserveIgnoresFarFuture(t *testing.T) {
+	wall := time.Unix(1000, 0)
+	c := New(frozen(wall))
+
+	remote := FromTime(wall.Add(2 * MaxOffset))
+	c.Observe(remote)
+	if now := c.Now(); now >= remote {
+		t.Errorf("expected the far future timestamp to be ignored, got %v", now)
+	}
+}
+
+func TestNewerBreaksTiesByNode(t *testing.T) {
+	if !Newer(2, "a", 1, "b") {
+		t.Error("later timestamp should win")
+	}
+	if !Newer(1, "b", 1, "a") || Newer(1, "a", 1, "b") {
+		t.Error("ties should be broken by node id")
+	}
+	if Newer(1, "a", 1, "a") {
+		t.Error("a version should not be newer than itself")
+	}
+}
+
+func TestToTime(t *testing.T) {
+	wall := time.Unix(1000, 5*int64(time.Millisecond))
+	if got := ToTime(FromTime(wall) + 3); !got.Equal(wall) {
+		t.Errorf("expected %v got %v", wall, got)
+	}
+}
@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config is used to deserialize the yaml config file
 // into an usable golang structure we can pass around
@@ -10,10 +15,85 @@ type Config struct {
 	APIPort          string
 	InternalGrpcPort string
 	ExternalGrpcPort string
-	Other            string
 	MachineName      string
 	LiquidExtension  string
 	KegFile          string
+
+	// Zone is the rack or zone the node runs in, a keg's replicas are
+	// spread across as many of them as possible
+	Zone string
+
+	// Cluster bootstrap, the nodes to join through and where the last known
+	// members are kept so a restarted node can find its way back
+	Seeds     []string
+	SeedsFile string
+	SeedsDNS  string
+	PeersFile string
+
+	ReplicationLogSize int
+
+	// Anti-entropy compares every peer's state with ours each interval,
+	// sooner right after finding a difference and backing off up to
+	// AntiEntropyMaxBackoff while the peer is down. At most
+	// ResyncConcurrency full comparisons run at once.
+	AntiEntropyInterval         time.Duration
+	AntiEntropyMismatchInterval time.Duration
+	AntiEntropyMaxBackoff       time.Duration
+	ResyncConcurrency           int
+
+	// ShutdownTimeout bounds each step of shutting down: finishing calls in
+	// flight, flushing the replication log and leaving the cluster
+	ShutdownTimeout time.Duration
+
+	// QuorumTimeout bounds how long a write or read waits on the replicas
+	// its consistency level requires
+	QuorumTimeout time.Duration
+
+	// ConflictRetention is how long the versions discarded by concurrent
+	// edits are kept for operators to restore
+	ConflictRetention time.Duration
+
+	// Fetching liquids from peers, PeerBandwidth is in bytes per second
+	// with 0 meaning unlimited
+	FetchConcurrency int
+	FetchRetries     int
+	FetchBackoff     time.Duration
+	PeerBandwidth    int64
+
+	ProbeInterval     time.Duration
+	ProbeTimeout      time.Duration
+	IndirectProbes    int
+	SuspicionTimeout  time.Duration
+	DeadMemberTimeout time.Duration
+
+	// One-way mirroring to a separate cluster. MirrorTarget is the mirror
+	// address of one of its nodes, and MirrorKegs the kegs pushed to it,
+	// every keg when empty. MirrorOf is set on the mirror cluster's nodes
+	// instead, naming the ClusterName of the cluster they mirror.
+	ClusterName    string
+	MirrorTarget   string
+	MirrorKegs     []string
+	MirrorOf       string
+	MirrorGrpcPort string
+
+	// Internal service mutual TLS, the CA signing every member's certificate
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Mirror service mutual TLS, the CA signing the certificates of the
+	// clusters mirroring each other and no one else's, so neither side can
+	// reach the other's internal service
+	MirrorTLSCAFile   string
+	MirrorTLSCertFile string
+	MirrorTLSKeyFile  string
+
+	// External service TLS, with clients authenticating by bearer token or
+	// a certificate signed by the external CA
+	ExternalTLSCertFile string
+	ExternalTLSKeyFile  string
+	ExternalTLSCAFile   string
+	ExternalTokensFile  string
 }
 
 // C is the config instance
@@ -27,10 +107,62 @@ func Load() {
 		APIPort:          getenv("API_PORT", "8080"),
 		InternalGrpcPort: getenv("INTERNAL_GRPC_PORT", "23471"),
 		ExternalGrpcPort: getenv("EXTERNAL_GRPC_PORT", "24471"),
-		Other:            getenv("OTHER", ""),
 		MachineName:      getenv("MACHINE_NAME", "pesho"),
 		LiquidExtension:  "liquid",
 		KegFile:          ".keg",
+
+		Zone: getenv("ZONE", ""),
+
+		Seeds:     append(getenvList("SEEDS"), getenvList("OTHER")...),
+		SeedsFile: getenv("SEEDS_FILE", ""),
+		SeedsDNS:  getenv("SEEDS_DNS", ""),
+		PeersFile: getenv("PEERS_FILE", ""),
+
+		ReplicationLogSize: getenvInt("REPLICATION_LOG_SIZE", 4096),
+
+		AntiEntropyInterval:         getenvDuration("ANTI_ENTROPY_INTERVAL", 30*time.Second),
+		AntiEntropyMismatchInterval: getenvDuration("ANTI_ENTROPY_MISMATCH_INTERVAL", 2*time.Second),
+		AntiEntropyMaxBackoff:       getenvDuration("ANTI_ENTROPY_MAX_BACKOFF", 5*time.Minute),
+		ResyncConcurrency:           getenvInt("RESYNC_CONCURRENCY", 2),
+
+		ShutdownTimeout: getenvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		QuorumTimeout:   getenvDuration("QUORUM_TIMEOUT", 10*time.Second),
+
+		ConflictRetention: getenvDuration("CONFLICT_RETENTION", 7*24*time.Hour),
+
+		FetchConcurrency: getenvInt("FETCH_CONCURRENCY", 8),
+		FetchRetries:     getenvInt("FETCH_RETRIES", 3),
+		FetchBackoff:     getenvDuration("FETCH_BACKOFF", 500*time.Millisecond),
+		PeerBandwidth:    int64(getenvInt("PEER_BANDWIDTH", 0)),
+
+		ProbeInterval:     getenvDuration("PROBE_INTERVAL", 1*time.Second),
+		ProbeTimeout:      getenvDuration("PROBE_TIMEOUT", 500*time.Millisecond),
+		IndirectProbes:    getenvInt("INDIRECT_PROBES", 3),
+		SuspicionTimeout:  getenvDuration("SUSPICION_TIMEOUT", 5*time.Second),
+		DeadMemberTimeout: getenvDuration("DEAD_MEMBER_TIMEOUT", time.Minute),
+
+		ClusterName:    getenv("CLUSTER_NAME", "kegr"),
+		MirrorTarget:   getenv("MIRROR_TARGET", ""),
+		MirrorKegs:     getenvList("MIRROR_KEGS"),
+		MirrorOf:       getenv("MIRROR_OF", ""),
+		MirrorGrpcPort: getenv("MIRROR_GRPC_PORT", "25471"),
+
+		TLSCAFile:   getenv("TLS_CA_FILE", ""),
+		TLSCertFile: getenv("TLS_CERT_FILE", ""),
+		TLSKeyFile:  getenv("TLS_KEY_FILE", ""),
+
+		MirrorTLSCAFile:   getenv("MIRROR_TLS_CA_FILE", ""),
+		MirrorTLSCertFile: getenv("MIRROR_TLS_CERT_FILE", ""),
+		MirrorTLSKeyFile:  getenv("MIRROR_TLS_KEY_FILE", ""),
+
+		ExternalTLSCertFile: getenv("EXTERNAL_TLS_CERT_FILE", getenv("TLS_CERT_FILE", "")),
+		ExternalTLSKeyFile:  getenv("EXTERNAL_TLS_KEY_FILE", getenv("TLS_KEY_FILE", "")),
+		ExternalTLSCAFile:   getenv("EXTERNAL_TLS_CA_FILE", ""),
+		ExternalTokensFile:  getenv("EXTERNAL_TOKENS_FILE", ""),
+	}
+
+	if len(C.PeersFile) == 0 {
+		C.PeersFile = C.DataRoot + "/.members"
 	}
 }
 
@@ -41,3 +173,30 @@ func getenv(key, fallback string) string {
 	}
 	return value
 }
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getenvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getenvList splits a comma separated value, ignoring empty entries
+func getenvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
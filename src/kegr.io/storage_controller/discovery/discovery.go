@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver looks up the records seeds can be published under. It's
+// satisfied by *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Resolve returns the addresses a DNS name points to. SRV records are
+// preferred as they carry their own port, otherwise every A or AAAA record
+// is paired with the default port.
+func Resolve(ctx context.Context, r Resolver, name, defaultPort string) ([]string, error) {
+	var addresses []string
+
+	if _, records, err := r.LookupSRV(ctx, "", "", name); err == nil {
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			addresses = append(addresses, net.JoinHostPort(host, fmt.Sprint(record.Port)))
+		}
+	}
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+
+	hosts, err := r.LookupHost(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		addresses = append(addresses, net.JoinHostPort(host, defaultPort))
+	}
+	return addresses, nil
+}
+
+// ReadList reads a file with an address per line. Blank lines and lines
+// starting with # are skipped and a missing file is an empty list.
+func ReadList(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addresses []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		address := strings.TrimSpace(scanner.Text())
+		if len(address) > 0 && !strings.HasPrefix(address, "#") {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, scanner.Err()
+}
+
+// WriteList replaces a file with an address per line. The list is written
+// to a temporary file first, so a crash never leaves half a list behind.
+func WriteList(file string, addresses []string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, address := range addresses {
+		if _, err = fmt.Fprintln(tmp, address); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Merge joins address lists in order, dropping duplicates and the excluded
+// address
+func Merge(exclude string, lists ...[]string) []string {
+	seen := map[string]bool{exclude: true}
+
+	var addresses []string
+	for _, list := range lists {
+		for _, address := range list {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
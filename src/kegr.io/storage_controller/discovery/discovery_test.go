@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// stubResolver answers from fixed records the way a local DNS server would
+type stubResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, exist := r.srv[name]
+	if !exist {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	hosts, exist := r.hosts[host]
+	if !exist {
+		return nil, errors.New("no such host")
+	}
+	return hosts, nil
+}
+
+func TestResolve(t *testing.T) {
+	r := &stubResolver{
+		srv: map[string][]*net.SRV{
+			"_kegr._tcp.cluster.local": {
+				{Target: "node-1.cluster.local.", Port: 23471},
+				{Target: "node-2.cluster.local.", Port: 23472},
+			},
+		},
+		hosts: map[string][]string{
+			"cluster.local": {"10.0.0.1", "10.0.0.2", "fd00::3"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"_kegr._tcp.cluster.local", []string{"node-1.cluster.local:23471", "node-2.cluster.local:23472"}},
+		{"cluster.local", []string{"10.0.0.1:23471", "10.0.0.2:23471", "[fd00::3]:23471"}},
+	}
+
+	for _, test := range tests {
+		addresses, err := Resolve(context.Background(), r, test.name, "23471")
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(addresses, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, addresses)
+		}
+	}
+
+	if _, err := Resolve(context.Background(), r, "missing.local", "23471"); err == nil {
+		t.Error("expected an unknown name to fail")
+	}
+}
+
+func TestListRoundTrip(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "discovery")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".members")
+
+	addresses, err := ReadList(file)
+	if err != nil || len(addresses) != 0 {
+		t.Fatalf("expected a missing file to be an empty list, got %v, %v", addresses, err)
+	}
+
+	expected := []string{"10.0.0.1:23471", "10.0.0.2:23471"}
+	if err = WriteList(file, expected); err != nil {
+		t.Fatal(err)
+	}
+	if addresses, _ = ReadList(file); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the list to be left behind, got %d files", len(files))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merged := Merge("self:1",
+		[]string{"a:1", "self:1", "b:1"},
+		[]string{"b:1", "c:1"},
+	)
+
+	expected := []string{"a:1", "b:1", "c:1"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/clock"
 	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/mirror"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/security"
 	"kegr.io/storage_controller/server"
 	"kegr.io/storage_controller/state"
 	"kegr.io/storage_controller/sync"
@@ -16,14 +24,37 @@ import (
 
 func main() {
 	config.Load()
+	clock.SetNode(config.C.MachineName)
+	if err := security.Load(); err != nil {
+		log.Fatalf("failed to load credentials: %v", err)
+	}
 
 	stateService := state.NewStateService()
 	syncService := sync.NewSyncService(stateService)
+	replicationLog := replication.NewLog(config.C.MachineName, config.C.ReplicationLogSize)
+	mirrorTarget := mirror.NewTarget(config.C.MirrorOf, stateService, replicationLog)
+
+	// Writes are pushed to a mirror cluster as they're published, which
+	// never joins this one
+	var mirrorSource mirror.ISource
+	stopMirroring := make(chan struct{})
+	if len(config.C.MirrorTarget) > 0 {
+		source, err := mirror.NewSource(config.C.ClusterName, config.C.MirrorTarget, config.C.MirrorKegs, stateService, replicationLog)
+		if err != nil {
+			log.Fatalf("failed to connect to the mirror: %v", err)
+		}
+		mirrorSource = source
+		go source.Run(stopMirroring)
+	}
 
-	grpcInternalServer := grpc.NewServer()
-	internalServer := server.NewInternalServer(syncService, stateService)
+	grpcInternalServer := grpc.NewServer(security.InternalServerOptions()...)
+	internalServer := server.NewInternalServer(syncService, stateService, replicationLog)
 	storage.RegisterInternalServer(grpcInternalServer, internalServer)
 
+	// The admin service shares the internal port and its certificates
+	adminServer := server.NewAdminServer(syncService, stateService, replicationLog, mirrorSource, mirrorTarget)
+	storage.RegisterAdminServer(grpcInternalServer, adminServer)
+
 	log.Println("starting grpc servers")
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%v", config.C.InternalGrpcPort))
 	if err != nil {
@@ -32,8 +63,30 @@ func main() {
 
 	go grpcInternalServer.Serve(lis)
 
-	grpcExternalServer := grpc.NewServer()
-	externalServer := server.NewExternalServer(stateService)
+	// The mirror service has a port and certificates of its own, as the
+	// cluster pushing to it isn't a member of this one
+	var grpcMirrorServer *grpc.Server
+	if len(config.C.MirrorOf) > 0 {
+		grpcMirrorServer = grpc.NewServer(security.MirrorServerOptions()...)
+		storage.RegisterMirrorServer(grpcMirrorServer, server.NewMirrorServer(mirrorTarget))
+
+		lis, err = net.Listen("tcp", fmt.Sprintf(":%v", config.C.MirrorGrpcPort))
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+
+		go grpcMirrorServer.Serve(lis)
+	}
+
+	// Writes are turned away when shutting down. grpc runs the interceptors
+	// security sets up before any chained one, so requests are still
+	// authenticated before they're turned away.
+	externalServer := server.NewExternalServer(stateService, syncService, replicationLog, mirrorTarget)
+	externalOptions := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(externalServer.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(externalServer.StreamInterceptor),
+	}, security.ExternalServerOptions()...)
+	grpcExternalServer := grpc.NewServer(externalOptions...)
 	storage.RegisterExternalServer(grpcExternalServer, externalServer)
 
 	lis, err = net.Listen("tcp", fmt.Sprintf(":%v", config.C.ExternalGrpcPort))
@@ -41,5 +94,47 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcExternalServer.Serve(lis)
+	go grpcExternalServer.Serve(lis)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	log.Println("shutting down")
+	externalServer.Drain()
+	shutdown(func(ctx context.Context) { gracefulStop(ctx, grpcExternalServer) })
+	if grpcMirrorServer != nil {
+		shutdown(func(ctx context.Context) { gracefulStop(ctx, grpcMirrorServer) })
+	}
+	shutdown(func(ctx context.Context) {
+		if err := replicationLog.Close(ctx); err != nil {
+			log.Printf("failed to flush the replication log: %v\n", err)
+		}
+	})
+	close(stopMirroring)
+	shutdown(syncService.Leave)
+	shutdown(func(ctx context.Context) { gracefulStop(ctx, grpcInternalServer) })
+}
+
+// shutdown runs a step of shutting down, giving it ShutdownTimeout
+func shutdown(step func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.ShutdownTimeout)
+	defer cancel()
+	step(ctx)
+}
+
+// gracefulStop lets the calls in flight finish, cutting them off once the
+// context is done
+func gracefulStop(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
 }
@@ -0,0 +1,504 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	pbModel "kegr.io/protobuf/model/storage/server"
+)
+
+const (
+	// maxPiggyback is how many membership updates ride along a single probe
+	maxPiggyback = 16
+
+	// retransmitMult scales how many times each update is passed on, which
+	// grows with the log of the cluster size so it reaches every member
+	retransmitMult = 3
+)
+
+// Member is a node of the cluster as this node sees it
+type Member struct {
+	ID          string
+	Address     string
+	Zone        string
+	State       pbModel.ServerInfo_State
+	Incarnation uint64
+
+	changed time.Time
+}
+
+// Transport sends probes to other members, piggybacking membership updates
+// and returning the ones the other member piggybacked on its answer
+type Transport interface {
+	Probe(ctx context.Context, target Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error)
+	IndirectProbe(ctx context.Context, via, target Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error)
+}
+
+// Listener is told about members changing state and being removed
+type Listener interface {
+	MemberChanged(m Member)
+	MemberRemoved(id string)
+}
+
+// Config holds the timings of the protocol
+type Config struct {
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	IndirectProbes   int
+	SuspicionTimeout time.Duration
+	RemovalTimeout   time.Duration
+}
+
+// Membership keeps track of the cluster's members SWIM style. Every
+// ProbeInterval one member is probed, directly and then through
+// IndirectProbes others. A member that doesn't answer is suspected, and
+// declared dead if it doesn't refute the suspicion within SuspicionTimeout.
+// Dead members are forgotten after RemovalTimeout. Changes are gossiped by
+// piggybacking them on the probes.
+type Membership struct {
+	mu         sync.Mutex
+	self       Member
+	members    map[string]*Member
+	rumours    []*rumour
+	probeOrder []string
+	events     []func()
+
+	config    Config
+	transport Transport
+	listener  Listener
+	now       func() time.Time
+}
+
+// IMembership is the Membership interface
+type IMembership interface {
+	GetSelf() Member
+	Get(id string) (Member, bool)
+	Members() []Member
+	Join(id, address, zone string, incarnation uint64) uint64
+	SetIncarnation(incarnation uint64)
+	Apply(updates []*pbModel.ServerInfo)
+	Updates() []*pbModel.ServerInfo
+	ProbeDirect(ctx context.Context, target Member) error
+	Evict(id string) error
+	Leave(ctx context.Context)
+	Run(stop <-chan struct{})
+}
+
+type rumour struct {
+	member    Member
+	transmits int
+}
+
+// New returns the membership of a cluster made up of this node only
+func New(id, address, zone string, config Config, transport Transport, listener Listener) *Membership {
+	return &Membership{
+		self: Member{
+			ID:      id,
+			Address: address,
+			Zone:    zone,
+			State:   pbModel.ServerInfo_ALIVE,
+		},
+		members:   make(map[string]*Member),
+		config:    config,
+		transport: transport,
+		listener:  listener,
+		now:       time.Now,
+	}
+}
+
+// GetSelf returns this node's own membership
+func (m *Membership) GetSelf() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self
+}
+
+// Get returns a member by id
+func (m *Membership) Get(id string) (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == m.self.ID {
+		return m.self, true
+	}
+	if member, exist := m.members[id]; exist {
+		return *member, true
+	}
+	return Member{}, false
+}
+
+// Members returns every member including this node, sorted by id
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []Member{m.self}
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Join adds a member that contacted us itself and returns the incarnation it
+// should carry on with. A member restarting under the same id comes back
+// with a newer incarnation than the one we suspected or buried, as does one
+// that has moved to another address or zone.
+func (m *Membership) Join(id, address, zone string, incarnation uint64) uint64 {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if id == m.self.ID {
+		return m.self.Incarnation
+	}
+
+	if current, exist := m.members[id]; exist && incarnation <= current.Incarnation {
+		incarnation = current.Incarnation
+		if current.State != pbModel.ServerInfo_ALIVE || current.Address != address || current.Zone != zone {
+			incarnation++
+		}
+	}
+
+	m.merge(Member{
+		ID:          id,
+		Address:     address,
+		Zone:        zone,
+		State:       pbModel.ServerInfo_ALIVE,
+		Incarnation: incarnation,
+	})
+	return incarnation
+}
+
+// SetIncarnation moves this node on to at least the given incarnation
+func (m *Membership) SetIncarnation(incarnation uint64) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if incarnation > m.self.Incarnation {
+		m.self.Incarnation = incarnation
+		m.spread(m.self)
+	}
+}
+
+// Apply merges the membership updates gossiped by another member
+func (m *Membership) Apply(updates []*pbModel.ServerInfo) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	for _, u := range updates {
+		m.merge(FromProto(u))
+	}
+}
+
+// Updates returns the membership updates to piggyback on the next message
+func (m *Membership) Updates() []*pbModel.ServerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var updates []*pbModel.ServerInfo
+	remaining := m.rumours[:0]
+	for _, r := range m.rumours {
+		if len(updates) < maxPiggyback {
+			updates = append(updates, r.member.ToProto())
+			r.transmits--
+		}
+		if r.transmits > 0 {
+			remaining = append(remaining, r)
+		}
+	}
+	m.rumours = remaining
+	return updates
+}
+
+// ProbeDirect probes a member and merges the updates it answers with
+func (m *Membership) ProbeDirect(ctx context.Context, target Member) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.ProbeTimeout)
+	defer cancel()
+
+	updates, err := m.transport.Probe(ctx, target, m.Updates())
+	if err != nil {
+		return err
+	}
+	m.Apply(updates)
+	return nil
+}
+
+// Run probes a member every ProbeInterval until stop is closed
+func (m *Membership) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick runs one protocol period
+func (m *Membership) tick() {
+	m.expire()
+
+	target, ok := m.nextTarget()
+	if !ok {
+		return
+	}
+
+	if err := m.ProbeDirect(context.Background(), target); err == nil {
+		return
+	}
+
+	if m.probeIndirectly(target) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+	if current, exist := m.members[target.ID]; exist && current.State == pbModel.ServerInfo_ALIVE {
+		suspect := *current
+		suspect.State = pbModel.ServerInfo_SUSPECT
+		m.merge(suspect)
+	}
+}
+
+// probeIndirectly asks a few other members to probe the target, in case
+// it's only our link to it that is down
+func (m *Membership) probeIndirectly(target Member) bool {
+	m.mu.Lock()
+	var helpers []Member
+	for _, member := range m.members {
+		if member.ID != target.ID && member.State == pbModel.ServerInfo_ALIVE {
+			helpers = append(helpers, *member)
+		}
+	}
+	m.mu.Unlock()
+
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > m.config.IndirectProbes {
+		helpers = helpers[:m.config.IndirectProbes]
+	}
+
+	acks := make(chan bool, len(helpers))
+	for _, via := range helpers {
+		go func(via Member) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*m.config.ProbeTimeout)
+			defer cancel()
+
+			updates, err := m.transport.IndirectProbe(ctx, via, target, m.Updates())
+			if err == nil {
+				m.Apply(updates)
+			}
+			acks <- err == nil
+		}(via)
+	}
+
+	for range helpers {
+		if <-acks {
+			return true
+		}
+	}
+	return false
+}
+
+// nextTarget picks the member to probe, going round a shuffled list so each
+// member is probed once per round
+func (m *Membership) nextTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if len(m.probeOrder) == 0 {
+			for id, member := range m.members {
+				if member.State != pbModel.ServerInfo_DEAD {
+					m.probeOrder = append(m.probeOrder, id)
+				}
+			}
+			if len(m.probeOrder) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(m.probeOrder), func(i, j int) {
+				m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+			})
+		}
+
+		id := m.probeOrder[0]
+		m.probeOrder = m.probeOrder[1:]
+		if member, exist := m.members[id]; exist && member.State != pbModel.ServerInfo_DEAD {
+			return *member, true
+		}
+	}
+}
+
+// Evict declares a member dead without waiting for it to stop answering,
+// for taking a node out of the cluster by hand. A node that is still
+// running refutes it, so it has to be stopped first.
+func (m *Membership) Evict(id string) error {
+	m.mu.Lock()
+	defer m.unlock()
+
+	member, exist := m.members[id]
+	if !exist {
+		return errors.New("Member not found")
+	}
+
+	dead := *member
+	dead.State = pbModel.ServerInfo_DEAD
+	m.merge(dead)
+	return nil
+}
+
+// Leave tells the other members this node is leaving, declaring itself dead
+// at a new incarnation, so they hand over its share of the kegs right away
+// rather than suspecting it first. Members are told directly, as there
+// won't be any more probes to gossip it on.
+func (m *Membership) Leave(ctx context.Context) {
+	m.mu.Lock()
+	m.self.Incarnation++
+	m.self.State = pbModel.ServerInfo_DEAD
+	updates := []*pbModel.ServerInfo{m.self.ToProto()}
+
+	var targets []Member
+	for _, member := range m.members {
+		if member.State != pbModel.ServerInfo_DEAD {
+			targets = append(targets, *member)
+		}
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target Member) {
+			defer wg.Done()
+			m.transport.Probe(ctx, target, updates)
+		}(target)
+	}
+	wg.Wait()
+}
+
+// expire declares suspects that never refuted dead, and forgets members
+// that have been dead for long enough
+func (m *Membership) expire() {
+	m.mu.Lock()
+	defer m.unlock()
+
+	now := m.now()
+	for id, member := range m.members {
+		switch {
+		case member.State == pbModel.ServerInfo_SUSPECT && now.Sub(member.changed) > m.config.SuspicionTimeout:
+			dead := *member
+			dead.State = pbModel.ServerInfo_DEAD
+			m.merge(dead)
+		case member.State == pbModel.ServerInfo_DEAD && now.Sub(member.changed) > m.config.RemovalTimeout:
+			delete(m.members, id)
+			m.events = append(m.events, func() { m.listener.MemberRemoved(id) })
+		}
+	}
+}
+
+// merge applies a single update, spreading it further if it was news to us.
+// Must be called with the lock held.
+func (m *Membership) merge(u Member) {
+	if u.ID == m.self.ID {
+		// Refute anything that says we're not alive by moving on to a newer
+		// incarnation, which overrides it everywhere, unless we're leaving
+		if m.self.State == pbModel.ServerInfo_ALIVE &&
+			u.State != pbModel.ServerInfo_ALIVE && u.Incarnation >= m.self.Incarnation {
+			m.self.Incarnation = u.Incarnation + 1
+			m.spread(m.self)
+		}
+		return
+	}
+
+	current, exist := m.members[u.ID]
+	if !exist {
+		// There's nothing to gain from learning about members already dead
+		if u.State == pbModel.ServerInfo_DEAD {
+			return
+		}
+		current = &Member{}
+		m.members[u.ID] = current
+	} else if !supersedes(u, *current) {
+		return
+	}
+
+	u.changed = m.now()
+	*current = u
+	m.spread(u)
+	m.events = append(m.events, func() { m.listener.MemberChanged(u) })
+}
+
+// spread queues an update to be piggybacked on the next few messages
+func (m *Membership) spread(u Member) {
+	transmits := retransmitMult * int(math.Ceil(math.Log2(float64(len(m.members)+2))))
+
+	for _, r := range m.rumours {
+		if r.member.ID == u.ID {
+			r.member = u
+			r.transmits = transmits
+			return
+		}
+	}
+	m.rumours = append(m.rumours, &rumour{member: u, transmits: transmits})
+}
+
+// unlock releases the lock and only then tells the listener what changed,
+// so it is free to call back into the membership
+func (m *Membership) unlock() {
+	events := m.events
+	m.events = nil
+	m.mu.Unlock()
+
+	if m.listener == nil {
+		return
+	}
+	for _, event := range events {
+		event()
+	}
+}
+
+// supersedes reports whether an update overrides what we know of a member.
+// Newer incarnations win, and at the same incarnation suspicion overrides
+// being alive and death overrides both.
+func supersedes(u, current Member) bool {
+	switch u.State {
+	case pbModel.ServerInfo_ALIVE:
+		return u.Incarnation > current.Incarnation
+	case pbModel.ServerInfo_SUSPECT:
+		return u.Incarnation > current.Incarnation ||
+			(u.Incarnation == current.Incarnation && current.State == pbModel.ServerInfo_ALIVE)
+	case pbModel.ServerInfo_DEAD:
+		return u.Incarnation >= current.Incarnation && current.State != pbModel.ServerInfo_DEAD
+	}
+	return false
+}
+
+// ToProto returns the protobuf representation of the member
+func (member Member) ToProto() *pbModel.ServerInfo {
+	return &pbModel.ServerInfo{
+		ID:          member.ID,
+		Address:     member.Address,
+		Zone:        member.Zone,
+		State:       member.State,
+		Incarnation: member.Incarnation,
+	}
+}
+
+// FromProto converts a protobuf server info to a member
+func FromProto(si *pbModel.ServerInfo) Member {
+	return Member{
+		ID:          si.GetID(),
+		Address:     si.GetAddress(),
+		Zone:        si.GetZone(),
+		State:       si.GetState(),
+		Incarnation: si.GetIncarnation(),
+	}
+}
@@ -0,0 +1,242 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pbModel "kegr.io/protobuf/model/storage/server"
+)
+
+var testConfig = Config{
+	ProbeInterval:    time.Second,
+	ProbeTimeout:     time.Second,
+	IndirectProbes:   2,
+	SuspicionTimeout: 5 * time.Second,
+	RemovalTimeout:   time.Minute,
+}
+
+// network connects memberships in memory, any of which can be taken down
+type network struct {
+	nodes map[string]*Membership
+	down  map[string]bool
+	now   time.Time
+}
+
+func newNetwork(size int) *network {
+	n := &network{
+		nodes: make(map[string]*Membership),
+		down:  make(map[string]bool),
+		now:   time.Unix(0, 0),
+	}
+	for i := 0; i < size; i++ {
+		n.add(fmt.Sprintf("node-%d", i))
+	}
+	for id, m := range n.nodes {
+		for other := range n.nodes {
+			if other != id {
+				m.Join(other, other, "", 0)
+			}
+		}
+	}
+	return n
+}
+
+func (n *network) add(id string) *Membership {
+	m := New(id, id, "", testConfig, n, nil)
+	m.now = func() time.Time { return n.now }
+	n.nodes[id] = m
+	return m
+}
+
+func (n *network) Probe(ctx context.Context, target Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	if n.down[target.ID] {
+		return nil, errors.New("unreachable")
+	}
+	m := n.nodes[target.ID]
+	m.Apply(updates)
+	return m.Updates(), nil
+}
+
+func (n *network) IndirectProbe(ctx context.Context, via, target Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	if n.down[via.ID] {
+		return nil, errors.New("unreachable")
+	}
+	m := n.nodes[via.ID]
+	m.Apply(updates)
+	if err := m.ProbeDirect(ctx, target); err != nil {
+		return nil, err
+	}
+	return m.Updates(), nil
+}
+
+// rounds advances time a second at a time, running a protocol period on
+// every node that is up
+func (n *network) rounds(count int) {
+	for i := 0; i < count; i++ {
+		n.now = n.now.Add(time.Second)
+		for id, m := range n.nodes {
+			if !n.down[id] {
+				m.tick()
+			}
+		}
+	}
+}
+
+func (n *network) state(observer, id string) (pbModel.ServerInfo_State, bool) {
+	member, exist := n.nodes[observer].Get(id)
+	return member.State, exist
+}
+
+func TestMembershipDetectsFailure(t *testing.T) {
+	n := newNetwork(4)
+	n.down["node-3"] = true
+
+	n.rounds(20)
+	for _, observer := range []string{"node-0", "node-1", "node-2"} {
+		if state, _ := n.state(observer, "node-3"); state != pbModel.ServerInfo_DEAD {
+			t.Errorf("%v sees node-3 as %v", observer, state)
+		}
+	}
+
+	n.rounds(int(testConfig.RemovalTimeout / time.Second))
+	for _, observer := range []string{"node-0", "node-1", "node-2"} {
+		if _, exist := n.state(observer, "node-3"); exist {
+			t.Errorf("%v still lists node-3", observer)
+		}
+	}
+}
+
+func TestMembershipRefutesSuspicion(t *testing.T) {
+	n := newNetwork(3)
+	n.nodes["node-0"].Apply([]*pbModel.ServerInfo{{
+		ID:      "node-1",
+		Address: "node-1",
+		State:   pbModel.ServerInfo_SUSPECT,
+	}})
+
+	n.rounds(10)
+	for _, observer := range []string{"node-0", "node-2"} {
+		member, _ := n.nodes[observer].Get("node-1")
+		if member.State != pbModel.ServerInfo_ALIVE || member.Incarnation == 0 {
+			t.Errorf("%v sees node-1 as %v at incarnation %d", observer, member.State, member.Incarnation)
+		}
+	}
+}
+
+func TestMembershipRejoin(t *testing.T) {
+	n := newNetwork(3)
+	n.down["node-2"] = true
+	n.rounds(20)
+
+	if state, _ := n.state("node-0", "node-2"); state != pbModel.ServerInfo_DEAD {
+		t.Fatalf("node-2 should be dead, is %v", state)
+	}
+
+	// node-2 restarts with the same id and registers with node-0, which
+	// answers with the incarnation to use and the members it knows of
+	delete(n.down, "node-2")
+	restarted := n.add("node-2")
+	restarted.SetIncarnation(n.nodes["node-0"].Join("node-2", "node-2", "", 0))
+	for _, member := range n.nodes["node-0"].Members() {
+		restarted.Apply([]*pbModel.ServerInfo{member.ToProto()})
+	}
+
+	n.rounds(10)
+	for _, observer := range []string{"node-0", "node-1"} {
+		if state, _ := n.state(observer, "node-2"); state != pbModel.ServerInfo_ALIVE {
+			t.Errorf("%v sees node-2 as %v", observer, state)
+		}
+	}
+	if _, exist := n.state("node-2", "node-1"); !exist {
+		t.Error("node-2 didn't learn about node-1")
+	}
+}
+
+func TestMembershipZoneChange(t *testing.T) {
+	n := newNetwork(3)
+
+	// node-2 restarts in another rack, which everyone has to learn of
+	restarted := New("node-2", "node-2", "rack-1", testConfig, n, nil)
+	restarted.now = func() time.Time { return n.now }
+	n.nodes["node-2"] = restarted
+
+	incarnation := n.nodes["node-0"].Join("node-2", "node-2", "rack-1", 0)
+	if incarnation == 0 {
+		t.Fatal("expected moving zones to take a new incarnation")
+	}
+	restarted.SetIncarnation(incarnation)
+	restarted.Apply([]*pbModel.ServerInfo{{ID: "node-1", Address: "node-1"}})
+
+	n.rounds(10)
+	if member, _ := n.nodes["node-1"].Get("node-2"); member.Zone != "rack-1" {
+		t.Errorf("node-1 sees node-2 in zone %q", member.Zone)
+	}
+}
+
+func TestMembershipEvict(t *testing.T) {
+	n := newNetwork(3)
+	n.down["node-2"] = true
+
+	if err := n.nodes["node-0"].Evict("node-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Well before a suspicion could time out, the eviction has been gossiped
+	n.rounds(2)
+	for _, observer := range []string{"node-0", "node-1"} {
+		if state, _ := n.state(observer, "node-2"); state != pbModel.ServerInfo_DEAD {
+			t.Errorf("%v sees node-2 as %v", observer, state)
+		}
+	}
+
+	if err := n.nodes["node-0"].Evict("node-9"); err == nil {
+		t.Error("expected evicting an unknown member to fail")
+	}
+}
+
+func TestMembershipLeave(t *testing.T) {
+	n := newNetwork(3)
+	n.nodes["node-2"].Leave(context.Background())
+	n.down["node-2"] = true
+
+	for _, observer := range []string{"node-0", "node-1"} {
+		if state, _ := n.state(observer, "node-2"); state != pbModel.ServerInfo_DEAD {
+			t.Errorf("%v sees node-2 as %v", observer, state)
+		}
+	}
+
+	// Hearing of its departure again doesn't make it refute it
+	n.nodes["node-2"].Apply(n.nodes["node-0"].Updates())
+	if self := n.nodes["node-2"].GetSelf(); self.State != pbModel.ServerInfo_DEAD {
+		t.Errorf("node-2 refuted leaving, it's %v", self.State)
+	}
+}
+
+func TestSupersedes(t *testing.T) {
+	alive := Member{State: pbModel.ServerInfo_ALIVE, Incarnation: 1}
+	suspect := Member{State: pbModel.ServerInfo_SUSPECT, Incarnation: 1}
+	dead := Member{State: pbModel.ServerInfo_DEAD, Incarnation: 1}
+	newer := Member{State: pbModel.ServerInfo_ALIVE, Incarnation: 2}
+
+	cases := []struct {
+		u, current Member
+		expected   bool
+	}{
+		{suspect, alive, true},
+		{alive, suspect, false},
+		{newer, suspect, true},
+		{dead, suspect, true},
+		{suspect, dead, false},
+		{newer, dead, true},
+		{dead, newer, false},
+	}
+
+	for i, c := range cases {
+		if supersedes(c.u, c.current) != c.expected {
+			t.Errorf("case %d: expected %v", i, c.expected)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	"kegr.io/storage_controller/util"
+)
+
+// Remote is a tree held by a peer. Rather than shipping the whole tree it
+// is inspected a few levels at a time, and only the content of the leaves
+// that differ is ever transferred.
+type Remote interface {
+	GetNodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error)
+	GetLeaves(paths []string) ([]IContent, error)
+}
+
+// NodeHashes returns the hashes of the nodes depth levels below path. Paths
+// have one character per level, '1' for the left branch and '0' for the
+// right, matching the bits of the content IDs stored below them.
+func (t *Tree) NodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	n, err := t.nodeAt(path)
+	if err != nil || n == nil {
+		return nil, err
+	}
+
+	if depth > t.depth-len(path) {
+		depth = t.depth - len(path)
+	}
+
+	var hashes []*pbMerkle.NodeHash
+	n.walk(path, depth, func(path string, n *node) {
+		hashes = append(hashes, &pbMerkle.NodeHash{
+			Path: path,
+			Hash: n.getHash(),
+		})
+	})
+	return hashes, nil
+}
+
+// Leaves returns all content stored below the given paths
+func (t *Tree) Leaves(paths []string) ([]IContent, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var content []IContent
+	for _, path := range paths {
+		n, err := t.nodeAt(path)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			content = append(content, n.getAllContent()...)
+		}
+	}
+	return content, nil
+}
+
+// DiffRemote is Diff against a peer's tree whose root hash is already known
+// to differ. The peer's hashes are requested step levels at a time, only
+// below branches that don't match, so the traffic grows with the size of
+// the difference rather than the size of the tree. The tree is only locked
+// while comparing, never while waiting on the peer.
+func (t *Tree) DiffRemote(remote Remote, step int) ([]IContent, error) {
+	if step < 1 {
+		step = 1
+	}
+
+	var fetch []string
+	pending := []string{""}
+
+	for len(pending) > 0 {
+		path := pending[0]
+		pending = pending[1:]
+
+		hashes, err := remote.GetNodeHashes(path, step)
+		if err != nil {
+			return nil, err
+		}
+
+		mismatched, missing, err := t.compareHashes(path, hashes)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, mismatched...)
+		fetch = append(fetch, missing...)
+	}
+
+	if len(fetch) == 0 {
+		return nil, nil
+	}
+
+	content, err := remote.GetLeaves(fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var diff []IContent
+	for _, other := range content {
+		if local, exist := t.find(other.GetID()); !exist || newer(local, other) {
+			diff = append(diff, other)
+		}
+	}
+	return diff, nil
+}
+
+// compareHashes splits a peer's node hashes that differ from ours into the
+// branches to descend into and the leaves, or branches we don't have, whose
+// content has to be fetched. The peer's tree may be of another depth: its
+// nodes below our leaves are fetched whole, as are its leaves, which are
+// the nodes it returns no deeper than the path requested.
+func (t *Tree) compareHashes(requested string, hashes []*pbMerkle.NodeHash) ([]string, []string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var mismatched, missing []string
+	for _, h := range hashes {
+		if len(h.GetPath()) > t.depth {
+			missing = append(missing, h.GetPath())
+			continue
+		}
+
+		local, err := t.nodeAt(h.GetPath())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case local != nil && bytes.Equal(local.getHash(), h.GetHash()):
+		case local == nil || len(h.GetPath()) >= t.depth || len(h.GetPath()) <= len(requested):
+			missing = append(missing, h.GetPath())
+		default:
+			mismatched = append(mismatched, h.GetPath())
+		}
+	}
+	return mismatched, missing, nil
+}
+
+// ContentFromProto fills a new content object from its proto
+func ContentFromProto(c *pbMerkle.Content, newContentObject func() IContent) IContent {
+	content := newContentObject()
+	content.SetID(c.GetID())
+	content.SetHash(c.GetHash())
+	content.SetLastUpdated(c.GetLastUpdated())
+	content.SetUpdatedBy(c.GetUpdatedBy())
+	return content
+}
+
+// nodeAt returns the node at path or nil if there is none
+func (t *Tree) nodeAt(path string) (*node, error) {
+	if len(path) > t.depth || strings.Trim(path, "01") != "" {
+		return nil, errors.New("Invalid merkle tree path")
+	}
+
+	current := t.rootNode
+	for i := 0; i < len(path) && current != nil; i++ {
+		if path[i] == '1' {
+			current = current.left
+		} else {
+			current = current.right
+		}
+	}
+	return current, nil
+}
+
+// find returns the content with that id if it's in the tree
+func (t *Tree) find(id []byte) (IContent, bool) {
+	n := t.leafNode(id)
+	if n == nil {
+		return nil, false
+	}
+	return n.leaf.Get(id)
+}
+
+// leafNode returns the node whose leaf would hold the content with that id,
+// or nil if there is none
+func (t *Tree) leafNode(id []byte) *node {
+	current := t.rootNode
+	for depth := 0; depth < t.depth && current != nil; depth++ {
+		b, err := util.GetBitFromByteArray(depth, id)
+		if err != nil {
+			return nil
+		}
+
+		if b == 1 {
+			current = current.left
+		} else {
+			current = current.right
+		}
+	}
+
+	if current == nil || current.leaf == nil {
+		return nil
+	}
+	return current
+}
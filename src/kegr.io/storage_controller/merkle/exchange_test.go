@@ -0,0 +1,125 @@
+package merkle
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+)
+
+type exchangeContent struct {
+	id          []byte
+	hash        []byte
+	lastUpdated int64
+	updatedBy   string
+}
+
+func newExchangeContent(i int, version string, lastUpdated int64) *exchangeContent {
+	id := sha1.Sum([]byte(fmt.Sprint(i)))
+	hash := sha1.Sum([]byte(fmt.Sprintf("%d-%s", i, version)))
+	return &exchangeContent{
+		id:          id[:],
+		hash:        hash[:],
+		lastUpdated: lastUpdated,
+	}
+}
+
+func (c *exchangeContent) GetID() []byte                    { return c.id }
+func (c *exchangeContent) SetID(id []byte)                  { c.id = id }
+func (c *exchangeContent) GetHash() []byte                  { return c.hash }
+func (c *exchangeContent) SetHash(hash []byte)              { c.hash = hash }
+func (c *exchangeContent) GetLastUpdated() int64            { return c.lastUpdated }
+func (c *exchangeContent) SetLastUpdated(lastUpdated int64) { c.lastUpdated = lastUpdated }
+func (c *exchangeContent) GetUpdatedBy() string             { return c.updatedBy }
+func (c *exchangeContent) SetUpdatedBy(updatedBy string)    { c.updatedBy = updatedBy }
+func (c *exchangeContent) GetProto() *pbMerkle.Content {
+	return &pbMerkle.Content{ID: c.id, Hash: c.hash, LastUpdated: c.lastUpdated, UpdatedBy: c.updatedBy}
+}
+
+// countingRemote serves a local tree as a peer would and keeps track of
+// how much of it was transferred
+type countingRemote struct {
+	tree   ITree
+	hashes int
+	leaves int
+}
+
+func (r *countingRemote) GetNodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error) {
+	hashes, err := r.tree.NodeHashes(path, depth)
+	r.hashes += len(hashes)
+	return hashes, err
+}
+
+func (r *countingRemote) GetLeaves(paths []string) ([]IContent, error) {
+	content, err := r.tree.Leaves(paths)
+	r.leaves += len(content)
+	return content, err
+}
+
+func exchangeTrees(items int) (ITree, ITree) {
+	local, other := NewTree(treeDepth), NewTree(treeDepth)
+	for i := 0; i < items; i++ {
+		local.Add(newExchangeContent(i, "a", 1))
+		other.Add(newExchangeContent(i, "a", 1))
+	}
+	return local, other
+}
+
+func TestTreeDiffRemoteOnlyDivergentLeaves(t *testing.T) {
+	local, other := exchangeTrees(treeTestItems)
+	other.Update(newExchangeContent(7, "b", 2))
+	other.Add(newExchangeContent(treeTestItems, "a", 2))
+
+	remote := &countingRemote{tree: other}
+	diff, err := local.DiffRemote(remote, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 2 {
+		t.Errorf("expected 2 differences got %d", len(diff))
+	}
+	if remote.leaves != 2 {
+		t.Errorf("expected only 2 leaves to be transferred got %d", remote.leaves)
+	}
+	if remote.hashes > 2*4*16 {
+		t.Errorf("transferred %d hashes for 2 differences", remote.hashes)
+	}
+}
+
+func TestTreeDiffRemoteKeepsNewerLocal(t *testing.T) {
+	local, other := exchangeTrees(16)
+	local.Update(newExchangeContent(3, "b", 3))
+	other.Update(newExchangeContent(3, "c", 2))
+
+	diff, err := local.DiffRemote(&countingRemote{tree: other}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("expected no differences got %d", len(diff))
+	}
+}
+
+func TestTreeDiffRemoteMatchesDiff(t *testing.T) {
+	local, other := exchangeTrees(0)
+	for i := 0; i < treeTestItems; i++ {
+		other.Add(newExchangeContent(i, "a", 1))
+	}
+
+	diff, err := local.DiffRemote(&countingRemote{tree: other}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != len(local.Diff(other)) {
+		t.Errorf("expected %d differences got %d", len(local.Diff(other)), len(diff))
+	}
+}
+
+func TestTreeNodeHashesInvalidPath(t *testing.T) {
+	tree := NewTree(treeDepth)
+	if _, err := tree.NodeHashes("012", 1); err == nil {
+		t.Error("expected an error for an invalid path")
+	}
+}
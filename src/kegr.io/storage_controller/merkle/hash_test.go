@@ -0,0 +1,110 @@
+package merkle
+
+import (
+	"bytes"
+	"testing"
+)
+
+// freshHash hashes a tree from scratch, ignoring every cached hash
+func freshHash(t ITree) []byte {
+	return FromProto(t.ToProto(), func() IContent { return &exchangeContent{} }).Hash()
+}
+
+func TestTreeCachedHashFollowsChanges(t *testing.T) {
+	tree := NewTree(treeDepth)
+	for i := 0; i < treeTestItems; i++ {
+		tree.Add(newExchangeContent(i, "a", 1))
+	}
+	if !bytes.Equal(tree.Hash(), freshHash(tree)) {
+		t.Fatal("expected the cached hash to match after adds")
+	}
+
+	before := tree.Hash()
+	tree.Update(newExchangeContent(7, "b", 2))
+	if bytes.Equal(tree.Hash(), before) || !bytes.Equal(tree.Hash(), freshHash(tree)) {
+		t.Error("expected the cached hash to follow an update")
+	}
+
+	for i := 0; i < treeTestItems/2; i++ {
+		if err := tree.Delete(newExchangeContent(i, "a", 1).GetID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(tree.Hash(), freshHash(tree)) {
+		t.Error("expected the cached hash to follow deletes")
+	}
+}
+
+func TestLeafCachedHashFollowsChanges(t *testing.T) {
+	leaf := NewLeaf()
+	var added []*C
+	for i := 0; i < leafTestItems; i++ {
+		c := newC()
+		leaf.Add(c)
+		added = append(added, c)
+		leaf.rehash()
+	}
+	if !bytes.Equal(leaf.GetHash(), leaf.computeHash()) {
+		t.Fatal("expected the cached hash to match after adds")
+	}
+
+	before := leaf.GetHash()
+	leaf.Add(newC())
+	if bytes.Equal(leaf.GetHash(), before) || !bytes.Equal(leaf.GetHash(), leaf.computeHash()) {
+		t.Error("expected the cached hash to follow an add")
+	}
+
+	leaf.rehash()
+	leaf.Delete(added[0].GetID())
+	if !bytes.Equal(leaf.GetHash(), leaf.computeHash()) {
+		t.Error("expected the cached hash to follow a delete")
+	}
+}
+
+func TestAdaptiveTreeDepth(t *testing.T) {
+	grown, shuffled := NewAdaptiveTree(), NewAdaptiveTree()
+	if grown.Depth() != minDepth {
+		t.Errorf("expected an empty tree at depth %v, got %v", minDepth, grown.Depth())
+	}
+
+	for i := 0; i < treeTestItems; i++ {
+		grown.Add(newExchangeContent(i, "a", 1))
+	}
+	for i := treeTestItems - 1; i >= 0; i-- {
+		shuffled.Add(newExchangeContent(i, "a", 1))
+	}
+
+	if grown.Depth() != depthFor(treeTestItems) || grown.Depth() <= minDepth {
+		t.Errorf("expected the tree to grow to depth %v, got %v", depthFor(treeTestItems), grown.Depth())
+	}
+	if !bytes.Equal(grown.Hash(), shuffled.Hash()) || !bytes.Equal(grown.Hash(), freshHash(grown)) {
+		t.Error("expected the same content to hash the same whatever the order it was added in")
+	}
+
+	for i := 0; i < treeTestItems; i++ {
+		grown.Delete(newExchangeContent(i, "a", 1).GetID())
+	}
+	if grown.Depth() != minDepth || !bytes.Equal(grown.Hash(), NewAdaptiveTree().Hash()) {
+		t.Error("expected an emptied tree to shrink back")
+	}
+}
+
+func TestTreeDiffRemoteAcrossDepths(t *testing.T) {
+	for _, depths := range [][2]int{{minDepth, treeDepth}, {treeDepth, minDepth}} {
+		local, other := NewTree(depths[0]), NewTree(depths[1])
+		for i := 0; i < treeTestItems; i++ {
+			local.Add(newExchangeContent(i, "a", 1))
+			other.Add(newExchangeContent(i, "a", 1))
+		}
+		other.Update(newExchangeContent(7, "b", 2))
+		other.Add(newExchangeContent(treeTestItems, "a", 2))
+
+		diff, err := local.DiffRemote(&countingRemote{tree: other}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diff) != 2 {
+			t.Errorf("expected 2 differences from depth %v to %v, got %v", depths[0], depths[1], len(diff))
+		}
+	}
+}
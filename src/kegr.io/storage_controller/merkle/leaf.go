@@ -2,13 +2,15 @@ package merkle
 
 import (
 	"bytes"
-	"crypto/md5"
+	"crypto/sha256"
 	"sort"
 
 	pbMerkle "kegr.io/protobuf/model/merkle"
+	"kegr.io/storage_controller/clock"
 )
 
-// Leaf is the struct that holds the actual items in the merkle tree
+// Leaf is the struct that holds the actual items in the merkle tree. Its
+// hash is cached, and dropped whenever its items change.
 type Leaf struct {
 	content map[string]IContent
 	hash    []byte
@@ -21,6 +23,8 @@ type IContent interface {
 	SetHash(hash []byte)
 	GetLastUpdated() int64
 	SetLastUpdated(lastUpdated int64)
+	GetUpdatedBy() string
+	SetUpdatedBy(updatedBy string)
 	GetProto() *pbMerkle.Content
 }
 
@@ -31,24 +35,37 @@ func NewLeaf() *Leaf {
 	}
 }
 
-// GetHash returns the has of the contents of the leaf
+// GetHash returns the hash of the contents of the leaf
 func (l *Leaf) GetHash() []byte {
+	if l.hash == nil {
+		return l.computeHash()
+	}
+	return l.hash
+}
+
+func (l *Leaf) computeHash() []byte {
 	var sortedIds []string
 	for id := range l.content {
 		sortedIds = append(sortedIds, id)
 	}
 	sort.Strings(sortedIds)
 
-	hash := md5.New()
+	hash := sha256.New()
 	for _, key := range sortedIds {
 		hash.Write(l.content[key].GetHash())
 	}
 	return hash.Sum(nil)
 }
 
+// rehash caches the hash of the leaf's current contents
+func (l *Leaf) rehash() {
+	l.hash = l.computeHash()
+}
+
 // Add inserts a new item in the leaf's content
 func (l *Leaf) Add(item IContent) {
 	l.content[string(item.GetID())] = item
+	l.hash = nil
 }
 
 // GetContent returns the content of the leaf
@@ -75,6 +92,7 @@ func (l *Leaf) Delete(id []byte) {
 		return
 	}
 	delete(l.content, string(id))
+	l.hash = nil
 }
 
 func (l *Leaf) isEmpty() bool {
@@ -85,28 +103,30 @@ func (l *Leaf) diff(other *Leaf) []IContent {
 	if bytes.Equal(l.GetHash(), other.GetHash()) {
 		return nil
 	}
-	var diff []IContent
-
-	for lk, lv := range l.content {
-		if ov, exist := other.content[lk]; exist {
-			if !bytes.Equal(lv.GetHash(), ov.GetHash()) && ov.GetLastUpdated() > lv.GetLastUpdated() {
-				diff = append(diff, ov)
-			}
-		}
-	}
+	return diffContent(l.content, other.GetContent())
+}
 
-	for ok, ov := range other.content {
-		if _, exist := l.content[ok]; !exist {
+// diffContent returns the other items that are missing from local, or are
+// later versions of the local ones
+func diffContent(local map[string]IContent, other []IContent) []IContent {
+	var diff []IContent
+	for _, ov := range other {
+		if lv, exist := local[string(ov.GetID())]; !exist || newer(lv, ov) {
 			diff = append(diff, ov)
 		}
 	}
-
 	return diff
 }
 
+// newer reports whether other is a later version of local
+func newer(local, other IContent) bool {
+	return !bytes.Equal(local.GetHash(), other.GetHash()) &&
+		clock.Newer(other.GetLastUpdated(), other.GetUpdatedBy(), local.GetLastUpdated(), local.GetUpdatedBy())
+}
+
 func (l *Leaf) toProto() *pbMerkle.Leaf {
 	pbLeaf := &pbMerkle.Leaf{
-		Hash: l.hash,
+		Hash: l.GetHash(),
 	}
 
 	pbContent := make(map[string]*pbMerkle.Content)
@@ -128,10 +148,7 @@ func leafFromProto(l *pbMerkle.Leaf, newContentObject func() IContent) *Leaf {
 	content := make(map[string]IContent)
 
 	for k, v := range l.GetContent() {
-		content[k] = newContentObject()
-		content[k].SetID(v.GetID())
-		content[k].SetHash(v.GetHash())
-		content[k].SetLastUpdated(v.GetLastUpdated())
+		content[k] = ContentFromProto(v, newContentObject)
 	}
 
 	ll.content = content
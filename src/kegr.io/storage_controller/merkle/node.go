@@ -2,14 +2,14 @@ package merkle
 
 import (
 	"bytes"
-	"crypto/md5"
-	"log"
+	"crypto/sha256"
 
 	pbMerkle "kegr.io/protobuf/model/merkle"
 )
 
 // Node is the stucture that defines a single node in the
-// merkle tree
+// merkle tree. Its hash is cached, and recomputed along with its parents'
+// whenever something below it changes.
 type node struct {
 	parent *node
 	left   *node
@@ -18,9 +18,17 @@ type node struct {
 	leaf   *Leaf
 }
 
-// Hash computes the sum of the hashes of the node's children
+// getHash returns the hash of the node
 func (n *node) getHash() []byte {
-	hash := md5.New()
+	if n.hash == nil {
+		return n.computeHash()
+	}
+	return n.hash
+}
+
+// computeHash computes the sum of the hashes of the node's children
+func (n *node) computeHash() []byte {
+	hash := sha256.New()
 	if n.left == nil && n.right == nil && n.leaf != nil {
 		hash.Write(n.leaf.GetHash())
 	} else {
@@ -34,19 +42,29 @@ func (n *node) getHash() []byte {
 	return hash.Sum(nil)
 }
 
+// recomputeHash updates the cached hashes of the node and every node above
+// it, after a change to the node's leaf or children
 func (n *node) recomputeHash() {
-	hash := md5.New()
-	if n.left == nil && n.right == nil && n.leaf != nil {
-		hash.Write(n.leaf.GetHash())
-	} else {
-		if n.left != nil {
-			hash.Write(n.left.getHash())
-		}
-		if n.right != nil {
-			hash.Write(n.right.getHash())
+	for current := n; current != nil; current = current.parent {
+		if current.leaf != nil {
+			current.leaf.rehash()
 		}
+		current.hash = current.computeHash()
 	}
-	n.hash = hash.Sum(nil)
+}
+
+// rehashAll computes the cached hashes of every node below this one
+func (n *node) rehashAll() {
+	if n.left != nil {
+		n.left.rehashAll()
+	}
+	if n.right != nil {
+		n.right.rehashAll()
+	}
+	if n.leaf != nil {
+		n.leaf.rehash()
+	}
+	n.hash = n.computeHash()
 }
 
 // ID dummy function to fulfil interface. NOT USED
@@ -71,8 +89,16 @@ func (n *node) diff(other *node) []IContent {
 
 	if n.isLeaf() && other.isLeaf() {
 		return n.leaf.diff(other.leaf)
-	} else if n.isLeaf() || other.isLeaf() {
-		log.Println("merkle tree inconsistency")
+	}
+
+	// Trees of different depths end at different levels, so the content
+	// below where either ends is compared as a whole
+	if n.isLeaf() || other.isLeaf() {
+		local := make(map[string]IContent)
+		for _, c := range n.getAllContent() {
+			local[string(c.GetID())] = c
+		}
+		return diffContent(local, other.getAllContent())
 	}
 
 	if n.left == nil && other.left != nil {
@@ -111,6 +137,23 @@ func (n *node) getAllContent() []IContent {
 	return content
 }
 
+// walk calls fn for every node depth levels below this one, along with
+// its path
+func (n *node) walk(path string, depth int, fn func(path string, n *node)) {
+	if depth == 0 {
+		fn(path, n)
+		return
+	}
+
+	if n.left != nil {
+		n.left.walk(path+"1", depth-1, fn)
+	}
+
+	if n.right != nil {
+		n.right.walk(path+"0", depth-1, fn)
+	}
+}
+
 func (n *node) toProto() *pbMerkle.Node {
 	pbNode := &pbMerkle.Node{
 		Hash: n.getHash(),
@@ -137,10 +180,12 @@ func nodeFromProto(n *pbMerkle.Node, newContentObject func() IContent) *node {
 
 	if n.GetLeft() != nil {
 		nn.left = nodeFromProto(n.GetLeft(), newContentObject)
+		nn.left.parent = nn
 	}
 
 	if n.GetRight() != nil {
 		nn.right = nodeFromProto(n.GetRight(), newContentObject)
+		nn.right.parent = nn
 	}
 
 	if n.GetLeaf() != nil {
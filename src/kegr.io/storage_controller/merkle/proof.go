@@ -0,0 +1,98 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+)
+
+// Prove returns the authentication path from the content with that id to
+// the root of the tree, which the proof carries as it was when proving
+func (t *Tree) Prove(id []byte) (*pbMerkle.Proof, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	n := t.leafNode(id)
+	if n == nil {
+		return nil, errors.New("ID not found in merkle tree")
+	}
+	if _, exist := n.leaf.Get(id); !exist {
+		return nil, errors.New("ID not found in merkle tree")
+	}
+
+	// The leaf is hashed over its content ordered by ID
+	var ids []string
+	for key := range n.leaf.content {
+		ids = append(ids, key)
+	}
+	sort.Strings(ids)
+
+	proof := &pbMerkle.Proof{
+		Root: t.rootNode.getHash(),
+	}
+	for i, key := range ids {
+		if key == string(id) {
+			proof.Index = uint32(i)
+		}
+		proof.Leaf = append(proof.Leaf, n.leaf.content[key].GetHash())
+	}
+
+	for current := n; current.parent != nil; current = current.parent {
+		step := &pbMerkle.ProofStep{
+			Left: current.parent.left == current,
+		}
+		if step.Left && current.parent.right != nil {
+			step.Sibling = current.parent.right.getHash()
+		} else if !step.Left && current.parent.left != nil {
+			step.Sibling = current.parent.left.getHash()
+		}
+		proof.Path = append(proof.Path, step)
+	}
+	return proof, nil
+}
+
+// VerifyProof checks that a proof leads from a content entry's hash to the
+// root it carries. It doesn't tell whether that root is the one to trust.
+func VerifyProof(hash []byte, proof *pbMerkle.Proof) error {
+	if int(proof.GetIndex()) >= len(proof.GetLeaf()) || !bytes.Equal(proof.GetLeaf()[proof.GetIndex()], hash) {
+		return errors.New("Proof doesn't include the content")
+	}
+
+	leaf := sha256.New()
+	for _, h := range proof.GetLeaf() {
+		leaf.Write(h)
+	}
+	current := sha256.Sum256(leaf.Sum(nil))
+
+	for _, step := range proof.GetPath() {
+		node := sha256.New()
+		if step.GetLeft() {
+			node.Write(current[:])
+			node.Write(step.GetSibling())
+		} else {
+			node.Write(step.GetSibling())
+			node.Write(current[:])
+		}
+		copy(current[:], node.Sum(nil))
+	}
+
+	if !bytes.Equal(current[:], proof.GetRoot()) {
+		return errors.New("Proof doesn't lead to its root")
+	}
+	return nil
+}
+
+// RootMessage returns what is signed to vouch for the root hash of a keg's
+// tree at a point in time
+func RootMessage(kegID string, hash []byte, signed int64) []byte {
+	message := sha256.New()
+	message.Write([]byte(kegID))
+	message.Write([]byte{0})
+	message.Write(hash)
+	binary.Write(message, binary.BigEndian, signed)
+	return message.Sum(nil)
+}
@@ -0,0 +1,82 @@
+package merkle
+
+import (
+	"testing"
+)
+
+func TestTreeProve(t *testing.T) {
+	for _, tree := range []ITree{NewTree(treeDepth), NewAdaptiveTree()} {
+		for i := 0; i < treeTestItems; i++ {
+			tree.Add(newExchangeContent(i, "a", 1))
+		}
+
+		for _, i := range []int{0, 7, treeTestItems - 1} {
+			content := newExchangeContent(i, "a", 1)
+			proof, err := tree.Prove(content.GetID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = VerifyProof(content.GetHash(), proof); err != nil {
+				t.Errorf("expected the proof of %v to verify: %v", i, err)
+			}
+			if string(proof.GetRoot()) != string(tree.Hash()) {
+				t.Error("expected the proof to lead to the tree's root")
+			}
+
+			// Another version of the content doesn't verify
+			if err = VerifyProof(newExchangeContent(i, "b", 2).GetHash(), proof); err == nil {
+				t.Errorf("expected another version of %v not to verify", i)
+			}
+		}
+
+		// Nor does a proof whose path was tampered with
+		content := newExchangeContent(7, "a", 1)
+		proof, _ := tree.Prove(content.GetID())
+		for _, step := range proof.GetPath() {
+			if len(step.GetSibling()) > 0 {
+				step.Sibling[0] ^= 1
+				break
+			}
+		}
+		if err := VerifyProof(content.GetHash(), proof); err == nil {
+			t.Error("expected a tampered proof not to verify")
+		}
+
+		if _, err := tree.Prove(newExchangeContent(treeTestItems, "a", 1).GetID()); err == nil {
+			t.Error("expected no proof of missing content")
+		}
+	}
+}
+
+func TestTreeProveAfterChanges(t *testing.T) {
+	tree := setup()
+	var added []*C
+	for i := 0; i < treeTestItems; i++ {
+		c := newC()
+		tree.Add(c)
+		added = append(added, c)
+	}
+
+	c := added[0]
+	before, err := tree.Prove(c.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree.Add(newC())
+	after, err := tree.Prove(c.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyProof(c.GetHash(), after); err != nil {
+		t.Errorf("expected the proof to verify after an add: %v", err)
+	}
+	if string(before.GetRoot()) == string(tree.Hash()) || string(after.GetRoot()) != string(tree.Hash()) {
+		t.Error("expected the proof to follow the tree's root")
+	}
+
+	tree.Delete(c.GetID())
+	if _, err = tree.Prove(c.GetID()); err == nil {
+		t.Error("expected no proof of deleted content")
+	}
+}
@@ -3,15 +3,34 @@ package merkle
 import (
 	"bytes"
 	"errors"
+	"sync"
 
 	pbMerkle "kegr.io/protobuf/model/merkle"
 	"kegr.io/storage_controller/util"
 )
 
-// Tree is the main structure for merkle trees
+const (
+	// HashVersion identifies how trees are hashed. Peers only compare trees
+	// hashed the same way, version 1 being MD5 over trees of a fixed depth.
+	HashVersion = 2
+
+	// Adaptive trees grow a level deeper whenever their leaves hold more
+	// than leafSize items on average
+	leafSize = 16
+	minDepth = 4
+	maxDepth = 20
+)
+
+// Tree is the main structure for merkle trees. It's safe for concurrent
+// use, reads share the tree while changes to it are exclusive. The hashes
+// of the nodes are kept up to date as content changes, so hashing the tree
+// doesn't depend on its size.
 type Tree struct {
+	mu       sync.RWMutex
 	rootNode *node
 	depth    int
+	adaptive bool
+	count    int
 }
 
 // ITree is the tree interface
@@ -20,32 +39,74 @@ type ITree interface {
 	Update(IContent) error
 	Delete([]byte) error
 	Hash() []byte
+	Depth() int
 	Diff(ITree) []IContent
+	DiffRemote(remote Remote, step int) ([]IContent, error)
+	Prove(id []byte) (*pbMerkle.Proof, error)
+	NodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error)
+	Leaves(paths []string) ([]IContent, error)
 	ToProto() *pbMerkle.Tree
 
 	getNode() *node
 }
 
-// NewTree initialises a new Merkle tree and returns the object
+// NewTree initialises a new Merkle tree of a fixed depth and returns the
+// object
 func NewTree(depth int) ITree {
 	t := &Tree{
 		depth:    depth,
 		rootNode: &node{},
 	}
+	t.rootNode.rehashAll()
 
 	return t
 }
 
+// NewAdaptiveTree initialises a new Merkle tree whose depth follows how
+// much it holds. It only depends on the number of items, so trees with the
+// same content have the same shape and hash.
+func NewAdaptiveTree() ITree {
+	t := NewTree(depthFor(0)).(*Tree)
+	t.adaptive = true
+	return t
+}
+
+// depthFor returns the depth an adaptive tree holding count items takes
+func depthFor(count int) int {
+	depth := minDepth
+	for depth < maxDepth && count > leafSize<<uint(depth) {
+		depth++
+	}
+	return depth
+}
+
 // Add adds a content object to the Merkle tree
 func (t *Tree) Add(content IContent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, err := t.insert(t.rootNode, t.depth, content)
+	if err != nil {
+		return err
+	}
+
+	if !t.resize() {
+		n.recomputeHash()
+	}
+	return nil
+}
+
+// insert adds a content object below root without updating any hashes,
+// returning the node whose leaf holds it
+func (t *Tree) insert(root *node, depth int, content IContent) (*node, error) {
 	id := content.GetID()
 	var err error
 
-	current := t.rootNode
-	for depth := 0; depth < t.depth; depth++ {
+	current := root
+	for level := 0; level < depth; level++ {
 		var b byte
-		if b, err = util.GetBitFromByteArray(depth, id); err != nil {
-			return errors.New("Error inserting")
+		if b, err = util.GetBitFromByteArray(level, id); err != nil {
+			return nil, errors.New("Error inserting")
 		}
 
 		if b == 1 {
@@ -67,12 +128,41 @@ func (t *Tree) Add(content IContent) error {
 		current.leaf = NewLeaf()
 	}
 
+	if _, exist := current.leaf.Get(id); !exist {
+		t.count++
+	}
 	current.leaf.Add(content)
-	return nil
+	return current, nil
+}
+
+// resize rebuilds an adaptive tree at the depth for how much it holds now,
+// reporting whether it did
+func (t *Tree) resize() bool {
+	depth := depthFor(t.count)
+	if !t.adaptive || depth == t.depth {
+		return false
+	}
+
+	count := t.count
+	root := &node{}
+	for _, content := range t.rootNode.getAllContent() {
+		if _, err := t.insert(root, depth, content); err != nil {
+			// IDs too short for the new depth keep the tree as it is
+			t.count = count
+			return false
+		}
+	}
+	root.rehashAll()
+
+	t.rootNode, t.depth, t.count = root, depth, count
+	return true
 }
 
 // Update a node in the tree
 func (t *Tree) Update(content IContent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	var err error
 	notFound := errors.New("ID not found in merkle tree")
 
@@ -105,11 +195,15 @@ func (t *Tree) Update(content IContent) error {
 	}
 
 	current.leaf.Add(content)
+	current.recomputeHash()
 	return nil
 }
 
 // Delete removes a content object to the Merkle tree
 func (t *Tree) Delete(id []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	var err error
 	notFound := errors.New("ID not found in merkle tree")
 
@@ -137,14 +231,33 @@ func (t *Tree) Delete(id []byte) error {
 		return notFound
 	}
 
+	if _, exist := current.leaf.Get(id); exist {
+		t.count--
+	}
+
 	current.leaf.Delete(id)
-	return t.cleanupAfterDelete(id, current)
+	if err = t.cleanupAfterDelete(id, current); err != nil {
+		return err
+	}
+
+	// The nodes left empty are detached, but still lead up to the ones
+	// whose hashes changed
+	if !t.resize() {
+		current.recomputeHash()
+	}
+	return nil
 }
 
 // Diff takes another merkle tree and traverses both to find differences.
-// It returns the additions and deletion differences
+// It returns the additions and deletion differences. The other tree is
+// expected to be a copy nobody else changes, such as one read from a peer.
 func (t *Tree) Diff(other ITree) []IContent {
-	if bytes.Equal(t.Hash(), other.Hash()) {
+	otherHash := other.Hash()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if bytes.Equal(t.rootNode.getHash(), otherHash) {
 		return nil
 	}
 
@@ -153,23 +266,63 @@ func (t *Tree) Diff(other ITree) []IContent {
 
 // Hash returns the root hash of the merkle tree
 func (t *Tree) Hash() []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.rootNode.getHash()
 }
 
+// Depth returns how many levels the tree has above its leaves
+func (t *Tree) Depth() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.depth
+}
+
 // ToProto returns the protobuf representation of the tree
 func (t *Tree) ToProto() *pbMerkle.Tree {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return &pbMerkle.Tree{
 		Depth:    int64(t.depth),
 		RootNode: t.rootNode.toProto(),
 	}
 }
 
-// FromProto reads a proto tree and returns a tree structure
+// FromProto reads a proto tree and returns a tree structure. Its hashes
+// are computed again rather than taken from the proto, which may have been
+// hashed another way.
 func FromProto(t *pbMerkle.Tree, newContentObject func() IContent) *Tree {
-	return &Tree{
+	tree := &Tree{
 		depth:    int(t.Depth),
-		rootNode: nodeFromProto(t.GetRootNode(), newContentObject),
+		rootNode: &node{},
+	}
+	if t.GetRootNode() != nil {
+		tree.rootNode = nodeFromProto(t.GetRootNode(), newContentObject)
+	}
+	tree.count = len(tree.rootNode.getAllContent())
+	tree.rootNode.rehashAll()
+	return tree
+}
+
+// AdaptiveFromProto reads a proto tree into an adaptive tree, sized for
+// the content it holds whatever the depth it was saved with
+func AdaptiveFromProto(t *pbMerkle.Tree, newContentObject func() IContent) ITree {
+	tree := NewAdaptiveTree().(*Tree)
+	if t.GetRootNode() == nil {
+		return tree
+	}
+
+	content := nodeFromProto(t.GetRootNode(), newContentObject).getAllContent()
+	depth := depthFor(len(content))
+	for _, c := range content {
+		if _, err := tree.insert(tree.rootNode, depth, c); err != nil {
+			return FromProto(t, newContentObject)
+		}
 	}
+	tree.depth = depth
+	tree.rootNode.rehashAll()
+	return tree
 }
 
 func (t *Tree) cleanupAfterDelete(id []byte, node *node) error {
@@ -15,7 +15,7 @@ const (
 type C struct {
 	id          []byte
 	lastUpdated int64
-	Content
+	IContent
 }
 
 func newC() *C {
@@ -30,6 +30,10 @@ func (c *C) GetID() []byte {
 	return c.id
 }
 
+func (c *C) GetLastUpdated() int64 {
+	return c.lastUpdated
+}
+
 func (c *C) SetLastUpdated(lastUpdated int64) {
 	c.lastUpdated = lastUpdated
 }
@@ -41,7 +45,7 @@ func (c *C) GetHash() []byte {
 }
 
 func setup() *Tree {
-	return NewTree(treeDepth)
+	return NewTree(treeDepth).(*Tree)
 }
 
 func TestTreeAdd(t *testing.T) {
@@ -79,7 +83,7 @@ func TestTreeDiffEqual(t *testing.T) {
 		}
 	}
 
-	if _, eq := one.Diff(two); !eq {
+	if d := one.Diff(two); len(d) != 0 {
 		t.Error("trees should be equal")
 	}
 }
@@ -95,10 +99,8 @@ func TestTreeDiffNotEqualMakeEqual(t *testing.T) {
 		}
 	}
 
-	var d []Content
-	var eq bool
-
-	if d, eq = one.Diff(two); eq {
+	d := one.Diff(two)
+	if len(d) == 0 {
 		t.Error("trees should not be equal")
 	}
 
@@ -106,7 +108,7 @@ func TestTreeDiffNotEqualMakeEqual(t *testing.T) {
 		one.Add(item)
 	}
 
-	if d, eq = one.Diff(two); !eq {
+	if d = one.Diff(two); len(d) != 0 {
 		t.Error("trees should be equal")
 	}
 }
@@ -124,9 +126,7 @@ func TestTreeDiffNotEqualAllElements(t *testing.T) {
 		}
 	}
 
-	var eq bool
-
-	if _, eq = one.Diff(two); eq {
+	if d := one.Diff(two); len(d) == 0 {
 		t.Error("trees should not be equal")
 	}
 }
@@ -147,9 +147,7 @@ func TestTreeDiffNotEqualOnlyOneElement(t *testing.T) {
 
 	two.Add(newC())
 
-	var eq bool
-
-	if _, eq = one.Diff(two); eq {
+	if d := one.Diff(two); len(d) == 0 {
 		t.Error("trees should not be equal")
 	}
 }
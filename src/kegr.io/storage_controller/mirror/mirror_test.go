@@ -0,0 +1,176 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	pbReplication "kegr.io/protobuf/model/storage/replication"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/state"
+	"kegr.io/storage_controller/util"
+)
+
+// cluster is a node with its own data dir. Both nodes share the config, so
+// it's pointed at whichever one is acting.
+type cluster struct {
+	dir string
+	ss  state.IStateService
+	rl  *replication.Log
+}
+
+func (c *cluster) on(act func()) {
+	config.C.DataRoot = c.dir
+	act()
+}
+
+func newCluster(t *testing.T, name string) *cluster {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cluster{dir: dir, rl: replication.NewLog(name, 16)}
+	c.on(func() { c.ss = state.NewStateService() })
+	return c
+}
+
+// directClient calls the mirror's target in process, on its data dir
+type directClient struct {
+	source, mirror *cluster
+	target         *Target
+}
+
+func (c *directClient) Compare(ctx context.Context, req *pbServer.MirrorCompareRequest, opts ...grpc.CallOption) (*pbServer.MirrorCompareResponse, error) {
+	defer c.source.on(func() {})
+	var res *pbServer.MirrorCompareResponse
+	var err error
+	c.mirror.on(func() { res, err = c.target.Compare(req) })
+	return res, err
+}
+
+func (c *directClient) Apply(ctx context.Context, req *pbServer.MirrorApplyRequest, opts ...grpc.CallOption) (*pbServer.MirrorApplyResponse, error) {
+	defer c.source.on(func() {})
+	var err error
+	c.mirror.on(func() { err = c.target.Apply(req) })
+	return &pbServer.MirrorApplyResponse{}, err
+}
+
+func newTestLiquid(id string) liquid.ILiquid {
+	content := []byte(fmt.Sprintf("liquid %s", id))
+
+	options := liquid.NewOptions()
+	options.SetName(id)
+	options.SetExt("txt")
+
+	l := liquid.NewLiquid()
+	l.SetID(id)
+	l.SetContent(content)
+	l.SetSize(int64(len(content)))
+	l.SetFileHash(util.GetContentHash(content))
+	l.SetOptions(options)
+	l.Touch()
+	return l
+}
+
+func TestMirror(t *testing.T) {
+	config.C = &config.Config{
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+	}
+	primary, dr := newCluster(t, "primary"), newCluster(t, "dr")
+	defer os.RemoveAll(primary.dir)
+	defer os.RemoveAll(dr.dir)
+
+	target := NewTarget("primary", dr.ss, dr.rl)
+	source := &Source{
+		origin: "primary",
+		target: "dr",
+		kegs:   make(map[string]bool),
+		ss:     primary.ss,
+		rl:     primary.rl,
+		client: &directClient{source: primary, mirror: dr, target: target},
+	}
+
+	write := func(k keg.IKeg, id string) {
+		l := newTestLiquid(id)
+		primary.on(func() {
+			if err := primary.ss.ApplyReplicated(k.GetID(), []liquid.ILiquid{l}, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+		primary.rl.PublishLiquids(k.GetID(), 0, []liquid.IInfo{l.GetLiquidInfo()})
+	}
+	mirrored := func(k keg.IKeg, id string) bool {
+		replica, err := dr.ss.GetKegByID(k.GetID())
+		if err != nil {
+			return false
+		}
+		_, err = replica.GetLiquidInfoByID(id)
+		return err == nil
+	}
+
+	options := keg.NewOptions()
+	options.SetName("site")
+	options.SetPath("site")
+	var k keg.IKeg
+	primary.on(func() { k, _ = primary.ss.CreateKeg(options) })
+	write(k, "before")
+
+	// A mirror that has just been set up is brought up to date first
+	primary.on(func() {
+		if err := source.apply(&pbReplication.ChangeEvent{Type: pbReplication.ChangeEvent_RESYNC}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !mirrored(k, "before") {
+		t.Fatal("expected the resync to push the keg and its liquids")
+	}
+
+	_, events, cancel := primary.rl.Subscribe(0, 0)
+	defer cancel()
+	write(k, "after")
+	event := <-events
+	primary.on(func() {
+		if !source.push(event, nil) {
+			t.Fatal("expected the event to be pushed")
+		}
+	})
+	if !mirrored(k, "after") {
+		t.Fatal("expected the write to be pushed")
+	}
+	if dr.rl.GetSequence() == 0 {
+		t.Error("expected the mirror to publish what it took to its own cluster")
+	}
+	if status := source.Status(); status.GetPushed() != event.GetSequence() || status.GetLag() != 0 {
+		t.Errorf("expected the mirror to be caught up, got %v", status)
+	}
+
+	if !target.IsReadOnly() {
+		t.Error("expected the mirror to turn writes away")
+	}
+	if err := target.Apply(&pbServer.MirrorApplyRequest{Origin: "other"}); err == nil {
+		t.Error("expected a push from another cluster to be turned away")
+	}
+
+	dr.on(func() {
+		if err := target.Promote(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if target.IsReadOnly() {
+		t.Error("expected the promoted mirror to take writes")
+	}
+	primary.on(func() {
+		if err := source.apply(event); err == nil {
+			t.Error("expected the promoted mirror to turn pushes away")
+		}
+	})
+}
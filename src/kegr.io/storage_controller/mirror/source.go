@@ -0,0 +1,385 @@
+package mirror
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	pbKeg "kegr.io/protobuf/model/storage/keg"
+	pbLiquid "kegr.io/protobuf/model/storage/liquid"
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	pbReplication "kegr.io/protobuf/model/storage/replication"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/security"
+	"kegr.io/storage_controller/state"
+)
+
+const (
+	// batchSize is how many liquids are pushed at once while resyncing
+	batchSize = 64
+
+	minPushBackoff = 1 * time.Second
+	maxPushBackoff = 30 * time.Second
+)
+
+// Source pushes the writes made through this node to a mirror cluster as
+// they are published on the replication log. Every node of the cluster
+// pushes its own writes, so together they mirror all of them. Nothing is
+// ever taken back from the mirror, and it never joins the cluster.
+type Source struct {
+	origin string
+	target string
+	kegs   map[string]bool
+	ss     state.IStateService
+	rl     replication.ILog
+	client pbServer.MirrorClient
+	conn   *grpc.ClientConn
+
+	mu        sync.Mutex
+	pushed    uint64
+	behind    time.Time
+	lastPush  time.Time
+	lastError error
+}
+
+// ISource is the Source interface
+type ISource interface {
+	Run(stop <-chan struct{})
+	Status() *pbServer.MirrorStatusResponse
+}
+
+// NewSource returns a source pushing to the mirror node at target. Only the
+// given kegs are mirrored, or every keg when there are none.
+func NewSource(origin, target string, kegs []string, ss state.IStateService, rl replication.ILog) (*Source, error) {
+	conn, err := grpc.Dial(target, security.MirrorDialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Source{
+		origin: origin,
+		target: target,
+		kegs:   make(map[string]bool),
+		ss:     ss,
+		rl:     rl,
+		client: pbServer.NewMirrorClient(conn),
+		conn:   conn,
+	}
+	for _, id := range kegs {
+		s.kegs[id] = true
+	}
+	return s, nil
+}
+
+// Run pushes every event of the replication log until stop is closed. The
+// mirror is brought up to date with a full resync first, and again whenever
+// the log no longer holds the events it missed.
+func (s *Source) Run(stop <-chan struct{}) {
+	defer s.conn.Close()
+
+	for {
+		from := uint64(0)
+		if pushed := s.getPushed(); pushed > 0 {
+			from = pushed + 1
+		}
+
+		backlog, events, cancel := s.rl.Subscribe(s.rl.GetEpoch(), from)
+		if from == 0 {
+			backlog = append([]*pbReplication.ChangeEvent{{
+				Type:     pbReplication.ChangeEvent_RESYNC,
+				Sequence: s.rl.GetSequence(),
+				Epoch:    s.rl.GetEpoch(),
+			}}, backlog...)
+		}
+
+		stopped := s.pushAll(backlog, events, stop)
+		cancel()
+		if stopped {
+			return
+		}
+
+		// The log dropped us for falling behind, or is closing
+		select {
+		case <-stop:
+			return
+		case <-time.After(minPushBackoff):
+		}
+	}
+}
+
+// pushAll pushes the backlog and then the events as they come, returning
+// whether it was stopped
+func (s *Source) pushAll(backlog []*pbReplication.ChangeEvent, events <-chan *pbReplication.ChangeEvent, stop <-chan struct{}) bool {
+	for _, event := range backlog {
+		if !s.push(event, stop) {
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if !s.push(event, stop) {
+				return true
+			}
+		}
+	}
+}
+
+// push applies an event on the mirror, retrying with backoff until it's
+// taken. It returns false when stopped first.
+func (s *Source) push(event *pbReplication.ChangeEvent, stop <-chan struct{}) bool {
+	s.mu.Lock()
+	if s.behind.IsZero() {
+		s.behind = time.Now()
+	}
+	s.mu.Unlock()
+
+	backoff := minPushBackoff
+	for {
+		err := s.apply(event)
+		s.pushDone(event.GetSequence(), err)
+		if err == nil {
+			return true
+		}
+		log.Printf("failed to mirror event %v to %v: %v\n", event.GetSequence(), s.target, err)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxPushBackoff {
+			backoff = maxPushBackoff
+		}
+	}
+}
+
+func (s *Source) pushDone(sequence uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastError = err
+	if err != nil {
+		return
+	}
+
+	s.lastPush = time.Now()
+	if sequence > s.pushed {
+		s.pushed = sequence
+	}
+	if s.pushed >= s.rl.GetSequence() {
+		s.behind = time.Time{}
+	}
+}
+
+func (s *Source) apply(event *pbReplication.ChangeEvent) error {
+	switch event.GetType() {
+	case pbReplication.ChangeEvent_RESYNC:
+		return s.resync()
+	case pbReplication.ChangeEvent_KEG:
+		if !s.mirrors(event.GetKegId()) {
+			return nil
+		}
+		return s.send(event.GetKeg(), nil, nil)
+	case pbReplication.ChangeEvent_LIQUIDS:
+		if !s.mirrors(event.GetKegId()) {
+			return nil
+		}
+		return s.pushLiquids(event)
+	}
+	return nil
+}
+
+// pushLiquids sends the liquids of an event as they are on disk now, along
+// with the release that wrote them
+func (s *Source) pushLiquids(event *pbReplication.ChangeEvent) error {
+	k, err := s.ss.GetKegByID(event.GetKegId())
+	if err != nil {
+		// The keg is gone, its deletion is pushed on its own
+		return nil
+	}
+
+	var ids []string
+	for _, content := range event.GetLiquids() {
+		ids = append(ids, string(content.GetID()))
+	}
+	liquids, err := readLiquids(k, ids)
+	if err != nil {
+		return err
+	}
+
+	var releases []*pbRelease.Release
+	if event.GetRelease() > 0 {
+		if releases, err = s.releases(k, event.GetRelease()-1); err != nil {
+			return err
+		}
+	}
+
+	return s.send(header(k), liquids, releases)
+}
+
+// resync compares every mirrored keg with the mirror and pushes what it's
+// missing. Only liquids this node holds can be pushed, the ones it doesn't
+// are pushed by the nodes that do.
+func (s *Source) resync() error {
+	for id, k := range s.ss.GetKegs() {
+		if !s.mirrors(id) {
+			continue
+		}
+
+		if err := s.send(header(k), nil, nil); err != nil {
+			return err
+		}
+
+		req := &pbServer.MirrorCompareRequest{
+			Origin: s.origin,
+			KegId:  id,
+		}
+		for _, info := range k.GetLiquids() {
+			content, err := liquid.NewMerkleTreeLiquid(info)
+			if err != nil {
+				continue
+			}
+			req.Liquids = append(req.Liquids, content.GetProto())
+		}
+
+		res, err := s.client.Compare(context.Background(), req)
+		if err != nil {
+			return err
+		}
+
+		releases, err := s.releases(k, res.GetRelease())
+		if err != nil {
+			return err
+		}
+
+		// The releases go with the last batch, so the mirror only moves
+		// on to them once it has their liquids
+		missing := res.GetMissing()
+		for len(missing) > 0 || len(releases) > 0 {
+			batch := missing
+			if len(batch) > batchSize {
+				batch = batch[:batchSize]
+			}
+			missing = missing[len(batch):]
+
+			liquids, err := readLiquids(k, batch)
+			if err != nil {
+				return err
+			}
+
+			var last []*pbRelease.Release
+			if len(missing) == 0 {
+				last, releases = releases, nil
+			}
+			if err = s.send(header(k), liquids, last); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Source) send(k *pbKeg.Keg, liquids []*pbLiquid.Liquid, releases []*pbRelease.Release) error {
+	_, err := s.client.Apply(context.Background(), &pbServer.MirrorApplyRequest{
+		Origin:   s.origin,
+		Keg:      k,
+		Liquids:  liquids,
+		Releases: releases,
+	})
+	return err
+}
+
+func (s *Source) releases(k keg.IKeg, after int64) ([]*pbRelease.Release, error) {
+	if k.GetRelease() <= after {
+		return nil, nil
+	}
+
+	releases, err := s.ss.GetReleases(k.GetID(), after)
+	if err != nil {
+		return nil, err
+	}
+
+	pbReleases := make([]*pbRelease.Release, len(releases))
+	for i, r := range releases {
+		pbReleases[i] = r.ToProto()
+	}
+	return pbReleases, nil
+}
+
+// mirrors reports whether a keg is pushed to the mirror
+func (s *Source) mirrors(kegID string) bool {
+	return len(s.kegs) == 0 || s.kegs[kegID]
+}
+
+func (s *Source) getPushed() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pushed
+}
+
+// Status returns how far behind the mirror is
+func (s *Source) Status() *pbServer.MirrorStatusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := &pbServer.MirrorStatusResponse{
+		Target:   s.target,
+		Sequence: s.rl.GetSequence(),
+		Pushed:   s.pushed,
+		LastPush: unix(s.lastPush),
+	}
+	for id := range s.kegs {
+		res.Kegs = append(res.Kegs, id)
+	}
+	if !s.behind.IsZero() {
+		res.Lag = int64(time.Since(s.behind) / time.Second)
+	}
+	if s.lastError != nil {
+		res.LastError = s.lastError.Error()
+	}
+	return res
+}
+
+// header returns a keg without its merkle tree
+func header(k keg.IKeg) *pbKeg.Keg {
+	pb := k.ToProto()
+	pb.Tree = nil
+	return pb
+}
+
+// readLiquids reads liquids from disk, leaving out the ones this node
+// doesn't hold
+func readLiquids(k keg.IKeg, ids []string) ([]*pbLiquid.Liquid, error) {
+	var liquids []*pbLiquid.Liquid
+	for _, id := range ids {
+		l, err := k.ReadLiquid(id)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		liquids = append(liquids, l.ToProto())
+	}
+	return liquids, nil
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
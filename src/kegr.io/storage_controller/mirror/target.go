@@ -0,0 +1,217 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/state"
+)
+
+// promotedFile marks a mirror node as promoted, so it stays so on restart
+const promotedFile = ".promoted"
+
+// Target is the receiving end of mirroring, on the nodes of a mirror
+// cluster. Writes pushed to a node are published on its replication log
+// so the rest of the mirror cluster picks them up as usual. Until promoted
+// the cluster only takes writes from the cluster it mirrors. Promoting it
+// for failover turns that cluster's pushes away instead, so the two don't
+// overwrite each other.
+type Target struct {
+	mirrorOf string
+	ss       state.IStateService
+	rl       replication.ILog
+
+	mu          sync.Mutex
+	promoted    bool
+	lastApplied time.Time
+}
+
+// ITarget is the Target interface
+type ITarget interface {
+	IsReadOnly() bool
+	Promote() error
+	Compare(req *pbServer.MirrorCompareRequest) (*pbServer.MirrorCompareResponse, error)
+	Apply(req *pbServer.MirrorApplyRequest) error
+	Status(res *pbServer.MirrorStatusResponse)
+}
+
+// NewTarget returns the receiving end of mirroring the named cluster. A
+// node that isn't a mirror has an empty mirrorOf, and turns pushes away.
+func NewTarget(mirrorOf string, ss state.IStateService, rl replication.ILog) *Target {
+	_, err := os.Stat(promotedPath())
+
+	return &Target{
+		mirrorOf: mirrorOf,
+		ss:       ss,
+		rl:       rl,
+		promoted: err == nil,
+	}
+}
+
+// IsReadOnly reports whether external writes have to be turned away, which
+// they are on a mirror that hasn't been promoted
+func (t *Target) IsReadOnly() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.mirrorOf) > 0 && !t.promoted
+}
+
+// Promote makes this node take writes and stop taking the mirrored
+// cluster's. Every node of the mirror cluster has to be promoted.
+func (t *Target) Promote() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.mirrorOf) == 0 {
+		return errors.New("Not a mirror")
+	}
+	if t.promoted {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(promotedPath(), []byte(t.mirrorOf), 0644); err != nil {
+		return err
+	}
+	t.promoted = true
+
+	log.Printf("promoted the mirror of %v\n", t.mirrorOf)
+	return nil
+}
+
+// Compare returns which of the source's liquids of a keg we're missing,
+// and the latest release of the keg we have
+func (t *Target) Compare(req *pbServer.MirrorCompareRequest) (*pbServer.MirrorCompareResponse, error) {
+	if err := t.accepts(req.GetOrigin()); err != nil {
+		return nil, err
+	}
+
+	res := &pbServer.MirrorCompareResponse{}
+	k, err := t.ss.GetKegByID(req.GetKegId())
+	if err == nil {
+		res.Release = k.GetRelease()
+	}
+
+	for _, content := range req.GetLiquids() {
+		id := string(content.GetID())
+		if err == nil && !isNewer(k, id, content.GetLastUpdated(), content.GetUpdatedBy()) {
+			continue
+		}
+		res.Missing = append(res.Missing, id)
+	}
+	return res, nil
+}
+
+// Apply writes what the source pushed, keeping only what's newer than ours
+func (t *Target) Apply(req *pbServer.MirrorApplyRequest) error {
+	if err := t.accepts(req.GetOrigin()); err != nil {
+		return err
+	}
+
+	other := keg.FromProto(req.GetKeg())
+	kegID := other.GetID()
+
+	_, err := t.ss.GetKegByID(kegID)
+	created := err != nil
+	diff := t.ss.Diff(map[string]keg.IKeg{kegID: other})
+	if kegDiff, exist := diff[kegID]; exist {
+		if err := t.ss.ApplyKegHeader(kegID, kegDiff); err != nil {
+			return err
+		}
+		if created || kegDiff.Options != nil {
+			t.publishKeg(kegID)
+		}
+	}
+
+	k, err := t.ss.GetKegByID(kegID)
+	if err != nil {
+		return err
+	}
+
+	var liquids []liquid.ILiquid
+	var infos []liquid.IInfo
+	for _, pb := range req.GetLiquids() {
+		l := liquid.FromProto(pb)
+		if isNewer(k, l.GetID(), l.GetLastUpdated(), l.GetUpdatedBy()) {
+			liquids = append(liquids, l)
+			infos = append(infos, l.GetLiquidInfo())
+		}
+	}
+
+	var releases []release.IRelease
+	number := int64(0)
+	for _, pb := range req.GetReleases() {
+		r := release.FromProto(pb)
+		releases = append(releases, r)
+		if r.GetNumber() > number {
+			number = r.GetNumber()
+		}
+	}
+
+	if len(liquids) > 0 || len(releases) > 0 {
+		if err = t.ss.ApplyReplicated(kegID, liquids, releases); err != nil {
+			return err
+		}
+		t.rl.PublishLiquids(kegID, number, infos)
+	}
+
+	t.mu.Lock()
+	t.lastApplied = time.Now()
+	t.mu.Unlock()
+	return nil
+}
+
+// Status fills in the receiving end of the mirroring status
+func (t *Target) Status(res *pbServer.MirrorStatusResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res.MirrorOf = t.mirrorOf
+	res.Promoted = t.promoted
+	res.LastApplied = unix(t.lastApplied)
+}
+
+// accepts checks a push comes from the cluster we mirror, and that we
+// haven't been promoted since
+func (t *Target) accepts(origin string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case len(t.mirrorOf) == 0:
+		return errors.New("Not a mirror")
+	case origin != t.mirrorOf:
+		return fmt.Errorf("Not a mirror of %v", origin)
+	case t.promoted:
+		return errors.New("Mirror has been promoted")
+	}
+	return nil
+}
+
+func (t *Target) publishKeg(kegID string) {
+	if k, err := t.ss.GetKegByID(kegID); err == nil {
+		t.rl.PublishKeg(k)
+	}
+}
+
+// isNewer reports whether a version of a liquid is newer than the one the
+// keg has, if any
+func isNewer(k keg.IKeg, liquidID string, lastUpdated int64, updatedBy string) bool {
+	info, err := k.GetLiquidInfoByID(liquidID)
+	return err != nil || clock.Newer(lastUpdated, updatedBy, info.GetLastUpdated(), info.GetUpdatedBy())
+}
+
+func promotedPath() string {
+	return fmt.Sprintf("%s/%s", config.C.DataRoot, promotedFile)
+}
@@ -0,0 +1,203 @@
+package conflict
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pbConflict "kegr.io/protobuf/model/storage/conflict"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+const (
+	// Dir is the directory inside a keg that holds its conflicts
+	Dir       = ".conflicts"
+	extension = "conflict"
+)
+
+// Conflict is a version of a liquid that a concurrent edit overwrote
+// without having seen it. The discarded version is kept in a body file
+// next to the conflict.
+type Conflict struct {
+	IConflict
+
+	id        string
+	kegID     string
+	liquidID  string
+	detected  int64
+	kept      liquid.IInfo
+	discarded liquid.IInfo
+}
+
+// IConflict is an interface
+type IConflict interface {
+	GetID() string
+	GetKegID() string
+	GetLiquidID() string
+	GetDetected() int64
+	GetKept() liquid.IInfo
+	GetDiscarded() liquid.IInfo
+
+	ToProto() *pbConflict.Conflict
+	ToFile() error
+}
+
+// NewConflict records that the discarded version of a liquid was
+// overwritten by the kept one
+func NewConflict(kegID string, kept, discarded liquid.IInfo) IConflict {
+	return &Conflict{
+		id:        util.ID(),
+		kegID:     kegID,
+		liquidID:  kept.GetID(),
+		detected:  time.Now().Unix(),
+		kept:      kept,
+		discarded: discarded,
+	}
+}
+
+// FromProto converts a proto conflict to a model.Conflict
+func FromProto(c *pbConflict.Conflict) IConflict {
+	return &Conflict{
+		id:        c.GetId(),
+		kegID:     c.GetKegId(),
+		liquidID:  c.GetLiquidId(),
+		detected:  c.GetDetected(),
+		kept:      liquid.InfoFromProto(c.GetKept()),
+		discarded: liquid.InfoFromProto(c.GetDiscarded()),
+	}
+}
+
+// FromFile loads a conflict of a keg from the FS
+func FromFile(kegID, conflictID string) (IConflict, error) {
+	content, err := ioutil.ReadFile(File(kegID, conflictID))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &pbConflict.Conflict{}
+	if err = proto.Unmarshal(content, c); err != nil {
+		return nil, err
+	}
+	return FromProto(c), nil
+}
+
+// List loads every conflict of a keg, oldest first. Conflicts detected
+// longer than the retention ago are removed instead, along with their
+// bodies.
+func List(kegID string, retention time.Duration) ([]*pbConflict.Conflict, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, kegID, Dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []*pbConflict.Conflict
+	expired := time.Now().Add(-retention).Unix()
+	suffix := "." + extension
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+
+		c, err := FromFile(kegID, strings.TrimSuffix(file.Name(), suffix))
+		if err != nil {
+			return nil, err
+		}
+		if c.GetDetected() < expired {
+			if err = Remove(kegID, c.GetID()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		conflicts = append(conflicts, c.ToProto())
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].GetDetected() < conflicts[j].GetDetected()
+	})
+	return conflicts, nil
+}
+
+// Remove forgets a conflict and the version it kept
+func Remove(kegID, conflictID string) error {
+	for _, file := range []string{BodyFile(kegID, conflictID), File(kegID, conflictID)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// File returns the path of a conflict's record
+func File(kegID, conflictID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s.%s", config.C.DataRoot, kegID, Dir, conflictID, extension)
+}
+
+// BodyFile returns the path under which the discarded version of a
+// conflict is kept
+func BodyFile(kegID, conflictID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s.%s", config.C.DataRoot, kegID, Dir, conflictID, config.C.LiquidExtension)
+}
+
+// ToProto returns the proto representation of the conflict
+func (c *Conflict) ToProto() *pbConflict.Conflict {
+	return &pbConflict.Conflict{
+		Id:        c.id,
+		KegId:     c.kegID,
+		LiquidId:  c.liquidID,
+		Detected:  c.detected,
+		Kept:      c.kept.ToProto(),
+		Discarded: c.discarded.ToProto(),
+	}
+}
+
+// ToFile saves the conflict record to the FS
+func (c *Conflict) ToFile() error {
+	content, err := proto.Marshal(c.ToProto())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, c.kegID, Dir), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(File(c.kegID, c.id), content, 0644)
+}
+
+// GetID getter
+func (c *Conflict) GetID() string {
+	return c.id
+}
+
+// GetKegID getter
+func (c *Conflict) GetKegID() string {
+	return c.kegID
+}
+
+// GetLiquidID getter
+func (c *Conflict) GetLiquidID() string {
+	return c.liquidID
+}
+
+// GetDetected getter
+func (c *Conflict) GetDetected() int64 {
+	return c.detected
+}
+
+// GetKept getter
+func (c *Conflict) GetKept() liquid.IInfo {
+	return c.kept
+}
+
+// GetDiscarded getter
+func (c *Conflict) GetDiscarded() liquid.IInfo {
+	return c.discarded
+}
@@ -3,7 +3,14 @@ package keg
 import "kegr.io/storage_controller/merkle"
 
 type KegDiff struct {
-	Options IOptions
+	// Options is set when the other keg's options or tombstone are a later
+	// version than ours, which Deleted, LastUpdated and UpdatedBy describe
+	Options     IOptions
+	Deleted     bool
+	LastUpdated int64
+	UpdatedBy   string
+
+	Release int64
 	Content []merkle.IContent
 }
 
@@ -0,0 +1,43 @@
+package keg
+
+import "sort"
+
+// liquidIndex keeps the access names of a keg's liquids sorted so
+// prefix listings and pagination don't have to walk the whole keg
+type liquidIndex struct {
+	names []string
+}
+
+func newLiquidIndex() *liquidIndex {
+	return &liquidIndex{}
+}
+
+// insert adds a name to the index, keeping it sorted
+func (li *liquidIndex) insert(name string) {
+	i := sort.SearchStrings(li.names, name)
+	if i < len(li.names) && li.names[i] == name {
+		return
+	}
+	li.names = append(li.names, "")
+	copy(li.names[i+1:], li.names[i:])
+	li.names[i] = name
+}
+
+// remove deletes a name from the index if present
+func (li *liquidIndex) remove(name string) {
+	i := sort.SearchStrings(li.names, name)
+	if i < len(li.names) && li.names[i] == name {
+		li.names = append(li.names[:i], li.names[i+1:]...)
+	}
+}
+
+// after returns the position of the first name strictly greater than
+// startAfter which also has the given prefix
+func (li *liquidIndex) after(prefix, startAfter string) int {
+	if startAfter < prefix {
+		return sort.SearchStrings(li.names, prefix)
+	}
+	return sort.Search(len(li.names), func(i int) bool {
+		return li.names[i] > startAfter
+	})
+}
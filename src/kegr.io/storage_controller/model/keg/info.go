@@ -11,6 +11,8 @@ type Info struct {
 	Path        string
 	Cache       int64
 	Gzip        bool
+	Release     int64
+	UpdatedBy   string
 }
 
 func (i *Info) ToProto() *keg.Info {
@@ -22,5 +24,7 @@ func (i *Info) ToProto() *keg.Info {
 		Path:        i.Path,
 		Cache:       i.Cache,
 		Gzip:        i.Gzip,
+		Release:     i.Release,
+		UpdatedBy:   i.UpdatedBy,
 	}
 }
@@ -1,14 +1,17 @@
 package keg
 
 import (
-	"crypto/md5"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	pbKeg "kegr.io/protobuf/model/storage/keg"
 
 	"github.com/golang/protobuf/proto"
+	"kegr.io/storage_controller/clock"
 	"kegr.io/storage_controller/config"
 	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/liquid"
@@ -17,20 +20,31 @@ import (
 const (
 	kegFile         = ".keg"
 	liquidExtension = "liquid"
-	merkleTreeDepth = 16
+
+	// merkleExchangeStep is how many levels of a peer's tree are requested
+	// at a time when comparing against it
+	merkleExchangeStep = 4
 )
 
-// Keg holds information about a keg including quick access maps for liquidAccessName to liquidID
+// Keg holds information about a keg including quick access maps for liquidAccessName to liquidID.
+// It's safe for concurrent use: mu guards its fields, while writeMu lets callers run changes
+// spanning several steps, its files included, one at a time.
 type Keg struct {
 	IKeg
 
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+
 	id          string
 	options     IOptions
 	deleted     bool
 	lastUpdated int64
+	updatedBy   string
+	release     int64
 
 	liquidByAccessName map[string]string
 	liquidInfo         map[string]liquid.IInfo
+	index              *liquidIndex
 	merkleTree         merkle.ITree
 }
 
@@ -42,6 +56,10 @@ type IKeg interface {
 	GetLiquidIDByAccessName(liquidAccessName string) (string, error)
 	GetLiquidInfoByID(liquidID string) (liquid.IInfo, error)
 	GetLiquids() map[string]liquid.IInfo
+	ApplyRelease(number int64, infos []liquid.IInfo, install func() error) error
+	ListLiquids(prefix, delimiter, startAfter string, limit int) ([]liquid.IInfo, []string, string)
+	ReadLiquid(liquidID string) (liquid.ILiquid, error)
+	LockWrites() func()
 
 	ToBytes() ([]byte, error)
 	ToProto() *pbKeg.Keg
@@ -49,6 +67,7 @@ type IKeg interface {
 
 	GetStateHash() ([]byte, error)
 	Diff(other IKeg) *KegDiff
+	DiffRemote(other IKeg, treeHash []byte, remote merkle.Remote) (*KegDiff, error)
 
 	// Getters and setters
 	GetID() string
@@ -56,29 +75,54 @@ type IKeg interface {
 	SetOptions(options IOptions)
 	GetTree() merkle.ITree
 	GetLastUpdated() int64
+	GetUpdatedBy() string
+	GetRelease() int64
+	SetRelease(release int64)
 	IsDeleted() bool
 	SetDeleted(deleted bool)
+	Replicate(options IOptions, deleted bool, lastUpdated int64, updatedBy string) bool
 	GetInfo() *Info
 }
 
+// LockWrites serialises changes to the keg that take more than one step,
+// such as writing a liquid's file and then updating its info. It returns
+// the function releasing the lock.
+func (k *Keg) LockWrites() func() {
+	k.writeMu.Lock()
+	return k.writeMu.Unlock
+}
+
 // ToBytes returns the bytes array representation of the object
 func (k *Keg) ToBytes() ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	return proto.Marshal(k.toKegFile())
 }
 
 // ToProto returns the protobuf representation of this keg
 func (k *Keg) ToProto() *pbKeg.Keg {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
 	return &pbKeg.Keg{
 		Id:          k.id,
 		Options:     k.options.ToProto(),
 		Tree:        k.merkleTree.ToProto(),
 		Deleted:     k.deleted,
 		LastUpdated: k.lastUpdated,
+		UpdatedBy:   k.updatedBy,
+		Release:     k.release,
 	}
 }
 
 // ToDir saves the keg contents to the FS
 func (k *Keg) ToDir() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.toDir()
+}
+
+func (k *Keg) toDir() error {
 	var content []byte
 	var err error
 
@@ -89,7 +133,7 @@ func (k *Keg) ToDir() error {
 		return err
 	}
 
-	if content, err = k.ToBytes(); err != nil {
+	if content, err = proto.Marshal(k.toKegFile()); err != nil {
 		return err
 	}
 
@@ -98,25 +142,23 @@ func (k *Keg) ToDir() error {
 
 // GetStateHash returns the bytes array representation of the object
 func (k *Keg) GetStateHash() ([]byte, error) {
-	bytes, err := k.ToBytes()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	content, err := proto.Marshal(k.toKegFile())
 	if err != nil {
 		return nil, err
 	}
 
-	hash := md5.New()
-	hash.Write(bytes)
+	hash := sha256.New()
+	hash.Write(content)
 	hash.Write(k.merkleTree.Hash())
 	return hash.Sum(nil), nil
 }
 
 // Diff returns the difference between this and another keg
 func (k *Keg) Diff(other IKeg) *KegDiff {
-	kd := NewKegDiff()
-
-	// Compare options
-	if other.GetLastUpdated() > k.GetLastUpdated() {
-		kd.Options = other.GetOptions()
-	}
+	kd := k.diffHeader(other)
 
 	// Compare content
 	kd.Content = k.GetTree().Diff(other.GetTree())
@@ -124,7 +166,53 @@ func (k *Keg) Diff(other IKeg) *KegDiff {
 	return kd
 }
 
+// DiffRemote is Diff for a keg whose merkle tree stays with a peer. Other
+// only carries the keg itself and treeHash is the root hash of its tree.
+// Without a remote only the keg itself is compared.
+func (k *Keg) DiffRemote(other IKeg, treeHash []byte, remote merkle.Remote) (*KegDiff, error) {
+	kd := k.diffHeader(other)
+	if remote == nil || bytes.Equal(k.merkleTree.Hash(), treeHash) {
+		return kd, nil
+	}
+
+	content, err := k.merkleTree.DiffRemote(remote, merkleExchangeStep)
+	if err != nil {
+		return nil, err
+	}
+	kd.Content = content
+
+	return kd, nil
+}
+
+func (k *Keg) diffHeader(other IKeg) *KegDiff {
+	kd := NewKegDiff()
+	otherLastUpdated, otherUpdatedBy := other.GetLastUpdated(), other.GetUpdatedBy()
+	otherOptions, otherRelease := other.GetOptions(), other.GetRelease()
+	otherDeleted := other.IsDeleted()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	// Compare options and tombstone, which share a version
+	if clock.Newer(otherLastUpdated, otherUpdatedBy, k.lastUpdated, k.updatedBy) {
+		kd.Options = otherOptions
+		kd.Deleted = otherDeleted
+		kd.LastUpdated = otherLastUpdated
+		kd.UpdatedBy = otherUpdatedBy
+	}
+
+	// Compare releases
+	if otherRelease > k.release {
+		kd.Release = otherRelease
+	}
+
+	return kd
+}
+
 func (k *Keg) GetInfo() *Info {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
 	return &Info{
 		ID:          k.id,
 		Deleted:     k.deleted,
@@ -133,6 +221,8 @@ func (k *Keg) GetInfo() *Info {
 		Path:        k.options.GetPath(),
 		Cache:       k.options.GetCache(),
 		Gzip:        k.options.GetGzip(),
+		Release:     k.release,
+		UpdatedBy:   k.updatedBy,
 	}
 }
 
@@ -142,5 +232,13 @@ func (k *Keg) toKegFile() *pbKeg.KegFile {
 		Options:     k.options.ToProto(),
 		Deleted:     k.deleted,
 		LastUpdated: k.lastUpdated,
+		UpdatedBy:   k.updatedBy,
+		Release:     k.release,
 	}
 }
+
+// touch marks the keg as changed now by this node
+func (k *Keg) touch() {
+	k.lastUpdated = clock.Now()
+	k.updatedBy = clock.Node()
+}
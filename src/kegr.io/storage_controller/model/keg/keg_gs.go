@@ -2,8 +2,8 @@ package keg
 
 import (
 	"fmt"
-	"time"
 
+	"kegr.io/storage_controller/clock"
 	"kegr.io/storage_controller/config"
 	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/liquid"
@@ -16,42 +16,119 @@ func (k *Keg) GetID() string {
 
 // GetOptions getter
 func (k *Keg) GetOptions() IOptions {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	return k.options
 }
 
 // GetLastUpdated getter
 func (k *Keg) GetLastUpdated() int64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	return k.lastUpdated
 }
 
+// GetUpdatedBy getter
+func (k *Keg) GetUpdatedBy() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.updatedBy
+}
+
+// GetRelease getter
+func (k *Keg) GetRelease() int64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.release
+}
+
+// SetRelease setter
+func (k *Keg) SetRelease(release int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.release = release
+	k.toDir()
+}
+
 // SetOptions setter
 func (k *Keg) SetOptions(options IOptions) {
-	k.lastUpdated = time.Now().Unix()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.touch()
 	k.options = options
-	k.ToDir()
+	k.toDir()
 }
 
-// GetTree getter
+// GetTree getter. The tree is never replaced and guards itself, so it's
+// handed out as is.
 func (k *Keg) GetTree() merkle.ITree {
 	return k.merkleTree
 }
 
 // IsDeleted getter
 func (k *Keg) IsDeleted() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	return k.deleted
 }
 
 // SetDeleted setter
 func (k *Keg) SetDeleted(deleted bool) {
-	k.lastUpdated = time.Now().Unix()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.touch()
 	k.deleted = deleted
-	for _, li := range k.GetLiquids() {
-		liquidFile := fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, k.id, li.GetID(), config.C.LiquidExtension)
-		if liquid, err := liquid.FromFile(liquidFile); err == nil {
-			liquid.SetDeleted(true)
-			k.updateLiquidInfo(liquid.GetLiquidInfo())
-			liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, k.id))
+	if deleted {
+		k.tombstoneLiquids()
+	}
+	k.toDir()
+}
+
+// Replicate takes a peer's options and tombstone if they're a later version
+// than ours. They keep the version they were made with, so every node ends
+// up with the same one. It reports whether the keg changed.
+func (k *Keg) Replicate(options IOptions, deleted bool, lastUpdated int64, updatedBy string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !clock.Newer(lastUpdated, updatedBy, k.lastUpdated, k.updatedBy) {
+		return false
+	}
+	clock.Observe(lastUpdated)
+
+	k.options = options
+	k.lastUpdated = lastUpdated
+	k.updatedBy = updatedBy
+	if deleted && !k.deleted {
+		k.tombstoneLiquids()
+	}
+	k.deleted = deleted
+	k.toDir()
+	return true
+}
+
+// tombstoneLiquids marks the liquids deleted along with the keg. They take
+// the keg's version, so every owner deleting the keg ends up with the same
+// tombstones, and liquids written after the keg was deleted are left alone.
+func (k *Keg) tombstoneLiquids() {
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, k.id)
+
+	for _, id := range k.liquidIDs() {
+		info := k.liquidInfo[id]
+		if info.IsDeleted() || !clock.Newer(k.lastUpdated, k.updatedBy, info.GetLastUpdated(), info.GetUpdatedBy()) {
+			continue
+		}
+
+		liquidFile := fmt.Sprintf("%s/%s.%s", dir, id, config.C.LiquidExtension)
+		if l, err := liquid.FromFile(liquidFile); err == nil {
+			l.SetDeleted(true)
+			l.SetLastUpdated(k.lastUpdated)
+			l.SetUpdatedBy(k.updatedBy)
+			k.updateLiquidInfo(l.GetLiquidInfo())
+			l.ToFile(dir)
 		}
 	}
-	k.ToDir()
 }
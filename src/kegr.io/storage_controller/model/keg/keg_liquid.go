@@ -2,36 +2,90 @@ package keg
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
+	"kegr.io/storage_controller/config"
 	"kegr.io/storage_controller/model/liquid"
 )
 
+// commonPrefixEnd sorts after every valid utf-8 continuation of a common
+// prefix, so resuming a listing from it skips the whole prefix
+const commonPrefixEnd = "\xff"
+
 // AddLiquid inserts a liquid in all data structures and makes it available in this keg
 func (k *Keg) AddLiquid(info liquid.IInfo) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	return k.updateLiquidInfo(info)
 }
 
 // UpdateLiquid updates the liquids options and updates the merkle tree
 func (k *Keg) UpdateLiquid(info liquid.IInfo) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	return k.updateLiquidInfo(info)
 }
 
+// ApplyRelease switches the keg over to a whole release at once. install is
+// run first to put the release's liquid files in place, then every info is
+// updated and the keg's release number moves to number. Readers wait for
+// the whole switch, so they never see half a release.
+func (k *Keg) ApplyRelease(number int64, infos []liquid.IInfo, install func() error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if install != nil {
+		if err := install(); err != nil {
+			return err
+		}
+	}
+
+	for _, info := range infos {
+		if err := k.updateLiquidInfo(info); err != nil {
+			return err
+		}
+	}
+
+	if number > k.release {
+		k.release = number
+		return k.toDir()
+	}
+	return nil
+}
+
+// ReadLiquid reads a liquid from disk. Reads wait for a release being
+// installed, so they never get a file of the release without the rest.
+func (k *Keg) ReadLiquid(liquidID string) (liquid.ILiquid, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, k.id, liquidID, config.C.LiquidExtension))
+}
+
 // DeleteLiquid receives a liquidID and checks if this liquid is in the keg,
-// after which it marks it as deleted
+// after which it marks it as deleted. The info is replaced rather than changed
+// in place, as readers may still hold on to it.
 func (k *Keg) DeleteLiquid(liquidID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
 	info, exist := k.liquidInfo[liquidID]
 	if !exist || info.IsDeleted() {
 		return errors.New("File not found")
 	}
 
-	info.SetDeleted(true)
+	deleted := liquid.InfoFromProto(info.ToProto())
+	deleted.SetDeleted(true)
 
-	return k.updateLiquidInfo(info)
+	return k.updateLiquidInfo(deleted)
 }
 
 // GetLiquidIDByAccessName does a lookup in the kegs LiquidByAccessName map to
 // find the liquidID of the corresponding file and loads it form disk.
 func (k *Keg) GetLiquidIDByAccessName(liquidAccessName string) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
 	liquidID, exist := k.liquidByAccessName[liquidAccessName]
 	if !exist {
 		return "", errors.New("File not found")
@@ -41,6 +95,9 @@ func (k *Keg) GetLiquidIDByAccessName(liquidAccessName string) (string, error) {
 
 // GetLiquidInfoByID returns the liquid info for that id
 func (k *Keg) GetLiquidInfoByID(liquidID string) (liquid.IInfo, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
 	info, exist := k.liquidInfo[liquidID]
 	if !exist {
 		return nil, errors.New("File not found")
@@ -48,21 +105,92 @@ func (k *Keg) GetLiquidInfoByID(liquidID string) (liquid.IInfo, error) {
 	return info, nil
 }
 
-// GetLiquids returns all the liquids in this keg
+// GetLiquids returns all the liquids in this keg. The map is a copy, so it
+// can be ranged over while the keg changes.
 func (k *Keg) GetLiquids() map[string]liquid.IInfo {
-	return k.liquidInfo
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	liquids := make(map[string]liquid.IInfo, len(k.liquidInfo))
+	for id, info := range k.liquidInfo {
+		liquids[id] = info
+	}
+	return liquids
+}
+
+// ListLiquids returns up to limit live liquids whose access name starts with
+// prefix, in access name order, resuming after startAfter. When delimiter is
+// set, names containing it after the prefix are rolled up into common
+// prefixes instead. The returned marker is empty when the listing is complete
+// and otherwise should be passed as startAfter to fetch the next page.
+func (k *Keg) ListLiquids(prefix, delimiter, startAfter string, limit int) ([]liquid.IInfo, []string, string) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var liquids []liquid.IInfo
+	var prefixes []string
+	var last string
+
+	names := k.index.names
+	for i := k.index.after(prefix, startAfter); i < len(names); i++ {
+		name := names[i]
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+
+		info, exist := k.liquidInfo[k.liquidByAccessName[name]]
+		if !exist || info.IsDeleted() {
+			continue
+		}
+
+		if len(delimiter) > 0 {
+			if pos := strings.Index(name[len(prefix):], delimiter); pos >= 0 {
+				common := name[:len(prefix)+pos+len(delimiter)]
+				if n := len(prefixes); n > 0 && prefixes[n-1] == common {
+					continue
+				}
+				if len(liquids)+len(prefixes) == limit {
+					return liquids, prefixes, last
+				}
+				prefixes = append(prefixes, common)
+				last = common + commonPrefixEnd
+				continue
+			}
+		}
+
+		if len(liquids)+len(prefixes) == limit {
+			return liquids, prefixes, last
+		}
+		liquids = append(liquids, info)
+		last = name
+	}
+
+	return liquids, prefixes, ""
+}
+
+func (k *Keg) liquidIDs() []string {
+	ids := make([]string, 0, len(k.liquidInfo))
+	for id := range k.liquidInfo {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 func (k *Keg) updateLiquidInfo(info liquid.IInfo) error {
 	oldInfo, exist := k.liquidInfo[info.GetID()]
 	if exist {
-		delete(k.liquidByAccessName, oldInfo.GetAccessName())
+		// Another liquid may have taken the old name since, it keeps it
+		if k.liquidByAccessName[oldInfo.GetAccessName()] == oldInfo.GetID() {
+			delete(k.liquidByAccessName, oldInfo.GetAccessName())
+			k.index.remove(oldInfo.GetAccessName())
+		}
 		delete(k.liquidInfo, oldInfo.GetID())
 	}
 
 	// Add new entries
 	k.liquidInfo[info.GetID()] = info
 	k.liquidByAccessName[info.GetAccessName()] = info.GetID()
+	k.index.insert(info.GetAccessName())
 	merkleTreeLiquid, err := liquid.NewMerkleTreeLiquid(info)
 	if err != nil {
 		return err
@@ -0,0 +1,103 @@
+package keg
+
+import (
+	"fmt"
+	"testing"
+
+	"kegr.io/storage_controller/model/liquid"
+)
+
+func newTestKeg(names ...string) IKeg {
+	k := NewKegWithID("test", NewOptions())
+	for i, name := range names {
+		k.AddLiquid(&liquid.Info{
+			ID:         testLiquidID(i),
+			Name:       name,
+			AccessName: name,
+		})
+	}
+	return k
+}
+
+func testLiquidID(i int) string {
+	return fmt.Sprintf("%020d", i)
+}
+
+func TestListLiquidsPrefix(t *testing.T) {
+	k := newTestKeg("img/a.png", "img/b.png", "js/app.js", "index.html")
+
+	liquids, prefixes, last := k.ListLiquids("img/", "", "", 10)
+	if len(liquids) != 2 || len(prefixes) != 0 || last != "" {
+		t.Errorf("unexpected listing %v %v %q", liquids, prefixes, last)
+	}
+}
+
+func TestListLiquidsDelimiter(t *testing.T) {
+	k := newTestKeg("img/icons/a.png", "img/icons/b.png", "img/c.png", "index.html")
+
+	liquids, prefixes, _ := k.ListLiquids("", "/", "", 10)
+	if len(liquids) != 1 || liquids[0].GetAccessName() != "index.html" {
+		t.Errorf("unexpected liquids %v", liquids)
+	}
+	if len(prefixes) != 1 || prefixes[0] != "img/" {
+		t.Errorf("unexpected prefixes %v", prefixes)
+	}
+
+	liquids, prefixes, _ = k.ListLiquids("img/", "/", "", 10)
+	if len(liquids) != 1 || len(prefixes) != 1 || prefixes[0] != "img/icons/" {
+		t.Errorf("unexpected listing %v %v", liquids, prefixes)
+	}
+}
+
+func TestListLiquidsPagination(t *testing.T) {
+	k := newTestKeg("a/1", "a/2", "b", "c/1", "c/2", "d")
+
+	var seen []string
+	last := ""
+	for {
+		liquids, prefixes, next := k.ListLiquids("", "/", last, 1)
+		for _, l := range liquids {
+			seen = append(seen, l.GetAccessName())
+		}
+		seen = append(seen, prefixes...)
+		if next == "" {
+			break
+		}
+		last = next
+	}
+
+	expected := []string{"a/", "b", "c/", "d"}
+	if len(seen) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("expected %v got %v", expected, seen)
+		}
+	}
+}
+
+func TestListLiquidsSkipsDeleted(t *testing.T) {
+	k := newTestKeg("a", "b")
+	k.DeleteLiquid(testLiquidID(0))
+
+	liquids, _, _ := k.ListLiquids("", "", "", 10)
+	if len(liquids) != 1 || liquids[0].GetAccessName() != "b" {
+		t.Errorf("unexpected liquids %v", liquids)
+	}
+}
+
+func TestUpdateLiquidKeepsOtherLiquidsName(t *testing.T) {
+	k := newTestKeg("a", "a")
+
+	// The second liquid holds the name, renaming the first leaves it there
+	k.UpdateLiquid(&liquid.Info{ID: testLiquidID(0), Name: "b", AccessName: "b"})
+
+	if id, err := k.GetLiquidIDByAccessName("a"); err != nil || id != testLiquidID(1) {
+		t.Errorf("expected a to still be %v, got %v %v", testLiquidID(1), id, err)
+	}
+	liquids, _, _ := k.ListLiquids("", "", "", 10)
+	if len(liquids) != 2 {
+		t.Errorf("expected both liquids to be listed, got %v", liquids)
+	}
+}
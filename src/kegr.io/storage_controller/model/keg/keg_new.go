@@ -4,10 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"time"
 
 	"github.com/golang/protobuf/proto"
 	pbKeg "kegr.io/protobuf/model/storage/keg"
+	"kegr.io/storage_controller/clock"
 	"kegr.io/storage_controller/config"
 	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/liquid"
@@ -21,8 +21,10 @@ func NewKegWithID(id string, options IOptions) IKeg {
 		options:            options,
 		liquidByAccessName: make(map[string]string),
 		liquidInfo:         make(map[string]liquid.IInfo),
-		merkleTree:         merkle.NewTree(merkleTreeDepth),
-		lastUpdated:        time.Now().Unix(),
+		index:              newLiquidIndex(),
+		merkleTree:         merkle.NewAdaptiveTree(),
+		lastUpdated:        clock.Now(),
+		updatedBy:          clock.Node(),
 		deleted:            false,
 	}
 }
@@ -38,8 +40,10 @@ func NewEmptyKeg() IKeg {
 	return &Keg{
 		liquidByAccessName: make(map[string]string),
 		liquidInfo:         make(map[string]liquid.IInfo),
-		merkleTree:         merkle.NewTree(merkleTreeDepth),
-		lastUpdated:        time.Now().Unix(),
+		index:              newLiquidIndex(),
+		merkleTree:         merkle.NewAdaptiveTree(),
+		lastUpdated:        clock.Now(),
+		updatedBy:          clock.Node(),
 		deleted:            false,
 	}
 }
@@ -58,9 +62,9 @@ func FromBytes(bytes []byte) (IKeg, error) {
 func FromProto(k *pbKeg.Keg) IKeg {
 	var tree merkle.ITree
 	if k.GetTree() != nil {
-		tree = merkle.FromProto(k.GetTree(), wrapper)
+		tree = merkle.AdaptiveFromProto(k.GetTree(), wrapper)
 	} else {
-		tree = merkle.NewTree(16)
+		tree = merkle.NewAdaptiveTree()
 	}
 
 	return &Keg{
@@ -68,9 +72,29 @@ func FromProto(k *pbKeg.Keg) IKeg {
 		options:            optionsFromProto(k.Options),
 		liquidByAccessName: make(map[string]string),
 		liquidInfo:         make(map[string]liquid.IInfo),
+		index:              newLiquidIndex(),
 		merkleTree:         tree,
 		lastUpdated:        k.LastUpdated,
-		deleted:            false,
+		updatedBy:          k.UpdatedBy,
+		release:            k.Release,
+		deleted:            k.Deleted,
+	}
+}
+
+// NewReplica initialises an empty keg with the id, options, tombstone and
+// version of a keg learned of from a peer. Taking its version keeps the
+// new keg from passing as a later change than the one it copies.
+func NewReplica(other IKeg) IKeg {
+	return &Keg{
+		id:                 other.GetID(),
+		options:            other.GetOptions(),
+		liquidByAccessName: make(map[string]string),
+		liquidInfo:         make(map[string]liquid.IInfo),
+		index:              newLiquidIndex(),
+		merkleTree:         merkle.NewAdaptiveTree(),
+		lastUpdated:        other.GetLastUpdated(),
+		updatedBy:          other.GetUpdatedBy(),
+		deleted:            other.IsDeleted(),
 	}
 }
 
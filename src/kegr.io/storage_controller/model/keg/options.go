@@ -4,10 +4,15 @@ import pbKeg "kegr.io/protobuf/model/storage/keg"
 
 // Options hold the changeable data for a keg
 type Options struct {
-	name  string
-	path  string
-	cache int64
-	gzip  bool
+	name     string
+	path     string
+	cache    int64
+	gzip     bool
+	replicas int64
+	// writes and reads are the consistency levels of the keg's writes and
+	// reads that don't ask for one
+	writes pbKeg.Consistency
+	reads  pbKeg.Consistency
 	IOptions
 }
 
@@ -21,6 +26,12 @@ type IOptions interface {
 	SetCache(cache int64)
 	GetPath() string
 	SetPath(path string)
+	GetReplicas() int64
+	SetReplicas(replicas int64)
+	GetWriteConsistency() pbKeg.Consistency
+	SetWriteConsistency(level pbKeg.Consistency)
+	GetReadConsistency() pbKeg.Consistency
+	SetReadConsistency(level pbKeg.Consistency)
 	Diff(other IOptions) IOptions
 
 	ToProto() *pbKeg.Options
@@ -37,10 +48,13 @@ func NewOptions() IOptions {
 // OptionsFromProto converts the protobuf  options object to a model.options
 func OptionsFromProto(lo *pbKeg.Options) IOptions {
 	return &Options{
-		name:  lo.Name,
-		path:  lo.Path,
-		cache: lo.Cache,
-		gzip:  lo.Gzip,
+		name:     lo.Name,
+		path:     lo.Path,
+		cache:    lo.Cache,
+		gzip:     lo.Gzip,
+		replicas: lo.Replicas,
+		writes:   lo.WriteConsistency,
+		reads:    lo.ReadConsistency,
 	}
 }
 
@@ -51,6 +65,9 @@ func (o *Options) Diff(other IOptions) IOptions {
 	newOptions.SetGzip(o.GetGzip())
 	newOptions.SetCache(o.GetCache())
 	newOptions.SetPath(o.GetPath())
+	newOptions.SetReplicas(o.GetReplicas())
+	newOptions.SetWriteConsistency(o.GetWriteConsistency())
+	newOptions.SetReadConsistency(o.GetReadConsistency())
 	if o.GetName() != other.GetName() {
 		newOptions.SetName(other.GetName())
 	}
@@ -63,6 +80,15 @@ func (o *Options) Diff(other IOptions) IOptions {
 	if o.GetPath() != other.GetPath() {
 		newOptions.SetPath(other.GetPath())
 	}
+	if o.GetReplicas() != other.GetReplicas() {
+		newOptions.SetReplicas(other.GetReplicas())
+	}
+	if o.GetWriteConsistency() != other.GetWriteConsistency() {
+		newOptions.SetWriteConsistency(other.GetWriteConsistency())
+	}
+	if o.GetReadConsistency() != other.GetReadConsistency() {
+		newOptions.SetReadConsistency(other.GetReadConsistency())
+	}
 	return newOptions
 }
 
@@ -106,21 +132,57 @@ func (o *Options) SetPath(path string) {
 	o.path = path
 }
 
+// GetReplicas getter
+func (o *Options) GetReplicas() int64 {
+	return o.replicas
+}
+
+// SetReplicas setter
+func (o *Options) SetReplicas(replicas int64) {
+	o.replicas = replicas
+}
+
+// GetWriteConsistency getter
+func (o *Options) GetWriteConsistency() pbKeg.Consistency {
+	return o.writes
+}
+
+// SetWriteConsistency setter
+func (o *Options) SetWriteConsistency(level pbKeg.Consistency) {
+	o.writes = level
+}
+
+// GetReadConsistency getter
+func (o *Options) GetReadConsistency() pbKeg.Consistency {
+	return o.reads
+}
+
+// SetReadConsistency setter
+func (o *Options) SetReadConsistency(level pbKeg.Consistency) {
+	o.reads = level
+}
+
 // ToProto returns the proto representation of the object
 func (o *Options) ToProto() *pbKeg.Options {
 	return &pbKeg.Options{
-		Name:  o.name,
-		Path:  o.path,
-		Cache: o.cache,
-		Gzip:  o.gzip,
+		Name:             o.name,
+		Path:             o.path,
+		Cache:            o.cache,
+		Gzip:             o.gzip,
+		Replicas:         o.replicas,
+		WriteConsistency: o.writes,
+		ReadConsistency:  o.reads,
 	}
 }
 
 func optionsFromProto(o *pbKeg.Options) IOptions {
 	return &Options{
-		name:  o.Name,
-		path:  o.Path,
-		cache: o.Cache,
-		gzip:  o.Gzip,
+		name:     o.Name,
+		path:     o.Path,
+		cache:    o.Cache,
+		gzip:     o.Gzip,
+		replicas: o.Replicas,
+		writes:   o.WriteConsistency,
+		reads:    o.ReadConsistency,
 	}
 }
@@ -20,6 +20,7 @@ type Info struct {
 	Deleted     bool
 	AccessName  string
 	LastUpdated int64
+	UpdatedBy   string
 }
 
 // IInfo is an interface
@@ -44,6 +45,8 @@ type IInfo interface {
 	SetAccessName(accessName string)
 	GetLastUpdated() int64
 	SetLastUpdated(lastUpdated int64)
+	GetUpdatedBy() string
+	SetUpdatedBy(updatedBy string)
 
 	ToBytes() ([]byte, error)
 	ToProto() *liquid.Info
@@ -149,6 +152,16 @@ func (i *Info) SetLastUpdated(lastUpdated int64) {
 	i.LastUpdated = lastUpdated
 }
 
+// GetUpdatedBy getter
+func (i *Info) GetUpdatedBy() string {
+	return i.UpdatedBy
+}
+
+// SetUpdatedBy setter
+func (i *Info) SetUpdatedBy(updatedBy string) {
+	i.UpdatedBy = updatedBy
+}
+
 // ToBytes returns the byte array representation of the object
 func (i *Info) ToBytes() ([]byte, error) {
 	return bson.Marshal(*i)
@@ -167,5 +180,23 @@ func (i *Info) ToProto() *liquid.Info {
 		Deleted:     i.Deleted,
 		AccessName:  i.AccessName,
 		LastUpdated: i.LastUpdated,
+		UpdatedBy:   i.UpdatedBy,
+	}
+}
+
+// InfoFromProto converts a proto info object to an Info
+func InfoFromProto(info *liquid.Info) IInfo {
+	return &Info{
+		ID:          info.GetId(),
+		FileHash:    info.GetFileHash(),
+		Size:        info.GetSize(),
+		Name:        info.GetName(),
+		Ext:         info.GetExt(),
+		Cache:       info.GetCache(),
+		Gzip:        info.GetGzip(),
+		Deleted:     info.GetDeleted(),
+		AccessName:  info.GetAccessName(),
+		LastUpdated: info.GetLastUpdated(),
+		UpdatedBy:   info.GetUpdatedBy(),
 	}
 }
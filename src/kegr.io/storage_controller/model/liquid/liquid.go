@@ -2,7 +2,7 @@ package liquid
 
 import (
 	"fmt"
-	"io/ioutil"
+	"os"
 
 	"github.com/golang/protobuf/proto"
 	pbLiquid "kegr.io/protobuf/model/storage/liquid"
@@ -16,9 +16,15 @@ type Liquid struct {
 	content     []byte
 	size        int64
 	lastUpdated int64
+	updatedBy   string
 	deleted     bool
 	options     IOptions
 	ILiquid
+
+	// previousUpdated and previousBy are the version this one was written
+	// over
+	previousUpdated int64
+	previousBy      string
 }
 
 // ILiquid is an interface
@@ -41,6 +47,12 @@ type ILiquid interface {
 	SetSize(size int64)
 	GetLastUpdated() int64
 	SetLastUpdated(lastUpdated int64)
+	GetUpdatedBy() string
+	SetUpdatedBy(updatedBy string)
+	GetPreviousUpdated() int64
+	GetPreviousBy() string
+	SetPrevious(previous IInfo)
+	Touch()
 	IsDeleted() bool
 	SetDeleted(deleted bool)
 	GetOptions() IOptions
@@ -60,6 +72,7 @@ func (l *Liquid) ToProto() *pbLiquid.Liquid {
 		Content:     l.content,
 		Size:        l.size,
 		LastUpdated: l.lastUpdated,
+		UpdatedBy:   l.updatedBy,
 		Deleted:     l.deleted,
 		Options: &pbLiquid.Options{
 			Name:  l.options.GetName(),
@@ -67,6 +80,8 @@ func (l *Liquid) ToProto() *pbLiquid.Liquid {
 			Cache: l.options.GetCache(),
 			Gzip:  l.options.GetGzip(),
 		},
+		PreviousUpdated: l.previousUpdated,
+		PreviousBy:      l.previousBy,
 	}
 }
 
@@ -79,11 +94,32 @@ func (l *Liquid) ToFile(path string) error {
 		return err
 	}
 
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s.%s", path, l.id, config.C.LiquidExtension), content, 0644); err != nil {
+	// Replacing the file instead of rewriting it in place keeps hard links
+	// to the previous version, such as the ones snapshots hold, intact
+	file := fmt.Sprintf("%s/%s.%s", path, l.id, config.C.LiquidExtension)
+	if err := writeSynced(file+".tmp", content); err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(file+".tmp", file)
+}
+
+// writeSynced writes a file and flushes it to disk, so a write acknowledged
+// to other nodes survives a crash
+func writeSynced(file string, content []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // GetAccessName returns the string file name that one can
@@ -104,6 +140,8 @@ func (l *Liquid) GetLiquidInfo() IInfo {
 		Cache:       l.options.GetCache(),
 		Gzip:        l.options.GetGzip(),
 		Deleted:     l.deleted,
+		AccessName:  l.GetAccessName(),
 		LastUpdated: l.lastUpdated,
+		UpdatedBy:   l.updatedBy,
 	}
 }
@@ -1,5 +1,7 @@
 package liquid
 
+import "kegr.io/storage_controller/clock"
+
 // GetID getter
 func (l *Liquid) GetID() string {
 	return l.id
@@ -50,6 +52,40 @@ func (l *Liquid) SetLastUpdated(lastUpdated int64) {
 	l.lastUpdated = lastUpdated
 }
 
+// GetUpdatedBy getter
+func (l *Liquid) GetUpdatedBy() string {
+	return l.updatedBy
+}
+
+// SetUpdatedBy setter
+func (l *Liquid) SetUpdatedBy(updatedBy string) {
+	l.updatedBy = updatedBy
+}
+
+// GetPreviousUpdated getter
+func (l *Liquid) GetPreviousUpdated() int64 {
+	return l.previousUpdated
+}
+
+// GetPreviousBy getter
+func (l *Liquid) GetPreviousBy() string {
+	return l.previousBy
+}
+
+// SetPrevious records the version the liquid is written over
+func (l *Liquid) SetPrevious(previous IInfo) {
+	l.previousUpdated = previous.GetLastUpdated()
+	l.previousBy = previous.GetUpdatedBy()
+}
+
+// Touch marks the liquid as written now by this node, over the version it
+// was until now
+func (l *Liquid) Touch() {
+	l.previousUpdated, l.previousBy = l.lastUpdated, l.updatedBy
+	l.lastUpdated = clock.Now()
+	l.updatedBy = clock.Node()
+}
+
 // IsDeleted getter
 func (l *Liquid) IsDeleted() bool {
 	return l.deleted
@@ -34,8 +34,12 @@ func FromProto(proto *pbLiquid.Liquid) ILiquid {
 		content:     proto.Content,
 		size:        proto.Size,
 		lastUpdated: proto.LastUpdated,
+		updatedBy:   proto.UpdatedBy,
 		deleted:     proto.Deleted,
 		options:     OptionsFromProto(proto.Options),
+
+		previousUpdated: proto.PreviousUpdated,
+		previousBy:      proto.PreviousBy,
 	}
 }
 
@@ -1,7 +1,7 @@
 package liquid
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
 
 	pbMerkle "kegr.io/protobuf/model/merkle"
 	"kegr.io/storage_controller/merkle"
@@ -15,7 +15,9 @@ type MerkleTreeLiquid struct {
 	id          []byte
 	hash        []byte
 	lastUpdated int64
+	updatedBy   string
 	deleted     bool
+	size        int64
 }
 
 // IMerkleTreeLiquid is an interface
@@ -28,10 +30,14 @@ type IMerkleTreeLiquid interface {
 	SetHash(hash []byte)
 	GetLastUpdated() int64
 	SetLastUpdated(lastUpdated int64)
+	GetUpdatedBy() string
+	SetUpdatedBy(updatedBy string)
 	GetProto() *pbMerkle.Content
 
 	IsDeleted() bool
 	SetDeleted(deleted bool)
+	GetSize() int64
+	SetSize(size int64)
 }
 
 // NewEmptyMerkleTreeLiquid returns an empty merkle tree liquid object
@@ -49,14 +55,16 @@ func NewMerkleTreeLiquid(info IInfo) (IMerkleTreeLiquid, error) {
 		return nil, err
 	}
 
-	hash := md5.New()
+	hash := sha256.New()
 	hash.Write(bytes)
 
 	return &MerkleTreeLiquid{
 		id:          []byte(info.GetID()),
 		hash:        hash.Sum(nil),
 		lastUpdated: info.GetLastUpdated(),
+		updatedBy:   info.GetUpdatedBy(),
 		deleted:     info.IsDeleted(),
+		size:        info.GetSize(),
 	}, nil
 }
 
@@ -66,7 +74,9 @@ func (mtl *MerkleTreeLiquid) GetProto() *pbMerkle.Content {
 		ID:          []byte(mtl.id),
 		Hash:        mtl.hash,
 		LastUpdated: mtl.lastUpdated,
+		UpdatedBy:   mtl.updatedBy,
 		Deleted:     mtl.deleted,
+		Size:        mtl.size,
 	}
 }
 
@@ -100,6 +110,16 @@ func (mtl *MerkleTreeLiquid) SetLastUpdated(lastUpdated int64) {
 	mtl.lastUpdated = lastUpdated
 }
 
+// GetUpdatedBy getter
+func (mtl *MerkleTreeLiquid) GetUpdatedBy() string {
+	return mtl.updatedBy
+}
+
+// SetUpdatedBy setter
+func (mtl *MerkleTreeLiquid) SetUpdatedBy(updatedBy string) {
+	mtl.updatedBy = updatedBy
+}
+
 // IsDeleted getter
 func (mtl *MerkleTreeLiquid) IsDeleted() bool {
 	return mtl.deleted
@@ -109,3 +129,13 @@ func (mtl *MerkleTreeLiquid) IsDeleted() bool {
 func (mtl *MerkleTreeLiquid) SetDeleted(deleted bool) {
 	mtl.deleted = deleted
 }
+
+// GetSize getter
+func (mtl *MerkleTreeLiquid) GetSize() int64 {
+	return mtl.size
+}
+
+// SetSize setter
+func (mtl *MerkleTreeLiquid) SetSize(size int64) {
+	mtl.size = size
+}
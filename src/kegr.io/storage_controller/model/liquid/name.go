@@ -0,0 +1,33 @@
+package liquid
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameSeparator splits the hierarchical parts of a liquid name,
+// e.g. img/icons/a
+const NameSeparator = "/"
+
+// CleanName validates a liquid name, which may be slash separated, and
+// returns it in canonical form without leading or duplicate separators
+func CleanName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errors.New("Invalid liquid name")
+	}
+
+	name = strings.TrimLeft(name, NameSeparator)
+	for _, part := range strings.Split(name, NameSeparator) {
+		if part == ".." {
+			return "", errors.New("Invalid liquid name")
+		}
+	}
+
+	name = path.Clean(name)
+	if name == "." || len(name) == 0 {
+		return "", errors.New("Invalid liquid name")
+	}
+	return name, nil
+}
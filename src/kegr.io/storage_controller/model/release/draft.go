@@ -0,0 +1,172 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+const (
+	draftsDir = "drafts"
+	draftFile = ".draft"
+)
+
+// Draft is a release that is still being staged. Staged liquids are kept
+// as liquid files in the draft's own directory until the draft is committed.
+type Draft struct {
+	IDraft
+
+	id          string
+	kegID       string
+	created     int64
+	description string
+	deletes     []string
+}
+
+// IDraft is an interface
+type IDraft interface {
+	GetID() string
+	GetKegID() string
+	GetDescription() string
+	GetDeletes() []string
+	GetDir() string
+
+	Stage(l liquid.ILiquid) error
+	StageDelete(liquidID string) error
+	GetStaged() ([]liquid.ILiquid, error)
+	Remove() error
+
+	ToProto() *pbRelease.Draft
+	ToFile() error
+}
+
+// NewDraft returns a new empty draft for a keg
+func NewDraft(kegID, description string) IDraft {
+	return &Draft{
+		id:          util.ID(),
+		kegID:       kegID,
+		created:     time.Now().Unix(),
+		description: description,
+	}
+}
+
+// DraftFromFile loads a draft of a keg from the FS
+func DraftFromFile(kegID, draftID string) (IDraft, error) {
+	d := &Draft{id: draftID, kegID: kegID}
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.GetDir(), draftFile))
+	if err != nil {
+		return nil, err
+	}
+
+	pb := &pbRelease.Draft{}
+	if err = proto.Unmarshal(content, pb); err != nil {
+		return nil, err
+	}
+
+	return &Draft{
+		id:          pb.GetId(),
+		kegID:       pb.GetKegId(),
+		created:     pb.GetCreated(),
+		description: pb.GetDescription(),
+		deletes:     pb.GetDeletes(),
+	}, nil
+}
+
+// Stage adds a created or updated liquid to the draft
+func (d *Draft) Stage(l liquid.ILiquid) error {
+	return l.ToFile(d.GetDir())
+}
+
+// StageDelete adds a deletion to the draft
+func (d *Draft) StageDelete(liquidID string) error {
+	for _, id := range d.deletes {
+		if id == liquidID {
+			return nil
+		}
+	}
+	d.deletes = append(d.deletes, liquidID)
+	return d.ToFile()
+}
+
+// GetStaged loads every liquid staged in the draft
+func (d *Draft) GetStaged() ([]liquid.ILiquid, error) {
+	files, err := ioutil.ReadDir(d.GetDir())
+	if err != nil {
+		return nil, err
+	}
+
+	var staged []liquid.ILiquid
+	for _, file := range files {
+		if file.IsDir() || file.Name() == draftFile {
+			continue
+		}
+
+		l, err := liquid.FromFile(fmt.Sprintf("%s/%s", d.GetDir(), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, l)
+	}
+	return staged, nil
+}
+
+// Remove discards the draft and everything staged in it
+func (d *Draft) Remove() error {
+	return os.RemoveAll(d.GetDir())
+}
+
+// ToProto returns the proto representation of the draft
+func (d *Draft) ToProto() *pbRelease.Draft {
+	return &pbRelease.Draft{
+		Id:          d.id,
+		KegId:       d.kegID,
+		Created:     d.created,
+		Description: d.description,
+		Deletes:     d.deletes,
+	}
+}
+
+// ToFile saves the draft manifest to the FS
+func (d *Draft) ToFile() error {
+	content, err := proto.Marshal(d.ToProto())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(d.GetDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("%s/%s", d.GetDir(), draftFile), content, 0644)
+}
+
+// GetID getter
+func (d *Draft) GetID() string {
+	return d.id
+}
+
+// GetKegID getter
+func (d *Draft) GetKegID() string {
+	return d.kegID
+}
+
+// GetDescription getter
+func (d *Draft) GetDescription() string {
+	return d.description
+}
+
+// GetDeletes getter
+func (d *Draft) GetDeletes() []string {
+	return d.deletes
+}
+
+// GetDir returns the directory the draft is staged in
+func (d *Draft) GetDir() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", config.C.DataRoot, d.kegID, Dir, draftsDir, d.id)
+}
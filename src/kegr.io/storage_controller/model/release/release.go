@@ -0,0 +1,169 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	"kegr.io/storage_controller/config"
+)
+
+const (
+	// Dir is the directory inside a keg that holds its releases
+	Dir       = ".releases"
+	extension = "release"
+)
+
+// Release is a numbered, committed batch of liquid changes in a keg
+type Release struct {
+	IRelease
+
+	kegID       string
+	number      int64
+	created     int64
+	description string
+	changes     []*pbRelease.Change
+}
+
+// IRelease is an interface
+type IRelease interface {
+	GetKegID() string
+	GetNumber() int64
+	GetCreated() int64
+	GetDescription() string
+	GetChanges() []*pbRelease.Change
+
+	ToProto() *pbRelease.Release
+	ToFile() error
+	Remove() error
+}
+
+// NewRelease returns an initialised release object
+func NewRelease(kegID string, number, created int64, description string, changes []*pbRelease.Change) IRelease {
+	return &Release{
+		kegID:       kegID,
+		number:      number,
+		created:     created,
+		description: description,
+		changes:     changes,
+	}
+}
+
+// FromProto converts a proto release to a model.Release
+func FromProto(r *pbRelease.Release) IRelease {
+	return NewRelease(r.GetKegId(), r.GetNumber(), r.GetCreated(), r.GetDescription(), r.GetChanges())
+}
+
+// FromFile loads a release of a keg from the FS
+func FromFile(kegID string, number int64) (IRelease, error) {
+	content, err := ioutil.ReadFile(File(kegID, number))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &pbRelease.Release{}
+	if err = proto.Unmarshal(content, r); err != nil {
+		return nil, err
+	}
+	return FromProto(r), nil
+}
+
+// List loads every release of a keg numbered after the given one,
+// in release order
+func List(kegID string, after int64) ([]IRelease, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, kegID, Dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []IRelease
+	suffix := "." + extension
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+
+		number, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), suffix), 10, 64)
+		if err != nil || number <= after {
+			continue
+		}
+
+		r, err := FromFile(kegID, number)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].GetNumber() < releases[j].GetNumber()
+	})
+	return releases, nil
+}
+
+// File returns the path of a release's manifest
+func File(kegID string, number int64) string {
+	return fmt.Sprintf("%s/%s/%s/%d.%s", config.C.DataRoot, kegID, Dir, number, extension)
+}
+
+// ToProto returns the proto representation of the release
+func (r *Release) ToProto() *pbRelease.Release {
+	return &pbRelease.Release{
+		KegId:       r.kegID,
+		Number:      r.number,
+		Created:     r.created,
+		Description: r.description,
+		Changes:     r.changes,
+	}
+}
+
+// ToFile saves the release manifest to the FS
+func (r *Release) ToFile() error {
+	content, err := proto.Marshal(r.ToProto())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, r.kegID, Dir), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(File(r.kegID, r.number), content, 0644)
+}
+
+// Remove deletes the release manifest from the FS
+func (r *Release) Remove() error {
+	return os.Remove(File(r.kegID, r.number))
+}
+
+// GetKegID getter
+func (r *Release) GetKegID() string {
+	return r.kegID
+}
+
+// GetNumber getter
+func (r *Release) GetNumber() int64 {
+	return r.number
+}
+
+// GetCreated getter
+func (r *Release) GetCreated() int64 {
+	return r.created
+}
+
+// GetDescription getter
+func (r *Release) GetDescription() string {
+	return r.description
+}
+
+// GetChanges getter
+func (r *Release) GetChanges() []*pbRelease.Change {
+	return r.changes
+}
@@ -0,0 +1,220 @@
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	pbSnapshot "kegr.io/protobuf/model/storage/snapshot"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/util"
+)
+
+const (
+	// Dir is the directory inside a keg that holds its snapshots
+	Dir         = ".snapshots"
+	versionsDir = "versions"
+	extension   = "snapshot"
+)
+
+// Snapshot is a frozen copy of a keg's merkle tree, options and the
+// versions of the liquids it referenced when it was taken
+type Snapshot struct {
+	ISnapshot
+
+	id          string
+	kegID       string
+	created     int64
+	description string
+	options     keg.IOptions
+	tree        *pbMerkle.Tree
+	versions    map[string][]byte
+}
+
+// ISnapshot is an interface
+type ISnapshot interface {
+	GetID() string
+	GetKegID() string
+	GetCreated() int64
+	GetDescription() string
+	GetOptions() keg.IOptions
+	GetVersions() map[string][]byte
+
+	ToProto() *pbSnapshot.Snapshot
+	ToFile() error
+	GetInfo() *pbSnapshot.Info
+}
+
+// NewSnapshot freezes the current state of a keg. The versions map holds
+// the merkle hash of every live liquid, keyed by liquid id.
+func NewSnapshot(k keg.IKeg, description string, versions map[string][]byte) ISnapshot {
+	return &Snapshot{
+		id:          util.ID(),
+		kegID:       k.GetID(),
+		created:     time.Now().Unix(),
+		description: description,
+		options:     k.GetOptions(),
+		tree:        k.GetTree().ToProto(),
+		versions:    versions,
+	}
+}
+
+// FromProto converts a proto snapshot to a model.Snapshot
+func FromProto(s *pbSnapshot.Snapshot) ISnapshot {
+	versions := make(map[string][]byte)
+	for _, v := range s.GetLiquids() {
+		versions[v.GetLiquidId()] = v.GetHash()
+	}
+
+	return &Snapshot{
+		id:          s.GetId(),
+		kegID:       s.GetKegId(),
+		created:     s.GetCreated(),
+		description: s.GetDescription(),
+		options:     keg.OptionsFromProto(s.GetOptions()),
+		tree:        s.GetTree(),
+		versions:    versions,
+	}
+}
+
+// FromFile loads a snapshot of a keg from the FS
+func FromFile(kegID, snapshotID string) (ISnapshot, error) {
+	content, err := ioutil.ReadFile(File(kegID, snapshotID))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pbSnapshot.Snapshot{}
+	if err = proto.Unmarshal(content, s); err != nil {
+		return nil, err
+	}
+	return FromProto(s), nil
+}
+
+// List loads the info of every snapshot of a keg, oldest first
+func List(kegID string) ([]*pbSnapshot.Info, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, kegID, Dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*pbSnapshot.Info
+	suffix := "." + extension
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+
+		s, err := FromFile(kegID, strings.TrimSuffix(file.Name(), suffix))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, s.GetInfo())
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GetCreated() < infos[j].GetCreated()
+	})
+	return infos, nil
+}
+
+// File returns the path of a snapshot's manifest
+func File(kegID, snapshotID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s.%s", config.C.DataRoot, kegID, Dir, snapshotID, extension)
+}
+
+// VersionFile returns the path under which a frozen version of a liquid
+// is kept. Versions are shared between all snapshots of a keg.
+func VersionFile(kegID, liquidID string, hash []byte) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s.%x.%s", config.C.DataRoot, kegID, Dir, versionsDir, liquidID, hash, config.C.LiquidExtension)
+}
+
+// ToProto returns the proto representation of the snapshot
+func (s *Snapshot) ToProto() *pbSnapshot.Snapshot {
+	var ids []string
+	for id := range s.versions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	liquids := make([]*pbSnapshot.Version, 0, len(ids))
+	for _, id := range ids {
+		liquids = append(liquids, &pbSnapshot.Version{
+			LiquidId: id,
+			Hash:     s.versions[id],
+		})
+	}
+
+	return &pbSnapshot.Snapshot{
+		Id:          s.id,
+		KegId:       s.kegID,
+		Created:     s.created,
+		Description: s.description,
+		Options:     s.options.ToProto(),
+		Tree:        s.tree,
+		Liquids:     liquids,
+	}
+}
+
+// ToFile saves the snapshot manifest to the FS
+func (s *Snapshot) ToFile() error {
+	content, err := proto.Marshal(s.ToProto())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s/%s", config.C.DataRoot, s.kegID, Dir), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(File(s.kegID, s.id), content, 0644)
+}
+
+// GetInfo returns the summary of the snapshot used in listings
+func (s *Snapshot) GetInfo() *pbSnapshot.Info {
+	return &pbSnapshot.Info{
+		Id:          s.id,
+		KegId:       s.kegID,
+		Created:     s.created,
+		Description: s.description,
+		Liquids:     int64(len(s.versions)),
+	}
+}
+
+// GetID getter
+func (s *Snapshot) GetID() string {
+	return s.id
+}
+
+// GetKegID getter
+func (s *Snapshot) GetKegID() string {
+	return s.kegID
+}
+
+// GetCreated getter
+func (s *Snapshot) GetCreated() int64 {
+	return s.created
+}
+
+// GetDescription getter
+func (s *Snapshot) GetDescription() string {
+	return s.description
+}
+
+// GetOptions getter
+func (s *Snapshot) GetOptions() keg.IOptions {
+	return s.options
+}
+
+// GetVersions getter
+func (s *Snapshot) GetVersions() map[string][]byte {
+	return s.versions
+}
@@ -1,7 +1,7 @@
 package state
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
 	"sort"
 
 	"github.com/golang/protobuf/proto"
@@ -40,7 +40,7 @@ func (s *State) GetHash() ([]byte, error) {
 	}
 	sort.Strings(keys)
 
-	hash := md5.New()
+	hash := sha256.New()
 	for _, id := range keys {
 		bytes, err := s.kegs[id].GetStateHash()
 		if err != nil {
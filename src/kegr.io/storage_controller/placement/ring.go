@@ -0,0 +1,159 @@
+package placement
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// Ring is a consistent hash ring over node IDs. Every node is placed on the
+// ring at several points so keys spread evenly between nodes and only a
+// small share of them move when a node joins or leaves. Nodes are labelled
+// with the zone they run in, so a key's replicas can survive losing one.
+type Ring struct {
+	mu     sync.RWMutex
+	vnodes int
+	points []uint32
+	owner  map[uint32]string
+	nodes  map[string]string
+}
+
+// IRing is the Ring interface
+type IRing interface {
+	Add(node, zone string)
+	Remove(node string)
+	Nodes() []string
+	Owners(key string, replicas int) []string
+	Zone(node string) string
+}
+
+// NewRing returns an empty ring placing each node at vnodes points
+func NewRing(vnodes int) *Ring {
+	if vnodes < 1 {
+		vnodes = 1
+	}
+
+	return &Ring{
+		vnodes: vnodes,
+		owner:  make(map[uint32]string),
+		nodes:  make(map[string]string),
+	}
+}
+
+// Add places a node in a zone on the ring, or moves it to another zone if
+// it's already there
+func (r *Ring) Add(node, zone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exist := r.nodes[node]
+	r.nodes[node] = zone
+	if exist {
+		return
+	}
+
+	for i := 0; i < r.vnodes; i++ {
+		point := hash(fmt.Sprintf("%s#%d", node, i))
+		if _, taken := r.owner[point]; taken {
+			continue
+		}
+		r.owner[point] = node
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Remove takes a node off the ring
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.nodes[node]; !exist {
+		return
+	}
+	delete(r.nodes, node)
+
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owner[point] == node {
+			delete(r.owner, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+}
+
+// Nodes returns every node on the ring, sorted
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nodeList()
+}
+
+func (r *Ring) nodeList() []string {
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Owners returns the nodes responsible for a key, the first one being its
+// primary. Walking the ring clockwise from the key, the first replicas
+// distinct nodes are picked, skipping nodes in a zone that already holds
+// a replica for as long as there are zones left without one. A replicas
+// of 0, or more than there are nodes, makes every node an owner.
+func (r *Ring) Owners(key string, replicas int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if replicas <= 0 || replicas >= len(r.nodes) {
+		return r.nodeList()
+	}
+
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= hash(key)
+	})
+
+	// The nodes in ring order, each taken at its first point
+	var walk []string
+	seen := make(map[string]struct{})
+	for i := 0; i < len(r.points) && len(seen) < len(r.nodes); i++ {
+		node := r.owner[r.points[(start+i)%len(r.points)]]
+		if _, exist := seen[node]; !exist {
+			seen[node] = struct{}{}
+			walk = append(walk, node)
+		}
+	}
+
+	// One replica per zone first, then the rest in ring order
+	var owners, rest []string
+	zones := make(map[string]struct{})
+	for _, node := range walk {
+		zone := r.nodes[node]
+		if _, taken := zones[zone]; taken || len(owners) == replicas {
+			rest = append(rest, node)
+			continue
+		}
+		zones[zone] = struct{}{}
+		owners = append(owners, node)
+	}
+	if missing := replicas - len(owners); missing < len(rest) {
+		rest = rest[:missing]
+	}
+	return append(owners, rest...)
+}
+
+// Zone returns the zone of a node on the ring
+func (r *Ring) Zone(node string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nodes[node]
+}
+
+func hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
@@ -0,0 +1,139 @@
+package placement
+
+import (
+	"fmt"
+	"testing"
+)
+
+const (
+	testVirtualNodes = 64
+	testKeys         = 10000
+)
+
+func newTestRing(nodes int) *Ring {
+	r := NewRing(testVirtualNodes)
+	for i := 0; i < nodes; i++ {
+		r.Add(fmt.Sprintf("node-%d", i), "")
+	}
+	return r
+}
+
+func TestRingOwnersDistinct(t *testing.T) {
+	r := newTestRing(5)
+
+	for i := 0; i < 100; i++ {
+		owners := r.Owners(fmt.Sprintf("keg-%d", i), 3)
+		if len(owners) != 3 {
+			t.Fatalf("expected 3 owners got %v", owners)
+		}
+		if owners[0] == owners[1] || owners[1] == owners[2] || owners[0] == owners[2] {
+			t.Errorf("owners are not distinct %v", owners)
+		}
+	}
+}
+
+func TestRingOwnersAll(t *testing.T) {
+	r := newTestRing(3)
+
+	if owners := r.Owners("keg", 0); len(owners) != 3 {
+		t.Errorf("expected every node to own the keg got %v", owners)
+	}
+	if owners := r.Owners("keg", 5); len(owners) != 3 {
+		t.Errorf("expected every node to own the keg got %v", owners)
+	}
+}
+
+func TestRingOwnersStable(t *testing.T) {
+	one, two := newTestRing(4), newTestRing(4)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("keg-%d", i)
+		if fmt.Sprint(one.Owners(key, 2)) != fmt.Sprint(two.Owners(key, 2)) {
+			t.Errorf("rings disagree on the owners of %v", key)
+		}
+	}
+}
+
+func TestRingAddMovesFewKeys(t *testing.T) {
+	r := newTestRing(4)
+
+	before := make(map[string]string)
+	for i := 0; i < testKeys; i++ {
+		key := fmt.Sprintf("keg-%d", i)
+		before[key] = r.Owners(key, 1)[0]
+	}
+
+	r.Add("node-4", "")
+
+	moved := 0
+	for key, owner := range before {
+		if r.Owners(key, 1)[0] != owner {
+			moved++
+		}
+	}
+
+	// About a fifth of the keys should move to the new node
+	if moved > testKeys/3 {
+		t.Errorf("adding a node moved %d of %d keys", moved, testKeys)
+	}
+}
+
+func TestRingRemove(t *testing.T) {
+	r := newTestRing(3)
+	r.Remove("node-1")
+
+	for i := 0; i < 100; i++ {
+		for _, owner := range r.Owners(fmt.Sprintf("keg-%d", i), 1) {
+			if owner == "node-1" {
+				t.Fatal("removed node still owns keys")
+			}
+		}
+	}
+}
+
+func TestRingOwnersSpreadAcrossZones(t *testing.T) {
+	r := NewRing(testVirtualNodes)
+	for i := 0; i < 6; i++ {
+		r.Add(fmt.Sprintf("node-%d", i), fmt.Sprintf("rack-%d", i%2))
+	}
+
+	for i := 0; i < 100; i++ {
+		owners := r.Owners(fmt.Sprintf("keg-%d", i), 2)
+		if len(owners) != 2 || r.Zone(owners[0]) == r.Zone(owners[1]) {
+			t.Errorf("expected owners in both racks got %v", owners)
+		}
+
+		// More replicas than zones still fills up with distinct nodes
+		owners = r.Owners(fmt.Sprintf("keg-%d", i), 3)
+		if len(owners) != 3 || owners[0] == owners[2] || owners[1] == owners[2] {
+			t.Errorf("expected 3 distinct owners got %v", owners)
+		}
+	}
+}
+
+func TestRingOwnersWhileNodesLeave(t *testing.T) {
+	r := newTestRing(4)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			r.Remove("node-2")
+			r.Remove("node-3")
+			r.Add("node-2", "")
+			r.Add("node-3", "")
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		// Down to two nodes there are only two owners to be had
+		if owners := r.Owners("keg", 3); len(owners) < 2 || len(owners) > 3 {
+			t.Fatalf("expected 2 or 3 owners got %v", owners)
+		}
+	}
+}
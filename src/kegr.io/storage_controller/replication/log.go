@@ -0,0 +1,226 @@
+package replication
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	pbReplication "kegr.io/protobuf/model/storage/replication"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped and has to catch up from the log
+const subscriberBuffer = 256
+
+// flushCheckInterval is how often Close checks whether subscribers have
+// been sent everything
+const flushCheckInterval = 10 * time.Millisecond
+
+// Log numbers every change made through this node's external service and
+// pushes it to subscribed peers. The most recent events are kept so a peer
+// that reconnects can catch up without a full anti-entropy round. Sequence
+// numbers start over with every log, so each one has its own epoch.
+type Log struct {
+	ILog
+
+	mu          sync.Mutex
+	origin      string
+	epoch       int64
+	sequence    uint64
+	size        int
+	events      []*pbReplication.ChangeEvent
+	subscribers map[chan *pbReplication.ChangeEvent]struct{}
+	closed      bool
+}
+
+// ILog is the Log interface
+type ILog interface {
+	PublishLiquids(kegID string, release int64, infos []liquid.IInfo)
+	PublishKeg(k keg.IKeg)
+	Subscribe(epoch int64, from uint64) ([]*pbReplication.ChangeEvent, <-chan *pbReplication.ChangeEvent, func())
+	GetEpoch() int64
+	GetSequence() uint64
+	Close(ctx context.Context) error
+}
+
+// NewLog returns an initialised log keeping the last size events
+func NewLog(origin string, size int) *Log {
+	return &Log{
+		origin:      origin,
+		epoch:       time.Now().UnixNano(),
+		size:        size,
+		subscribers: make(map[chan *pbReplication.ChangeEvent]struct{}),
+	}
+}
+
+// PublishLiquids records a write of one or more liquids in a keg. Liquids
+// written by a release are published in one event with its number.
+func (l *Log) PublishLiquids(kegID string, release int64, infos []liquid.IInfo) {
+	var content []*pbMerkle.Content
+	for _, info := range infos {
+		mtl, err := liquid.NewMerkleTreeLiquid(info)
+		if err != nil {
+			log.Printf("could not publish liquid %v: %v\n", info.GetID(), err)
+			continue
+		}
+		content = append(content, mtl.GetProto())
+	}
+
+	if len(content) == 0 {
+		return
+	}
+
+	l.publish(&pbReplication.ChangeEvent{
+		Type:    pbReplication.ChangeEvent_LIQUIDS,
+		KegId:   kegID,
+		Liquids: content,
+		Release: release,
+	})
+}
+
+// PublishKeg records a change to a keg's options or deletion
+func (l *Log) PublishKeg(k keg.IKeg) {
+	pb := k.ToProto()
+	pb.Tree = nil
+
+	l.publish(&pbReplication.ChangeEvent{
+		Type:  pbReplication.ChangeEvent_KEG,
+		KegId: k.GetID(),
+		Keg:   pb,
+	})
+}
+
+// Subscribe returns the events from sequence from of the given epoch on,
+// followed by a channel of new ones, and a function to unsubscribe. If from
+// can't be served from the log the backlog is a single RESYNC event instead.
+// A from of 0 only subscribes to new events.
+func (l *Log) Subscribe(epoch int64, from uint64) ([]*pbReplication.ChangeEvent, <-chan *pbReplication.ChangeEvent, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var backlog []*pbReplication.ChangeEvent
+	if from > 0 && epoch != l.epoch {
+		// The subscriber followed another incarnation of this log, whose
+		// sequence numbers mean nothing here
+		backlog = append(backlog, l.resync())
+	} else if from > 0 && from <= l.sequence {
+		oldest := l.sequence + 1 - uint64(len(l.events))
+		if from >= oldest {
+			backlog = append(backlog, l.events[from-oldest:]...)
+		} else {
+			backlog = append(backlog, l.resync())
+		}
+	} else if from > l.sequence+1 {
+		// The subscriber has seen events we never published, so we have
+		// restarted since and it has to compare state with us instead
+		backlog = append(backlog, l.resync())
+	}
+
+	ch := make(chan *pbReplication.ChangeEvent, subscriberBuffer)
+	if l.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	l.subscribers[ch] = struct{}{}
+
+	return backlog, ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, exist := l.subscribers[ch]; exist {
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// GetEpoch returns when the log was started, which tells its sequence
+// numbers apart from those of the node's earlier runs
+func (l *Log) GetEpoch() int64 {
+	return l.epoch
+}
+
+// GetSequence returns the sequence number of the last published event
+func (l *Log) GetSequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sequence
+}
+
+// Close waits for every subscriber to be sent the events published so far
+// and then ends the subscriptions, so peers following a node that's shutting
+// down don't miss its last writes. Subscriptions are ended when ctx is done
+// even if some events are still pending.
+func (l *Log) Close(ctx context.Context) error {
+	ticker := time.NewTicker(flushCheckInterval)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && !l.flushed() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for ch := range l.subscribers {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+	return err
+}
+
+// flushed reports whether every subscriber has taken its pending events
+func (l *Log) flushed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ch := range l.subscribers {
+		if len(ch) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Log) publish(event *pbReplication.ChangeEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sequence++
+	event.Sequence = l.sequence
+	event.Origin = l.origin
+	event.Epoch = l.epoch
+
+	l.events = append(l.events, event)
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Too slow to keep up, it will reconnect and catch up
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (l *Log) resync() *pbReplication.ChangeEvent {
+	return &pbReplication.ChangeEvent{
+		Type:     pbReplication.ChangeEvent_RESYNC,
+		Sequence: l.sequence,
+		Origin:   l.origin,
+		Epoch:    l.epoch,
+	}
+}
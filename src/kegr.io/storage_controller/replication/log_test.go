@@ -0,0 +1,116 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbReplication "kegr.io/protobuf/model/storage/replication"
+)
+
+func publishN(l *Log, n int) {
+	for i := 0; i < n; i++ {
+		l.publish(&pbReplication.ChangeEvent{Type: pbReplication.ChangeEvent_LIQUIDS})
+	}
+}
+
+func TestSubscribeBacklog(t *testing.T) {
+	l := NewLog("test", 8)
+	publishN(l, 5)
+
+	backlog, _, cancel := l.Subscribe(l.GetEpoch(), 3)
+	defer cancel()
+
+	if len(backlog) != 3 || backlog[0].GetSequence() != 3 {
+		t.Errorf("expected events 3 to 5, got %v", backlog)
+	}
+}
+
+func TestSubscribeResyncWhenTrimmed(t *testing.T) {
+	l := NewLog("test", 4)
+	publishN(l, 10)
+
+	backlog, _, cancel := l.Subscribe(l.GetEpoch(), 2)
+	defer cancel()
+
+	if len(backlog) != 1 || backlog[0].GetType() != pbReplication.ChangeEvent_RESYNC {
+		t.Errorf("expected a resync, got %v", backlog)
+	}
+	if backlog[0].GetSequence() != 10 {
+		t.Errorf("expected resync at 10, got %v", backlog[0].GetSequence())
+	}
+}
+
+func TestSubscribeResyncAfterRestart(t *testing.T) {
+	l := NewLog("test", 4)
+	publishN(l, 2)
+
+	backlog, _, cancel := l.Subscribe(l.GetEpoch(), 50)
+	defer cancel()
+
+	if len(backlog) != 1 || backlog[0].GetType() != pbReplication.ChangeEvent_RESYNC {
+		t.Errorf("expected a resync, got %v", backlog)
+	}
+}
+
+func TestSubscribeResyncOtherEpoch(t *testing.T) {
+	l := NewLog("test", 8)
+	publishN(l, 5)
+
+	backlog, _, cancel := l.Subscribe(l.GetEpoch()-1, 3)
+	defer cancel()
+
+	if len(backlog) != 1 || backlog[0].GetType() != pbReplication.ChangeEvent_RESYNC {
+		t.Errorf("expected a resync, got %v", backlog)
+	}
+	if backlog[0].GetEpoch() != l.GetEpoch() {
+		t.Errorf("expected the resync to carry epoch %v, got %v", l.GetEpoch(), backlog[0].GetEpoch())
+	}
+}
+
+func TestSubscribeLive(t *testing.T) {
+	l := NewLog("test", 4)
+	publishN(l, 2)
+
+	backlog, events, cancel := l.Subscribe(l.GetEpoch(), 0)
+	defer cancel()
+
+	if len(backlog) != 0 {
+		t.Errorf("expected no backlog, got %v", backlog)
+	}
+
+	publishN(l, 1)
+	if event := <-events; event.GetSequence() != 3 || event.GetOrigin() != "test" {
+		t.Errorf("unexpected event %v", event)
+	}
+}
+
+func TestClose(t *testing.T) {
+	l := NewLog("test", 4)
+	_, events, cancel := l.Subscribe(l.GetEpoch(), 0)
+	defer cancel()
+	publishN(l, 2)
+
+	received := make(chan int)
+	go func() {
+		count := 0
+		for range events {
+			count++
+		}
+		received <- count
+	}()
+
+	ctx, cancelClose := context.WithTimeout(context.Background(), time.Second)
+	defer cancelClose()
+	if err := l.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count := <-received; count != 2 {
+		t.Errorf("expected 2 events before closing, got %d", count)
+	}
+
+	_, events, _ = l.Subscribe(l.GetEpoch(), 0)
+	if _, open := <-events; open {
+		t.Error("expected subscribing to a closed log to end right away")
+	}
+}
@@ -0,0 +1,148 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval is how often the files behind a reloader are checked
+// for changes
+const reloadCheckInterval = 10 * time.Second
+
+// fileWatch tells when any of a set of files has changed since it last
+// looked, checking at most once every reloadCheckInterval
+type fileWatch struct {
+	files   []string
+	modTime time.Time
+	checked time.Time
+}
+
+func (fw *fileWatch) changed(now time.Time) bool {
+	if now.Sub(fw.checked) < reloadCheckInterval {
+		return false
+	}
+	fw.checked = now
+
+	var latest time.Time
+	for _, file := range fw.files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	if latest.After(fw.modTime) {
+		fw.modTime = latest
+		return true
+	}
+	return false
+}
+
+// CertReloader serves a certificate and key pair from disk, picking up a
+// rotated pair without a restart
+type CertReloader struct {
+	mu    sync.Mutex
+	cert  *tls.Certificate
+	watch fileWatch
+
+	certFile string
+	keyFile  string
+}
+
+// NewCertReloader loads a certificate and key pair
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		watch:    fileWatch{files: []string{certFile, keyFile}},
+	}
+	if _, err := cr.get(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate is the tls.Config hook for servers
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.get()
+}
+
+// GetClientCertificate is the tls.Config hook for clients
+func (cr *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cr.get()
+}
+
+func (cr *CertReloader) get() (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if cr.watch.changed(time.Now()) || cr.cert == nil {
+		cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+		if err != nil {
+			// Keep serving the previous pair while a rotation is half written
+			if cr.cert != nil {
+				return cr.cert, nil
+			}
+			return nil, err
+		}
+		cr.cert = &cert
+	}
+	return cr.cert, nil
+}
+
+// PoolReloader serves a pool of CA certificates from a PEM file, picking up
+// changes to it without a restart
+type PoolReloader struct {
+	mu    sync.Mutex
+	pool  *x509.CertPool
+	watch fileWatch
+
+	caFile string
+}
+
+// NewPoolReloader loads the CA certificates in a PEM file
+func NewPoolReloader(caFile string) (*PoolReloader, error) {
+	pr := &PoolReloader{
+		caFile: caFile,
+		watch:  fileWatch{files: []string{caFile}},
+	}
+	if _, err := pr.Get(); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Get returns the current pool
+func (pr *PoolReloader) Get() (*x509.CertPool, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.watch.changed(time.Now()) || pr.pool == nil {
+		pool, err := loadPool(pr.caFile)
+		if err != nil {
+			if pr.pool != nil {
+				return pr.pool, nil
+			}
+			return nil, err
+		}
+		pr.pool = pool
+	}
+	return pr.pool, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New("No certificates found in CA file")
+	}
+	return pool, nil
+}
@@ -0,0 +1,124 @@
+package security
+
+import (
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"kegr.io/storage_controller/config"
+)
+
+var (
+	internalServer []grpc.ServerOption
+	internalDial   = grpc.WithInsecure()
+	externalServer []grpc.ServerOption
+	mirrorServer   []grpc.ServerOption
+	mirrorDial     = grpc.WithInsecure()
+)
+
+// Load sets up the credentials of both gRPC services from the config. The
+// internal service runs mutual TLS with certificates signed by the cluster
+// CA, so only cluster members can call it. The external service runs TLS
+// and takes either a bearer token or a client certificate signed by the
+// external CA. The mirror service runs mutual TLS with certificates signed
+// by the mirror CA, which the mirror cluster's nodes are dialled with too,
+// so the two clusters only ever reach each other's mirror service. Services
+// without certificates configured run plaintext. The internal
+// certificate's key also signs what the node vouches for.
+func Load() error {
+	c := config.C
+
+	signer = nil
+	if len(c.TLSCertFile) > 0 {
+		serverTLS, err := ServerTLS(c.TLSCertFile, c.TLSKeyFile, c.TLSCAFile, true)
+		if err != nil {
+			return err
+		}
+		clientTLS, err := ClientTLS(c.TLSCertFile, c.TLSKeyFile, c.TLSCAFile)
+		if err != nil {
+			return err
+		}
+
+		if signer, err = NewCertReloader(c.TLSCertFile, c.TLSKeyFile); err != nil {
+			return err
+		}
+
+		internalServer = []grpc.ServerOption{grpc.Creds(credentials.NewTLS(serverTLS))}
+		internalDial = grpc.WithTransportCredentials(credentials.NewTLS(clientTLS))
+	} else {
+		log.Println("TLS_CERT_FILE is not set, the internal service runs plaintext and unauthenticated")
+	}
+
+	mirrorServer, mirrorDial = nil, grpc.WithInsecure()
+	if len(c.MirrorTLSCertFile) > 0 {
+		serverTLS, err := ServerTLS(c.MirrorTLSCertFile, c.MirrorTLSKeyFile, c.MirrorTLSCAFile, true)
+		if err != nil {
+			return err
+		}
+		clientTLS, err := ClientTLS(c.MirrorTLSCertFile, c.MirrorTLSKeyFile, c.MirrorTLSCAFile)
+		if err != nil {
+			return err
+		}
+
+		mirrorServer = []grpc.ServerOption{grpc.Creds(credentials.NewTLS(serverTLS))}
+		mirrorDial = grpc.WithTransportCredentials(credentials.NewTLS(clientTLS))
+	} else if len(c.MirrorTarget) > 0 || len(c.MirrorOf) > 0 {
+		log.Println("MIRROR_TLS_CERT_FILE is not set, the mirror service runs plaintext and unauthenticated")
+	}
+
+	externalServer = nil
+	if len(c.ExternalTLSCertFile) > 0 {
+		serverTLS, err := ServerTLS(c.ExternalTLSCertFile, c.ExternalTLSKeyFile, c.ExternalTLSCAFile, false)
+		if err != nil {
+			return err
+		}
+		externalServer = append(externalServer, grpc.Creds(credentials.NewTLS(serverTLS)))
+	} else {
+		log.Println("EXTERNAL_TLS_CERT_FILE is not set, the external service runs plaintext")
+	}
+
+	var tokens *TokenStore
+	if len(c.ExternalTokensFile) > 0 {
+		var err error
+		if tokens, err = NewTokenStore(c.ExternalTokensFile); err != nil {
+			return err
+		}
+	}
+
+	if tokens != nil || len(c.ExternalTLSCAFile) > 0 {
+		auth := NewAuthenticator(tokens)
+		externalServer = append(externalServer,
+			grpc.UnaryInterceptor(auth.UnaryInterceptor),
+			grpc.StreamInterceptor(auth.StreamInterceptor),
+		)
+	} else {
+		log.Println("neither EXTERNAL_TOKENS_FILE nor EXTERNAL_TLS_CA_FILE is set, the external service is unauthenticated")
+	}
+
+	return nil
+}
+
+// InternalServerOptions returns the options of the internal gRPC server
+func InternalServerOptions() []grpc.ServerOption {
+	return internalServer
+}
+
+// InternalDialOption returns the option peers are dialled with
+func InternalDialOption() grpc.DialOption {
+	return internalDial
+}
+
+// ExternalServerOptions returns the options of the external gRPC server
+func ExternalServerOptions() []grpc.ServerOption {
+	return externalServer
+}
+
+// MirrorServerOptions returns the options of the mirror gRPC server
+func MirrorServerOptions() []grpc.ServerOption {
+	return mirrorServer
+}
+
+// MirrorDialOption returns the option the mirror cluster is dialled with
+func MirrorDialOption() grpc.DialOption {
+	return mirrorDial
+}
@@ -0,0 +1,89 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+)
+
+// signer holds the certificate of the internal service, whose key vouches
+// for what this node serves
+var signer *CertReloader
+
+// Sign signs a message with the key of the node's internal certificate and
+// returns the signature along with the certificate chain, leaf first. It
+// fails when the internal service runs without a certificate.
+func Sign(message []byte) ([]byte, [][]byte, error) {
+	if signer == nil {
+		return nil, nil, errors.New("No certificate to sign with")
+	}
+
+	cert, err := signer.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("Certificate key can't sign")
+	}
+
+	// Ed25519 signs the message itself, the others its digest
+	var signature []byte
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		signature, err = key.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return signature, cert.Certificate, nil
+}
+
+// VerifySignature checks a message was signed by the key of a certificate
+// chaining up to one of the roots
+func VerifySignature(message, signature []byte, chain [][]byte, roots *x509.CertPool) error {
+	if len(signature) == 0 || len(chain) == 0 {
+		return errors.New("Message isn't signed")
+	}
+
+	certs := make([]*x509.Certificate, len(chain))
+	for i, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return err
+	}
+
+	var algorithm x509.SignatureAlgorithm
+	switch certs[0].PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		algorithm = x509.ECDSAWithSHA256
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+	default:
+		return errors.New("Unsupported certificate key")
+	}
+	return certs[0].CheckSignature(algorithm, message, signature)
+}
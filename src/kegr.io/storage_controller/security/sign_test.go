@@ -0,0 +1,49 @@
+package security
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sign")
+	defer os.RemoveAll(dir)
+
+	ca, other := newTestCA(t, "cluster"), newTestCA(t, "other")
+	certFile, keyFile := ca.issue(t, dir, "node", 2)
+
+	signer = nil
+	if _, _, err := Sign([]byte("root")); err == nil {
+		t.Error("expected signing without a certificate to fail")
+	}
+
+	var err error
+	if signer, err = NewCertReloader(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { signer = nil }()
+
+	signature, chain, err := Sign([]byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots, others := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	others.AddCert(other.cert)
+
+	if err = VerifySignature([]byte("root"), signature, chain, roots); err != nil {
+		t.Errorf("expected the signature to verify: %v", err)
+	}
+	if err = VerifySignature([]byte("other root"), signature, chain, roots); err == nil {
+		t.Error("expected the signature not to verify another message")
+	}
+	if err = VerifySignature([]byte("root"), signature, chain, others); err == nil {
+		t.Error("expected the signature not to verify against another CA")
+	}
+	if err = VerifySignature([]byte("root"), nil, chain, roots); err == nil {
+		t.Error("expected an unsigned message not to verify")
+	}
+}
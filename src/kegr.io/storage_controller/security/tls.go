@@ -0,0 +1,107 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// ServerTLS returns the TLS config of a server presenting the given
+// certificate. With a CA file clients are asked for a certificate signed by
+// it, which they must present when requireClientCert is set.
+func ServerTLS(certFile, keyFile, caFile string, requireClientCert bool) (*tls.Config, error) {
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if len(caFile) == 0 {
+		if requireClientCert {
+			return nil, errors.New("Client certificates require a CA file")
+		}
+		return base, nil
+	}
+
+	cas, err := NewPoolReloader(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	// Every handshake gets a config with the current CA pool, so a rotated
+	// CA is picked up without a restart
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := cas.Get()
+			if err != nil {
+				return nil, err
+			}
+
+			config := base.Clone()
+			config.ClientAuth = clientAuth
+			config.ClientCAs = pool
+			return config, nil
+		},
+	}, nil
+}
+
+// ClientTLS returns the TLS config of a client which verifies servers
+// against the CA file and, given a certificate, presents it to them
+func ClientTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(certFile) > 0 {
+		certs, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = certs.GetClientCertificate
+	}
+
+	if len(caFile) == 0 {
+		return config, nil
+	}
+
+	cas, err := NewPoolReloader(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The standard verification only ever sees the CA pool the config was
+	// created with, so it's replaced by the same checks against the
+	// current pool
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		pool, err := cas.Get()
+		if err != nil {
+			return err
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("Server presented no certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         pool,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+	return config, nil
+}
@@ -0,0 +1,173 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue writes a certificate for localhost signed by the CA and its key
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func (ca *testCA) write(t *testing.T, dir, name string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// handshake connects a client to a server and returns the client's error
+func handshake(t *testing.T, server, client *tls.Config) error {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+		conn.Read(make([]byte, 1))
+	}()
+
+	client.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Client certificates are only checked by the server after the
+	// client's side of the handshake is done, so wait for its verdict
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil
+	}
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "security")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "cluster")
+	caFile := ca.write(t, dir, "ca.crt")
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+
+	server, err := ServerTLS(serverCert, serverKey, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := ClientTLS(clientCert, clientKey, caFile)
+	if err = handshake(t, server, client); err != nil {
+		t.Errorf("expected the handshake to succeed: %v", err)
+	}
+
+	anonymous, _ := ClientTLS("", "", caFile)
+	if err = handshake(t, server, anonymous); err == nil {
+		t.Error("expected a client without a certificate to be rejected")
+	}
+
+	other := newTestCA(t, "other")
+	strangerCert, strangerKey := other.issue(t, dir, "stranger", 4)
+	stranger, _ := ClientTLS(strangerCert, strangerKey, caFile)
+	if err = handshake(t, server, stranger); err == nil {
+		t.Error("expected a certificate from another CA to be rejected")
+	}
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "security")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "cluster")
+	certFile, keyFile := ca.issue(t, dir, "node", 2)
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := cr.get()
+
+	// Rotate the pair and make sure the change is noticed on the next check
+	ca.issue(t, dir, "node", 3)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	cr.watch.checked = time.Time{}
+
+	second, _ := cr.get()
+	if string(first.Certificate[0]) == string(second.Certificate[0]) {
+		t.Error("expected the rotated certificate to be loaded")
+	}
+}
@@ -0,0 +1,159 @@
+package security
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// TokenStore holds the tokens accepted by the external service, one per
+// line in a file which is reloaded when it changes
+type TokenStore struct {
+	mu     sync.Mutex
+	tokens []string
+	watch  fileWatch
+
+	file string
+}
+
+// NewTokenStore loads the tokens in a file
+func NewTokenStore(file string) (*TokenStore, error) {
+	ts := &TokenStore{
+		file:  file,
+		watch: fileWatch{files: []string{file}},
+	}
+	if err := ts.load(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Valid reports whether a token is one of the accepted ones
+func (ts *TokenStore) Valid(token string) bool {
+	ts.mu.Lock()
+	if ts.watch.changed(time.Now()) {
+		ts.mu.Unlock()
+		ts.load()
+		ts.mu.Lock()
+	}
+	tokens := ts.tokens
+	ts.mu.Unlock()
+
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return len(token) > 0 && valid
+}
+
+func (ts *TokenStore) load() error {
+	file, err := os.Open(ts.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if len(token) > 0 && !strings.HasPrefix(token, "#") {
+			tokens = append(tokens, token)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	ts.tokens = tokens
+	ts.mu.Unlock()
+	return nil
+}
+
+// Authenticator lets calls through that carry a valid bearer token or come
+// over a connection whose client certificate was verified
+type Authenticator struct {
+	tokens *TokenStore
+}
+
+// NewAuthenticator returns an authenticator checking tokens against the
+// store. Without a store only client certificates are accepted.
+func NewAuthenticator(tokens *TokenStore) *Authenticator {
+	return &Authenticator{
+		tokens: tokens,
+	}
+}
+
+// UnaryInterceptor authenticates unary calls
+func (a *Authenticator) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authenticates streaming calls
+func (a *Authenticator) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authenticate(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (a *Authenticator) authenticate(ctx context.Context) error {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return nil
+		}
+	}
+
+	if a.tokens != nil {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get(authorizationHeader) {
+				if strings.HasPrefix(value, bearerPrefix) && a.tokens.Valid(strings.TrimPrefix(value, bearerPrefix)) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "Missing or invalid credentials")
+}
+
+// TokenCredentials attaches a bearer token to every call a client makes
+type TokenCredentials struct {
+	Token  string
+	Secure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (tc TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		authorizationHeader: bearerPrefix + tc.Token,
+	}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials. Tokens
+// are only sent in the clear when the connection itself is plaintext.
+func (tc TokenCredentials) RequireTransportSecurity() bool {
+	return tc.Secure
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/mirror"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/state"
+	"kegr.io/storage_controller/sync"
+)
+
+// AdminServer defines the grpc service operators use to inspect the cluster
+type AdminServer struct {
+	is     sync.ISyncService
+	ss     state.IStateService
+	rl     replication.ILog
+	source mirror.ISource
+	target mirror.ITarget
+}
+
+// NewAdminServer returns an initialised admin server object. The mirror
+// source is nil on nodes that don't push to a mirror. Conflicts restored
+// by operators are published to the replication log.
+func NewAdminServer(is sync.ISyncService, ss state.IStateService, rl replication.ILog, source mirror.ISource, target mirror.ITarget) *AdminServer {
+	return &AdminServer{
+		is:     is,
+		ss:     ss,
+		rl:     rl,
+		source: source,
+		target: target,
+	}
+}
+
+// ClusterStatus returns this node's state hash and the health of every peer
+func (as *AdminServer) ClusterStatus(ctx context.Context, req *pb.ClusterStatusRequest) (*pb.ClusterStatusResponse, error) {
+	hash, err := as.ss.GetHash()
+	return &pb.ClusterStatusResponse{
+		Id:    as.is.GetID(),
+		State: hash,
+		Peers: as.is.Status(),
+	}, err
+}
+
+// ForceSync compares every keg with a peer's and applies what it's ahead on
+func (as *AdminServer) ForceSync(ctx context.Context, req *pb.ForceSyncRequest) (*pb.ForceSyncResponse, error) {
+	return &pb.ForceSyncResponse{}, as.is.ForceSync(req.GetPeer())
+}
+
+// EvictPeer declares a peer dead
+func (as *AdminServer) EvictPeer(ctx context.Context, req *pb.EvictPeerRequest) (*pb.EvictPeerResponse, error) {
+	return &pb.EvictPeerResponse{}, as.is.EvictPeer(req.GetPeer())
+}
+
+// DiffWithPeer returns what syncing with a peer would take from it
+func (as *AdminServer) DiffWithPeer(ctx context.Context, req *pb.DiffWithPeerRequest) (*pb.DiffWithPeerResponse, error) {
+	transfers, err := as.is.DiffWithPeer(req.GetPeer())
+	if err != nil {
+		return &pb.DiffWithPeerResponse{}, err
+	}
+	return &pb.DiffWithPeerResponse{
+		Kegs: transfers,
+	}, nil
+}
+
+// MirrorStatus returns how far behind the mirror this node pushes to is,
+// and whether this node is a mirror itself
+func (as *AdminServer) MirrorStatus(ctx context.Context, req *pb.MirrorStatusRequest) (*pb.MirrorStatusResponse, error) {
+	res := &pb.MirrorStatusResponse{}
+	if as.source != nil {
+		res = as.source.Status()
+	}
+	as.target.Status(res)
+	return res, nil
+}
+
+// PromoteMirror makes this mirror node take writes, for failing over
+func (as *AdminServer) PromoteMirror(ctx context.Context, req *pb.PromoteMirrorRequest) (*pb.PromoteMirrorResponse, error) {
+	return &pb.PromoteMirrorResponse{}, as.target.Promote()
+}
+
+// ListConflicts returns the versions of a keg's liquids this node discarded
+// for concurrent edits
+func (as *AdminServer) ListConflicts(ctx context.Context, req *pb.ListConflictsRequest) (*pb.ListConflictsResponse, error) {
+	conflicts, err := as.ss.ListConflicts(req.GetKegId())
+	return &pb.ListConflictsResponse{
+		Conflicts: conflicts,
+	}, err
+}
+
+// ResolveConflict forgets a conflict, restoring the discarded version when
+// asked to. Restoring is a write, so a mirror only does it once promoted.
+func (as *AdminServer) ResolveConflict(ctx context.Context, req *pb.ResolveConflictRequest) (*pb.ResolveConflictResponse, error) {
+	if req.GetRestore() && as.target.IsReadOnly() {
+		return &pb.ResolveConflictResponse{}, status.Error(codes.FailedPrecondition, "Cluster is a mirror, promote it to take writes")
+	}
+
+	restored, err := as.ss.ResolveConflict(req.GetKegId(), req.GetConflictId(), req.GetRestore())
+	if err != nil {
+		return &pb.ResolveConflictResponse{}, err
+	}
+	if restored != nil {
+		as.rl.PublishLiquids(req.GetKegId(), 0, []liquid.IInfo{restored.GetLiquidInfo()})
+	}
+	return &pb.ResolveConflictResponse{}, nil
+}
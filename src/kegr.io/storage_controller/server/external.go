@@ -2,27 +2,54 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
+	pbMerkle "kegr.io/protobuf/model/merkle"
 	pbKeg "kegr.io/protobuf/model/storage/keg"
 	pbLiquid "kegr.io/protobuf/model/storage/liquid"
 	pbServer "kegr.io/protobuf/server/storage"
 	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/mirror"
 	"kegr.io/storage_controller/model/keg"
 	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/replication"
+	"kegr.io/storage_controller/security"
 	"kegr.io/storage_controller/state"
+	"kegr.io/storage_controller/sync"
 	"kegr.io/storage_controller/util"
 )
 
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 1000
+)
+
 // Server defines the grpc service
 type ExternalServer struct {
 	ss state.IStateService
+	is sync.ISyncService
+	rl replication.ILog
+	mt mirror.ITarget
+
+	// draining is set once the node is shutting down
+	draining int32
 }
 
-func NewExternalServer(ss state.IStateService) *ExternalServer {
+// NewExternalServer returns an initialised external server object which
+// publishes every write it makes to the replication log. Reads of kegs this
+// node doesn't own are proxied to their owners. Writes are turned away on
+// a mirror that hasn't been promoted.
+func NewExternalServer(ss state.IStateService, is sync.ISyncService, rl replication.ILog, mt mirror.ITarget) *ExternalServer {
 	return &ExternalServer{
 		ss: ss,
+		is: is,
+		rl: rl,
+		mt: mt,
 	}
 }
 
@@ -30,31 +57,56 @@ func NewExternalServer(ss state.IStateService) *ExternalServer {
 func (es *ExternalServer) CreateLiquid(ctx context.Context, req *pbServer.CreateLiquidRequest) (*pbServer.CreateLiquidResponse, error) {
 	liquid := liquid.FromProto(req.GetLiquid())
 	liquid.SetID(util.ID())
-	liquid.SetLastUpdated(time.Now().Unix())
+	liquid.SetSize(int64(len(liquid.GetContent())))
+	liquid.SetFileHash(util.GetContentHash(liquid.GetContent()))
+	liquid.Touch()
+
+	name, err := cleanName(liquid.GetOptions().GetName())
+	if err != nil {
+		return &pbServer.CreateLiquidResponse{}, err
+	}
+	liquid.GetOptions().SetName(name)
 
 	keg, err := es.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pbServer.CreateLiquidResponse{}, err
 	}
 
-	err = liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, keg.GetID()))
+	err = func() error {
+		defer keg.LockWrites()()
+
+		err := liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, keg.GetID()))
+		if err != nil {
+			return err
+		}
+
+		if err = keg.AddLiquid(liquid.GetLiquidInfo()); err != nil {
+			return err
+		}
+
+		es.publishLiquids(keg, 0, liquid.GetID())
+		return nil
+	}()
 	if err != nil {
 		return &pbServer.CreateLiquidResponse{}, err
 	}
 
-	err = keg.AddLiquid(liquid.GetLiquidInfo())
-	return &pbServer.CreateLiquidResponse{}, err
+	return &pbServer.CreateLiquidResponse{}, es.replicate(keg, liquid, req.GetConsistency())
 }
 
 // GetLiquid returns the merkle tree of this server
 func (es *ExternalServer) GetLiquid(ctx context.Context, req *pbServer.GetLiquidRequest) (*pbServer.GetLiquidResponse, error) {
-	_, err := es.ss.GetKegByID(req.GetKegId())
+	keg, err := es.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pbServer.GetLiquidResponse{}, err
 	}
 
-	liquid, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
-
+	var liquid liquid.ILiquid
+	if level := sync.Consistency(req.GetConsistency(), keg.GetOptions().GetReadConsistency()); level != pbKeg.Consistency_ONE {
+		liquid, err = es.is.ReadFromOwners(keg, req.GetLiquidId(), level)
+	} else {
+		liquid, err = es.loadLiquid(keg, req.GetLiquidId())
+	}
 	if err != nil {
 		return &pbServer.GetLiquidResponse{}, err
 	}
@@ -64,74 +116,159 @@ func (es *ExternalServer) GetLiquid(ctx context.Context, req *pbServer.GetLiquid
 	}, nil
 }
 
+// GetLiquidProof proves the version of a liquid the keg's owners hold is
+// part of the keg's tree, vouching for the tree's root with the certificate
+// of the node proving it
+func (es *ExternalServer) GetLiquidProof(ctx context.Context, req *pbServer.GetLiquidProofRequest) (*pbServer.GetLiquidProofResponse, error) {
+	keg, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pbServer.GetLiquidProofResponse{}, err
+	}
+
+	if !es.is.IsOwner(keg) {
+		return es.is.GetLiquidProofFromOwners(keg, req)
+	}
+
+	return proveLiquid(es.is.GetID(), keg, req.GetLiquidId())
+}
+
 // UpdateLiquid updates a liquid
 func (es *ExternalServer) UpdateLiquid(ctx context.Context, req *pbServer.UpdateLiquidRequest) (*pbServer.UpdateLiquidResponse, error) {
 	l := req.GetLiquid()
 	liquid := liquid.FromProto(l)
-	liquid.SetLastUpdated(time.Now().Unix())
+	liquid.SetID(req.GetLiquidId())
+	liquid.SetSize(int64(len(liquid.GetContent())))
+	liquid.SetFileHash(util.GetContentHash(liquid.GetContent()))
+	liquid.Touch()
+
+	name, err := cleanName(liquid.GetOptions().GetName())
+	if err != nil {
+		return &pbServer.UpdateLiquidResponse{}, err
+	}
+	liquid.GetOptions().SetName(name)
 
 	keg, err := es.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pbServer.UpdateLiquidResponse{}, err
 	}
 
-	err = liquid.ToFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
+	err = func() error {
+		defer keg.LockWrites()()
+
+		if current, err := keg.GetLiquidInfoByID(liquid.GetID()); err == nil {
+			liquid.SetPrevious(current)
+		}
+
+		err := liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, req.GetKegId()))
+		if err != nil {
+			return err
+		}
+
+		if err = keg.UpdateLiquid(liquid.GetLiquidInfo()); err != nil {
+			return err
+		}
+
+		es.publishLiquids(keg, 0, liquid.GetID())
+		return nil
+	}()
 	if err != nil {
 		return &pbServer.UpdateLiquidResponse{}, err
 	}
 
-	err = keg.UpdateLiquid(liquid.GetLiquidInfo())
-	return &pbServer.UpdateLiquidResponse{}, err
+	return &pbServer.UpdateLiquidResponse{}, es.replicate(keg, liquid, req.GetConsistency())
 }
 
-// UpdateLiquidOptions updates the options of a liquid
+// UpdateLiquidOptions updates the options of a liquid. On a node that
+// doesn't own the keg the update is made by one of its owners.
 func (es *ExternalServer) UpdateLiquidOptions(ctx context.Context, req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error) {
-	options := liquid.OptionsFromProto(req.GetOptions())
-	keg, err := es.ss.GetKegByID(req.GetKegId())
-
+	k, err := es.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pbServer.UpdateLiquidOptionsResponse{}, err
 	}
 
-	liquid, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
+	if !es.is.IsOwner(k) {
+		return es.is.UpdateLiquidOptionsOnOwners(k, req)
+	}
+	return es.updateOwnedLiquidOptions(k, req)
+}
+
+// updateOwnedLiquidOptions updates the options of a liquid of a keg this
+// node owns
+func (es *ExternalServer) updateOwnedLiquidOptions(k keg.IKeg, req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error) {
+	options := liquid.OptionsFromProto(req.GetOptions())
+	name, err := cleanName(options.GetName())
 	if err != nil {
 		return &pbServer.UpdateLiquidOptionsResponse{}, err
 	}
+	options.SetName(name)
+
+	var updated liquid.ILiquid
+	err = func() error {
+		defer k.LockWrites()()
+
+		liquid, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, k.GetID(), req.GetLiquidId(), config.C.LiquidExtension))
+		if err != nil {
+			return err
+		}
+
+		liquid.Touch()
+		liquid.SetOptions(options)
+
+		err = liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID()))
+		if err != nil {
+			return err
+		}
 
-	liquid.SetLastUpdated(time.Now().Unix())
-	liquid.SetOptions(options)
+		if err = k.UpdateLiquid(liquid.GetLiquidInfo()); err != nil {
+			return err
+		}
 
-	err = liquid.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, req.GetKegId()))
+		es.publishLiquids(k, 0, liquid.GetID())
+		updated = liquid
+		return nil
+	}()
 	if err != nil {
 		return &pbServer.UpdateLiquidOptionsResponse{}, err
 	}
 
-	err = keg.UpdateLiquid(liquid.GetLiquidInfo())
-	return &pbServer.UpdateLiquidOptionsResponse{}, err
+	return &pbServer.UpdateLiquidOptionsResponse{}, es.replicate(k, updated, req.GetConsistency())
 }
 
-// DeleteLiquid marks the liquid as deleted
+// DeleteLiquid marks the liquid as deleted. On a node that doesn't own the
+// keg the delete is made by one of its owners.
 func (es *ExternalServer) DeleteLiquid(ctx context.Context, req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error) {
-	keg, err := es.ss.GetKegByID(req.GetKegId())
+	k, err := es.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pbServer.DeleteLiquidResponse{}, err
 	}
 
-	liquid, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
-	if err != nil || liquid.IsDeleted() {
-		return &pbServer.DeleteLiquidResponse{}, err
+	if !es.is.IsOwner(k) {
+		return es.is.DeleteLiquidOnOwners(k, req)
 	}
+	return es.deleteOwnedLiquid(k, req)
+}
+
+// deleteOwnedLiquid marks a liquid of a keg this node owns as deleted
+func (es *ExternalServer) deleteOwnedLiquid(k keg.IKeg, req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error) {
+	err := func() error {
+		defer k.LockWrites()()
 
-	liquid.SetLastUpdated(time.Now().Unix())
-	liquid.SetDeleted(true)
+		if err := deleteLiquid(k, req.GetLiquidId()); err != nil {
+			return err
+		}
 
-	err = liquid.ToFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
+		es.publishLiquids(k, 0, req.GetLiquidId())
+		return nil
+	}()
 	if err != nil {
 		return &pbServer.DeleteLiquidResponse{}, err
 	}
 
-	err = keg.UpdateLiquid(liquid.GetLiquidInfo())
-	return &pbServer.DeleteLiquidResponse{}, err
+	deleted, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, k.GetID(), req.GetLiquidId(), config.C.LiquidExtension))
+	if err != nil {
+		return &pbServer.DeleteLiquidResponse{}, err
+	}
+	return &pbServer.DeleteLiquidResponse{}, es.replicate(k, deleted, req.GetConsistency())
 }
 
 // CreateKeg returns the merkle tree of this server
@@ -141,6 +278,8 @@ func (es *ExternalServer) CreateKeg(ctx context.Context, req *pbServer.CreateKeg
 	if err != nil {
 		return &pbServer.CreateKegResponse{}, err
 	}
+
+	es.rl.PublishKeg(keg)
 	return &pbServer.CreateKegResponse{
 		KegID: keg.GetID(),
 	}, nil
@@ -179,7 +318,56 @@ func (es *ExternalServer) GetKegLiquids(ctx context.Context, req *pbServer.GetKe
 		return &pbServer.GetKegLiquidsResponse{}, err
 	}
 
-	liquids := keg.GetLiquids()
+	if !es.is.IsOwner(keg) {
+		return es.is.GetKegLiquidsFromOwners(keg, req)
+	}
+
+	return getKegLiquids(keg), nil
+}
+
+// ListLiquids returns a page of the live liquids in a keg whose access name
+// starts with the requested prefix, rolling names up to the delimiter
+func (es *ExternalServer) ListLiquids(ctx context.Context, req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error) {
+	keg, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pbServer.ListLiquidsResponse{}, err
+	}
+
+	if !es.is.IsOwner(keg) {
+		return es.is.ListLiquidsFromOwners(keg, req)
+	}
+
+	return listLiquids(keg, req)
+}
+
+// proveLiquid returns the authentication path of a liquid in its keg's tree
+// along with the tree's root, signed by this node when it has a certificate
+func proveLiquid(nodeID string, k keg.IKeg, liquidID string) (*pbServer.GetLiquidProofResponse, error) {
+	proof, err := k.GetTree().Prove([]byte(liquidID))
+	if err != nil {
+		return &pbServer.GetLiquidProofResponse{}, err
+	}
+
+	root := &pbMerkle.SignedRoot{
+		KegId:  k.GetID(),
+		Hash:   proof.GetRoot(),
+		Signed: time.Now().Unix(),
+		Signer: nodeID,
+	}
+	if len(config.C.TLSCertFile) > 0 {
+		if root.Signature, root.Certificates, err = security.Sign(merkle.RootMessage(root.KegId, root.Hash, root.Signed)); err != nil {
+			return &pbServer.GetLiquidProofResponse{}, err
+		}
+	}
+
+	return &pbServer.GetLiquidProofResponse{
+		Proof: proof,
+		Root:  root,
+	}, nil
+}
+
+func getKegLiquids(k keg.IKeg) *pbServer.GetKegLiquidsResponse {
+	liquids := k.GetLiquids()
 	var infos []*pbLiquid.Info
 
 	for _, liq := range liquids {
@@ -188,18 +376,204 @@ func (es *ExternalServer) GetKegLiquids(ctx context.Context, req *pbServer.GetKe
 
 	return &pbServer.GetKegLiquidsResponse{
 		Liquids: infos,
+	}
+}
+
+func listLiquids(k keg.IKeg, req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error) {
+	startAfter, err := base64.RawURLEncoding.DecodeString(req.GetPageToken())
+	if err != nil {
+		return &pbServer.ListLiquidsResponse{}, errors.New("Invalid page token")
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	liquids, prefixes, last := k.ListLiquids(req.GetPrefix(), req.GetDelimiter(), string(startAfter), pageSize)
+	infos := make([]*pbLiquid.Info, 0, len(liquids))
+	for _, liq := range liquids {
+		infos = append(infos, liq.ToProto())
+	}
+
+	return &pbServer.ListLiquidsResponse{
+		Liquids:        infos,
+		CommonPrefixes: prefixes,
+		NextPageToken:  base64.RawURLEncoding.EncodeToString([]byte(last)),
 	}, nil
 }
 
 // UpdateKegOptions updates the options of a Keg
 func (es *ExternalServer) UpdateKegOptions(ctx context.Context, req *pbServer.UpdateKegOptionsRequest) (*pbServer.UpdateKegOptionsResponse, error) {
 	options := keg.OptionsFromProto(req.GetOptions())
-	es.ss.UpdateKeg(req.GetKegId(), options)
+	if err := es.ss.UpdateKeg(req.GetKegId(), options); err != nil {
+		return &pbServer.UpdateKegOptionsResponse{}, err
+	}
+
+	es.publishKeg(req.GetKegId())
 	return &pbServer.UpdateKegOptionsResponse{}, nil
 }
 
 // DeleteKeg marks the Keg as deleted
 func (es *ExternalServer) DeleteKeg(ctx context.Context, req *pbServer.DeleteKegRequest) (*pbServer.DeleteKegResponse, error) {
-	err := es.ss.DeleteKeg(req.GetKegId())
-	return &pbServer.DeleteKegResponse{}, err
+	if err := es.ss.DeleteKeg(req.GetKegId()); err != nil {
+		return &pbServer.DeleteKegResponse{}, err
+	}
+
+	es.publishKeg(req.GetKegId())
+	return &pbServer.DeleteKegResponse{}, nil
+}
+
+// CreateKegSnapshot freezes the current contents of a keg. Snapshots are
+// kept by the keg's owners, as only they hold its liquids.
+func (es *ExternalServer) CreateKegSnapshot(ctx context.Context, req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error) {
+	k, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pbServer.CreateKegSnapshotResponse{}, err
+	}
+
+	if !es.is.IsOwner(k) {
+		return es.is.CreateKegSnapshotOnOwners(k, req)
+	}
+	return es.createOwnedKegSnapshot(req)
+}
+
+func (es *ExternalServer) createOwnedKegSnapshot(req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error) {
+	snapshot, err := es.ss.CreateKegSnapshot(req.GetKegId(), req.GetDescription())
+	if err != nil {
+		return &pbServer.CreateKegSnapshotResponse{}, err
+	}
+	return &pbServer.CreateKegSnapshotResponse{
+		Snapshot: snapshot.GetInfo(),
+	}, nil
+}
+
+// ListKegSnapshots returns all snapshots of a keg
+func (es *ExternalServer) ListKegSnapshots(ctx context.Context, req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error) {
+	k, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pbServer.ListKegSnapshotsResponse{}, err
+	}
+
+	if !es.is.IsOwner(k) {
+		return es.is.ListKegSnapshotsFromOwners(k, req)
+	}
+	return es.listOwnedKegSnapshots(req)
+}
+
+func (es *ExternalServer) listOwnedKegSnapshots(req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error) {
+	snapshots, err := es.ss.ListKegSnapshots(req.GetKegId())
+	return &pbServer.ListKegSnapshotsResponse{
+		Snapshots: snapshots,
+	}, err
+}
+
+// RestoreKegSnapshot rolls a keg back to a snapshot
+func (es *ExternalServer) RestoreKegSnapshot(ctx context.Context, req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error) {
+	k, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pbServer.RestoreKegSnapshotResponse{}, err
+	}
+
+	if !es.is.IsOwner(k) {
+		return es.is.RestoreKegSnapshotOnOwners(k, req)
+	}
+	return es.restoreOwnedKegSnapshot(k, req)
+}
+
+func (es *ExternalServer) restoreOwnedKegSnapshot(k keg.IKeg, req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error) {
+	updated, deleted, err := es.ss.RestoreKegSnapshot(k.GetID(), req.GetSnapshotId())
+	es.publishKeg(k.GetID())
+	es.publishLiquids(k, 0, append(updated, deleted...)...)
+	return &pbServer.RestoreKegSnapshotResponse{
+		Updated: updated,
+		Deleted: deleted,
+	}, err
+}
+
+// cleanName validates a liquid name, keeping an empty name as is
+func cleanName(name string) (string, error) {
+	if len(name) == 0 {
+		return name, nil
+	}
+	return liquid.CleanName(name)
+}
+
+// deleteLiquid marks a liquid as deleted on disk and in the keg. Deleting an
+// already deleted liquid is a no-op. The caller holds the keg's write lock.
+func deleteLiquid(k keg.IKeg, liquidID string) error {
+	l, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, k.GetID(), liquidID, config.C.LiquidExtension))
+	if err != nil || l.IsDeleted() {
+		return err
+	}
+
+	l.Touch()
+	l.SetDeleted(true)
+
+	if err = l.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID())); err != nil {
+		return err
+	}
+
+	return k.UpdateLiquid(l.GetLiquidInfo())
+}
+
+// loadLiquid reads a liquid from disk, or from one of its keg's owners when
+// this node doesn't own the keg and so may not have the latest version
+func (es *ExternalServer) loadLiquid(k keg.IKeg, liquidID string) (liquid.ILiquid, error) {
+	if !es.is.IsOwner(k) {
+		if l, err := es.is.GetLiquidFromOwners(k, liquidID); err == nil {
+			return l, nil
+		}
+		return readLiquid(es.is, k, liquidID, false)
+	}
+	return readLiquid(es.is, k, liquidID, true)
+}
+
+// readLiquid reads a liquid from disk. When its file is missing and repair
+// is set it's fetched from the keg's other owners instead, as the write may
+// not have reached this node yet.
+func readLiquid(is sync.ISyncService, k keg.IKeg, liquidID string, repair bool) (liquid.ILiquid, error) {
+	l, err := k.ReadLiquid(liquidID)
+	if err == nil || !repair || !os.IsNotExist(err) {
+		return l, err
+	}
+
+	if repaired, repairErr := is.RepairLiquid(k, liquidID); repairErr == nil {
+		return repaired, nil
+	}
+	return nil, err
+}
+
+// replicate waits for a liquid written through this node to be stored on as
+// many of its keg's owners as the write's consistency level requires. It's
+// called once the keg's write lock is released, as the owners take theirs.
+func (es *ExternalServer) replicate(k keg.IKeg, l liquid.ILiquid, requested pbKeg.Consistency) error {
+	level := sync.Consistency(requested, k.GetOptions().GetWriteConsistency())
+	if level == pbKeg.Consistency_ONE {
+		return nil
+	}
+	return es.is.StoreOnOwners(k, l, level)
+}
+
+// publishLiquids pushes the current state of the given liquids of a keg
+// to the replication log
+func (es *ExternalServer) publishLiquids(k keg.IKeg, release int64, ids ...string) {
+	var infos []liquid.IInfo
+	for _, id := range ids {
+		if info, err := k.GetLiquidInfoByID(id); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	es.rl.PublishLiquids(k.GetID(), release, infos)
+}
+
+// publishKeg pushes the current options and status of a keg to the
+// replication log
+func (es *ExternalServer) publishKeg(kegID string) {
+	if k, err := es.ss.GetKegByID(kegID); err == nil {
+		es.rl.PublishKeg(k)
+	}
 }
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/archive"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+const archiveChunkSize = 1 << 20
+
+// UploadArchive extracts every file in a zip or tar(.gz) archive into a keg as
+// individual liquids named after the entry's path. Live liquids with the same
+// name and extension are updated in place. In replace mode every other live
+// liquid in the keg is deleted afterwards, except the ones whose entry failed
+// to extract.
+func (es *ExternalServer) UploadArchive(stream pbServer.External_UploadArchiveServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	keg, err := es.ss.GetKegByID(first.GetKegId())
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile("", "kegr-archive")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	for req := first; ; {
+		if _, err = file.Write(req.GetChunk()); err != nil {
+			return err
+		}
+		if req, err = stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// The whole archive is applied as one write, so other writes to the keg
+	// don't interleave with it
+	defer keg.LockWrites()()
+
+	existing := make(map[string]string)
+	for id, info := range keg.GetLiquids() {
+		if !info.IsDeleted() {
+			existing[fullName(info.GetName(), info.GetExt())] = id
+		}
+	}
+
+	written := make(map[string]bool)
+	kept := make(map[string]bool)
+	var results []*pbServer.ArchiveEntryResult
+
+	err = archive.Walk(file, first.GetFormat(), func(entry string, content io.Reader) error {
+		result := &pbServer.ArchiveEntryResult{Name: entry}
+		results = append(results, result)
+
+		id, err := extractLiquid(keg, entry, content, existing)
+		if err != nil {
+			result.Error = err.Error()
+			// The liquid the entry was meant to update isn't stale
+			if name, err := liquid.CleanName(entry); err == nil {
+				if id, exist := existing[name]; exist {
+					kept[id] = true
+				}
+			}
+			return nil
+		}
+		result.LiquidId = id
+		written[id] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var deleted []string
+	if first.GetReplace() {
+		var stale []string
+		for id, info := range keg.GetLiquids() {
+			if !info.IsDeleted() && !written[id] && !kept[id] {
+				stale = append(stale, id)
+			}
+		}
+
+		for _, id := range stale {
+			if err = deleteLiquid(keg, id); err != nil {
+				break
+			}
+			deleted = append(deleted, id)
+		}
+	}
+
+	// Whatever was changed reaches the peers, even when a delete failed
+	var changed []string
+	for id := range written {
+		changed = append(changed, id)
+	}
+	es.publishLiquids(keg, 0, append(changed, deleted...)...)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pbServer.UploadArchiveResponse{
+		Results: results,
+		Deleted: deleted,
+	})
+}
+
+// DownloadArchive streams every live liquid in a keg, optionally limited to a
+// name prefix, as a zip or tar(.gz) archive. Liquids are read from disk one at
+// a time so the keg is never held in memory as a whole. Liquids sharing a
+// name and extension get the liquid id appended so every entry is unique.
+func (es *ExternalServer) DownloadArchive(req *pbServer.DownloadArchiveRequest, stream pbServer.External_DownloadArchiveServer) error {
+	keg, err := es.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return err
+	}
+
+	format := req.GetFormat()
+	if len(format) == 0 {
+		format = archive.Zip
+	}
+
+	buffered := bufio.NewWriterSize(&chunkWriter{stream: stream}, archiveChunkSize)
+	aw, err := archive.NewWriter(buffered, format)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	pageToken := ""
+	for {
+		page, err := es.ListLiquids(stream.Context(), &pbServer.ListLiquidsRequest{
+			KegId:     keg.GetID(),
+			Prefix:    req.GetPrefix(),
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, info := range page.GetLiquids() {
+			l, err := es.loadLiquid(keg, info.GetId())
+			if err != nil {
+				return err
+			}
+
+			name := fullName(l.GetOptions().GetName(), l.GetOptions().GetExt())
+			if used[name] {
+				name = fullName(fmt.Sprintf("%s-%s", l.GetOptions().GetName(), l.GetID()), l.GetOptions().GetExt())
+			}
+			used[name] = true
+
+			if err = aw.Add(name, clock.ToTime(l.GetLastUpdated()), l.GetContent()); err != nil {
+				return err
+			}
+		}
+
+		if len(page.GetNextPageToken()) == 0 {
+			break
+		}
+		pageToken = page.GetNextPageToken()
+	}
+
+	if err = aw.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// chunkWriter sends everything written to it down a DownloadArchive stream,
+// splitting it so no message exceeds archiveChunkSize
+type chunkWriter struct {
+	stream pbServer.External_DownloadArchiveServer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + archiveChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := cw.stream.Send(&pbServer.DownloadArchiveResponse{Chunk: p[written:end]}); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// extractLiquid writes a single archive entry to the keg, reusing the id of a
+// live liquid with the same name so the upload acts as an update
+func extractLiquid(k keg.IKeg, entry string, r io.Reader, existing map[string]string) (string, error) {
+	entry, err := liquid.CleanName(entry)
+	if err != nil {
+		return "", err
+	}
+
+	ext := path.Ext(entry)
+	name := strings.TrimSuffix(entry, ext)
+	ext = strings.TrimPrefix(ext, ".")
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	options := liquid.NewOptions()
+	options.SetName(name)
+	options.SetExt(ext)
+	options.SetCache(k.GetOptions().GetCache())
+	options.SetGzip(k.GetOptions().GetGzip())
+
+	id, exist := existing[entry]
+	if !exist {
+		id = util.ID()
+	}
+
+	l := liquid.NewLiquid()
+	l.SetID(id)
+	l.SetContent(content)
+	l.SetSize(int64(len(content)))
+	l.SetFileHash(util.GetContentHash(content))
+	l.Touch()
+	l.SetOptions(options)
+	if current, err := k.GetLiquidInfoByID(id); err == nil {
+		l.SetPrevious(current)
+	}
+
+	if err = l.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID())); err != nil {
+		return "", err
+	}
+	existing[entry] = id
+
+	return id, k.UpdateLiquid(l.GetLiquidInfo())
+}
+
+// fullName joins a liquid's name and extension back into its file name
+func fullName(name, ext string) string {
+	if len(ext) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%s", name, ext)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readMethods are the external calls still served while draining, and by
+// mirrors
+var readMethods = map[string]bool{
+	"GetLiquid":        true,
+	"GetLiquidProof":   true,
+	"GetKeg":           true,
+	"GetKegs":          true,
+	"GetKegLiquids":    true,
+	"ListLiquids":      true,
+	"DownloadArchive":  true,
+	"ListKegSnapshots": true,
+	"ListReleases":     true,
+}
+
+// Drain stops the server from accepting writes, so none are made that
+// can't be replicated before the node goes away. Reads are still served.
+func (es *ExternalServer) Drain() {
+	atomic.StoreInt32(&es.draining, 1)
+}
+
+func (es *ExternalServer) accepts(fullMethod string) error {
+	if readMethods[path.Base(fullMethod)] {
+		return nil
+	}
+	if atomic.LoadInt32(&es.draining) == 1 {
+		return status.Error(codes.Unavailable, "Node is shutting down")
+	}
+	if es.mt.IsReadOnly() {
+		return status.Error(codes.FailedPrecondition, "Cluster is a mirror, promote it to take writes")
+	}
+	return nil
+}
+
+// UnaryInterceptor rejects unary writes while draining or on a mirror
+func (es *ExternalServer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := es.accepts(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects streaming writes while draining or on a mirror
+func (es *ExternalServer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := es.accepts(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
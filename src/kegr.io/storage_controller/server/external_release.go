@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/util"
+)
+
+// CreateRelease starts a release draft for a keg
+func (es *ExternalServer) CreateRelease(ctx context.Context, req *pbServer.CreateReleaseRequest) (*pbServer.CreateReleaseResponse, error) {
+	draft, err := es.ss.CreateReleaseDraft(req.GetKegId(), req.GetDescription())
+	if err != nil {
+		return &pbServer.CreateReleaseResponse{}, err
+	}
+	return &pbServer.CreateReleaseResponse{
+		DraftId: draft.GetID(),
+	}, nil
+}
+
+// StageLiquid stages a created or updated liquid in a release draft
+func (es *ExternalServer) StageLiquid(ctx context.Context, req *pbServer.StageLiquidRequest) (*pbServer.StageLiquidResponse, error) {
+	liquid := liquid.FromProto(req.GetLiquid())
+	if !liquid.IsDeleted() {
+		liquid.SetSize(int64(len(liquid.GetContent())))
+		liquid.SetFileHash(util.GetContentHash(liquid.GetContent()))
+	}
+
+	name, err := cleanName(liquid.GetOptions().GetName())
+	if err != nil {
+		return &pbServer.StageLiquidResponse{}, err
+	}
+	liquid.GetOptions().SetName(name)
+
+	liquidID, err := es.ss.StageLiquid(req.GetKegId(), req.GetDraftId(), liquid)
+	return &pbServer.StageLiquidResponse{
+		LiquidId: liquidID,
+	}, err
+}
+
+// StageLiquidDeletion stages the deletion of a liquid in a release draft
+func (es *ExternalServer) StageLiquidDeletion(ctx context.Context, req *pbServer.StageLiquidDeletionRequest) (*pbServer.StageLiquidDeletionResponse, error) {
+	err := es.ss.StageLiquidDeletion(req.GetKegId(), req.GetDraftId(), req.GetLiquidId())
+	return &pbServer.StageLiquidDeletionResponse{}, err
+}
+
+// CommitRelease atomically applies a release draft to its keg
+func (es *ExternalServer) CommitRelease(ctx context.Context, req *pbServer.CommitReleaseRequest) (*pbServer.CommitReleaseResponse, error) {
+	release, err := es.ss.CommitRelease(req.GetKegId(), req.GetDraftId())
+	if err != nil {
+		return &pbServer.CommitReleaseResponse{}, err
+	}
+
+	es.publishRelease(release)
+	return &pbServer.CommitReleaseResponse{
+		Release: release.ToProto(),
+	}, nil
+}
+
+// ListReleases returns all releases of a keg
+func (es *ExternalServer) ListReleases(ctx context.Context, req *pbServer.ListReleasesRequest) (*pbServer.ListReleasesResponse, error) {
+	releases, err := es.ss.ListReleases(req.GetKegId())
+	if err != nil {
+		return &pbServer.ListReleasesResponse{}, err
+	}
+
+	var pbReleases []*pbRelease.Release
+	for _, r := range releases {
+		pbReleases = append(pbReleases, r.ToProto())
+	}
+
+	return &pbServer.ListReleasesResponse{
+		Releases: pbReleases,
+	}, nil
+}
+
+// RevertRelease undoes a release by committing a new one
+func (es *ExternalServer) RevertRelease(ctx context.Context, req *pbServer.RevertReleaseRequest) (*pbServer.RevertReleaseResponse, error) {
+	release, err := es.ss.RevertRelease(req.GetKegId(), req.GetNumber())
+	if err != nil {
+		return &pbServer.RevertReleaseResponse{}, err
+	}
+
+	es.publishRelease(release)
+	return &pbServer.RevertReleaseResponse{
+		Release: release.ToProto(),
+	}, nil
+}
+
+// publishRelease pushes every liquid a release changed as a single event
+func (es *ExternalServer) publishRelease(r release.IRelease) {
+	k, err := es.ss.GetKegByID(r.GetKegID())
+	if err != nil {
+		return
+	}
+
+	var ids []string
+	for _, change := range r.GetChanges() {
+		ids = append(ids, change.GetLiquidId())
+	}
+	es.publishLiquids(k, r.GetNumber(), ids...)
+}
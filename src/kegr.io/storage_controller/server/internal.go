@@ -2,26 +2,35 @@ package server
 
 import (
 	"context"
-	"fmt"
 
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	pbRelease "kegr.io/protobuf/model/storage/release"
 	pb "kegr.io/protobuf/server/storage"
-	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/replication"
 	"kegr.io/storage_controller/state"
 	"kegr.io/storage_controller/sync"
 )
 
+// maxNodeHashDepth caps how many levels of a merkle tree a peer can ask for
+// in one go, and with it the size of the response
+const maxNodeHashDepth = 8
+
 // InternalServer defines the grpc service
 type InternalServer struct {
 	is sync.ISyncService
 	ss state.IStateService
+	rl replication.ILog
 }
 
 // NewInternalServer returns an initialised internal server object
-func NewInternalServer(is sync.ISyncService, ss state.IStateService) *InternalServer {
+func NewInternalServer(is sync.ISyncService, ss state.IStateService, rl replication.ILog) *InternalServer {
 	return &InternalServer{
 		is: is,
 		ss: ss,
+		rl: rl,
 	}
 }
 
@@ -29,22 +38,39 @@ func NewInternalServer(is sync.ISyncService, ss state.IStateService) *InternalSe
 func (is *InternalServer) Ping(ctx context.Context, ping *pb.PingRequest) (*pb.PingResponse, error) {
 	hash, err := is.ss.GetHash()
 	return &pb.PingResponse{
-		State: hash,
+		State:       hash,
+		Timestamp:   clock.Now(),
+		HashVersion: merkle.HashVersion,
 	}, err
 }
 
 // Register is the healthcheck other instances use to make sure
 // they're on this instances radar
 func (is *InternalServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	others := is.is.GetClientAddresses()
-	is.is.AddClient(req.Id, req.Address)
+	incarnation := is.is.Join(req.GetId(), req.GetAddress(), req.GetZone(), req.GetIncarnation())
 	return &pb.RegisterResponse{
-		Response: "ok",
-		Id:       is.is.GetID(),
-		Others:   others,
+		Response:    "ok",
+		Id:          is.is.GetID(),
+		Others:      is.is.GetMembers(),
+		Incarnation: incarnation,
 	}, nil
 }
 
+// Probe is the membership protocol's check that this node is alive
+func (is *InternalServer) Probe(ctx context.Context, req *pb.ProbeRequest) (*pb.ProbeResponse, error) {
+	return &pb.ProbeResponse{
+		Updates: is.is.Probe(req.GetUpdates()),
+	}, nil
+}
+
+// IndirectProbe probes another member for a peer that couldn't reach it
+func (is *InternalServer) IndirectProbe(ctx context.Context, req *pb.IndirectProbeRequest) (*pb.ProbeResponse, error) {
+	updates, err := is.is.IndirectProbe(ctx, req.GetTarget(), req.GetUpdates())
+	return &pb.ProbeResponse{
+		Updates: updates,
+	}, err
+}
+
 // GetState returns the merkle tree of this server
 func (is *InternalServer) GetState(ctx context.Context, req *pb.GetStateRequest) (*pb.GetStateResponse, error) {
 	return &pb.GetStateResponse{
@@ -52,21 +78,81 @@ func (is *InternalServer) GetState(ctx context.Context, req *pb.GetStateRequest)
 	}, nil
 }
 
-// GetPeers returns the merkle tree of this server
+// GetPeers returns every member of the cluster with its state and
+// incarnation
 func (is *InternalServer) GetPeers(ctx context.Context, req *pb.GetPeersRequest) (*pb.GetPeersResponse, error) {
 	return &pb.GetPeersResponse{
-		Peers: is.is.GetClientAddresses(),
+		Peers: is.is.GetMembers(),
+	}, nil
+}
+
+// GetKegSummaries returns every keg with only the root hash of its merkle
+// tree, so peers can tell which trees they need to look into
+func (is *InternalServer) GetKegSummaries(ctx context.Context, req *pb.GetKegSummariesRequest) (*pb.GetKegSummariesResponse, error) {
+	var summaries []*pb.KegSummary
+	for _, k := range is.ss.GetKegs() {
+		pbKeg := k.ToProto()
+		pbKeg.Tree = nil
+
+		summaries = append(summaries, &pb.KegSummary{
+			Keg:      pbKeg,
+			TreeHash: k.GetTree().Hash(),
+		})
+	}
+
+	return &pb.GetKegSummariesResponse{
+		Kegs: summaries,
+	}, nil
+}
+
+// GetNodeHashes returns the hashes of a subtree of a keg's merkle tree
+func (is *InternalServer) GetNodeHashes(ctx context.Context, req *pb.GetNodeHashesRequest) (*pb.GetNodeHashesResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.GetNodeHashesResponse{}, err
+	}
+
+	depth := int(req.GetDepth())
+	if depth > maxNodeHashDepth {
+		depth = maxNodeHashDepth
+	}
+
+	nodes, err := k.GetTree().NodeHashes(req.GetPath(), depth)
+	return &pb.GetNodeHashesResponse{
+		Nodes: nodes,
+	}, err
+}
+
+// GetLeaves returns the content of a keg's merkle tree below the given paths
+func (is *InternalServer) GetLeaves(ctx context.Context, req *pb.GetLeavesRequest) (*pb.GetLeavesResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.GetLeavesResponse{}, err
+	}
+
+	leaves, err := k.GetTree().Leaves(req.GetPaths())
+	if err != nil {
+		return &pb.GetLeavesResponse{}, err
+	}
+
+	var content []*pbMerkle.Content
+	for _, c := range leaves {
+		content = append(content, c.GetProto())
+	}
+
+	return &pb.GetLeavesResponse{
+		Content: content,
 	}, nil
 }
 
 // GetLiquid returns a liquid
 func (is *InternalServer) GetLiquid(ctx context.Context, req *pb.GetLiquidRequest) (*pb.GetLiquidResponse, error) {
-	_, err := is.ss.GetKegByID(req.GetKegId())
+	k, err := is.ss.GetKegByID(req.GetKegId())
 	if err != nil {
 		return &pb.GetLiquidResponse{}, err
 	}
 
-	liquid, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, req.GetKegId(), req.GetLiquidId(), config.C.LiquidExtension))
+	liquid, err := readLiquid(is.is, k, req.GetLiquidId(), !req.GetLocalOnly())
 	if err != nil {
 		return &pb.GetLiquidResponse{}, err
 	}
@@ -75,3 +161,137 @@ func (is *InternalServer) GetLiquid(ctx context.Context, req *pb.GetLiquidReques
 		Liquid: liquid.ToProto(),
 	}, err
 }
+
+// StoreLiquid durably stores a liquid written through a peer, before the
+// peer acknowledges the write
+func (is *InternalServer) StoreLiquid(ctx context.Context, req *pb.StoreLiquidRequest) (*pb.StoreLiquidResponse, error) {
+	err := is.is.StoreReplica(req.GetKegId(), liquid.FromProto(req.GetLiquid()))
+	return &pb.StoreLiquidResponse{}, err
+}
+
+// GetKegLiquids returns all liquids in a keg, for peers proxying reads of a
+// keg they don't own
+func (is *InternalServer) GetKegLiquids(ctx context.Context, req *pb.GetKegLiquidsRequest) (*pb.GetKegLiquidsResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.GetKegLiquidsResponse{}, err
+	}
+	return getKegLiquids(k), nil
+}
+
+// ListLiquids returns a page of the liquids in a keg, for peers proxying
+// reads of a keg they don't own
+func (is *InternalServer) ListLiquids(ctx context.Context, req *pb.ListLiquidsRequest) (*pb.ListLiquidsResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.ListLiquidsResponse{}, err
+	}
+	return listLiquids(k, req)
+}
+
+// GetLiquidProof proves a liquid is part of its keg's tree, for peers
+// proxying proofs of a keg they don't own
+func (is *InternalServer) GetLiquidProof(ctx context.Context, req *pb.GetLiquidProofRequest) (*pb.GetLiquidProofResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.GetLiquidProofResponse{}, err
+	}
+	return proveLiquid(is.is.GetID(), k, req.GetLiquidId())
+}
+
+// UpdateLiquidOptions updates the options of a liquid, for peers passing
+// on writes to a keg they don't own
+func (is *InternalServer) UpdateLiquidOptions(ctx context.Context, req *pb.UpdateLiquidOptionsRequest) (*pb.UpdateLiquidOptionsResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.UpdateLiquidOptionsResponse{}, err
+	}
+	return is.local().updateOwnedLiquidOptions(k, req)
+}
+
+// DeleteLiquid marks a liquid as deleted, for peers passing on writes to a
+// keg they don't own
+func (is *InternalServer) DeleteLiquid(ctx context.Context, req *pb.DeleteLiquidRequest) (*pb.DeleteLiquidResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.DeleteLiquidResponse{}, err
+	}
+	return is.local().deleteOwnedLiquid(k, req)
+}
+
+// CreateKegSnapshot freezes the contents of a keg, for peers that don't own
+// it
+func (is *InternalServer) CreateKegSnapshot(ctx context.Context, req *pb.CreateKegSnapshotRequest) (*pb.CreateKegSnapshotResponse, error) {
+	return is.local().createOwnedKegSnapshot(req)
+}
+
+// ListKegSnapshots returns the snapshots of a keg kept here, for peers that
+// don't own it
+func (is *InternalServer) ListKegSnapshots(ctx context.Context, req *pb.ListKegSnapshotsRequest) (*pb.ListKegSnapshotsResponse, error) {
+	return is.local().listOwnedKegSnapshots(req)
+}
+
+// RestoreKegSnapshot rolls a keg back to a snapshot kept here, for peers
+// that don't own it
+func (is *InternalServer) RestoreKegSnapshot(ctx context.Context, req *pb.RestoreKegSnapshotRequest) (*pb.RestoreKegSnapshotResponse, error) {
+	k, err := is.ss.GetKegByID(req.GetKegId())
+	if err != nil {
+		return &pb.RestoreKegSnapshotResponse{}, err
+	}
+	return is.local().restoreOwnedKegSnapshot(k, req)
+}
+
+// local returns an external server making writes passed on by peers here.
+// They aren't passed on again, even when our view of the owners differs
+// from the peer's.
+func (is *InternalServer) local() *ExternalServer {
+	return &ExternalServer{
+		ss: is.ss,
+		is: is.is,
+		rl: is.rl,
+	}
+}
+
+// GetReleases returns the releases of a keg after a given release number
+func (is *InternalServer) GetReleases(ctx context.Context, req *pb.GetReleasesRequest) (*pb.GetReleasesResponse, error) {
+	releases, err := is.ss.GetReleases(req.GetKegId(), req.GetAfter())
+	if err != nil {
+		return &pb.GetReleasesResponse{}, err
+	}
+
+	var pbReleases []*pbRelease.Release
+	for _, r := range releases {
+		pbReleases = append(pbReleases, r.ToProto())
+	}
+
+	return &pb.GetReleasesResponse{
+		Releases: pbReleases,
+	}, nil
+}
+
+// Subscribe streams every change made through this node's external service
+// to a peer as it happens, starting with any it missed
+func (is *InternalServer) Subscribe(req *pb.SubscribeRequest, stream pb.Internal_SubscribeServer) error {
+	backlog, events, cancel := is.rl.Subscribe(req.GetEpoch(), req.GetFrom())
+	defer cancel()
+
+	for _, event := range backlog {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+
+	pb "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/mirror"
+)
+
+// MirrorServer defines the grpc service the cluster this one mirrors pushes
+// its writes to
+type MirrorServer struct {
+	target mirror.ITarget
+}
+
+// NewMirrorServer returns an initialised mirror server object
+func NewMirrorServer(target mirror.ITarget) *MirrorServer {
+	return &MirrorServer{
+		target: target,
+	}
+}
+
+// Compare returns which of the pushing cluster's liquids of a keg we miss
+func (ms *MirrorServer) Compare(ctx context.Context, req *pb.MirrorCompareRequest) (*pb.MirrorCompareResponse, error) {
+	res, err := ms.target.Compare(req)
+	if err != nil {
+		return &pb.MirrorCompareResponse{}, err
+	}
+	return res, nil
+}
+
+// Apply writes a change pushed by the cluster we mirror
+func (ms *MirrorServer) Apply(ctx context.Context, req *pb.MirrorApplyRequest) (*pb.MirrorApplyResponse, error) {
+	return &pb.MirrorApplyResponse{}, ms.target.Apply(req)
+}
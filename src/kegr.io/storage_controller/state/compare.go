@@ -1,6 +1,7 @@
 package state
 
 import (
+	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/keg"
 )
 
@@ -9,12 +10,7 @@ func (ss *StateService) Diff(kegs map[string]keg.IKeg) map[string]*keg.KegDiff {
 	diff := make(map[string]*keg.KegDiff)
 
 	for id, k := range kegs {
-		localKeg, exist := ss.kegByID[id]
-		if !exist {
-			localKeg = keg.NewKegWithID(k.GetID(), k.GetOptions())
-			ss.addKeg(localKeg)
-			localKeg.ToDir()
-		}
+		localKeg := ss.getOrAddKeg(k)
 
 		d := localKeg.Diff(k)
 		diff[id] = d
@@ -22,3 +18,10 @@ func (ss *StateService) Diff(kegs map[string]keg.IKeg) map[string]*keg.KegDiff {
 
 	return diff
 }
+
+// DiffRemote returns the difference with a peer's keg without fetching its
+// merkle tree, only the parts of it that differ from ours
+func (ss *StateService) DiffRemote(k keg.IKeg, treeHash []byte, remote merkle.Remote) (*keg.KegDiff, error) {
+	localKeg := ss.getOrAddKeg(k)
+	return localKeg.DiffRemote(k, treeHash, remote)
+}
@@ -3,16 +3,26 @@ package state
 import (
 	"io/ioutil"
 	"log"
+	"sync"
 
+	pbConflict "kegr.io/protobuf/model/storage/conflict"
+	pbSnapshot "kegr.io/protobuf/model/storage/snapshot"
 	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/model/snapshot"
 	"kegr.io/storage_controller/model/state"
 )
 
-// StateService is responsible for comparing and keeping the state up to date
+// StateService is responsible for comparing and keeping the state up to date.
+// mu guards the keg maps, each keg guards itself and changes to a keg that
+// take several steps hold the keg's write lock throughout.
 type StateService struct {
 	IStateService
 
+	mu        sync.RWMutex
 	kegByPath map[string]keg.IKeg
 	kegByID   map[string]keg.IKeg
 }
@@ -27,11 +37,32 @@ type IStateService interface {
 	CreateKeg(options keg.IOptions) (keg.IKeg, error)
 	UpdateKeg(kegID string, options keg.IOptions) error
 	DeleteKeg(kegID string) error
+	ApplyKegHeader(kegID string, diff *keg.KegDiff) error
+
+	// Snapshot operations
+	CreateKegSnapshot(kegID, description string) (snapshot.ISnapshot, error)
+	ListKegSnapshots(kegID string) ([]*pbSnapshot.Info, error)
+	RestoreKegSnapshot(kegID, snapshotID string) ([]string, []string, error)
+
+	// Conflict operations
+	ListConflicts(kegID string) ([]*pbConflict.Conflict, error)
+	ResolveConflict(kegID, conflictID string, restore bool) (liquid.ILiquid, error)
+
+	// Release operations
+	CreateReleaseDraft(kegID, description string) (release.IDraft, error)
+	StageLiquid(kegID, draftID string, l liquid.ILiquid) (string, error)
+	StageLiquidDeletion(kegID, draftID, liquidID string) error
+	CommitRelease(kegID, draftID string) (release.IRelease, error)
+	ListReleases(kegID string) ([]release.IRelease, error)
+	GetReleases(kegID string, after int64) ([]release.IRelease, error)
+	RevertRelease(kegID string, number int64) (release.IRelease, error)
+	ApplyReplicated(kegID string, liquids []liquid.ILiquid, releases []release.IRelease) error
 
 	// Liquid operations
 	GetState() state.IState
 	GetHash() ([]byte, error)
 	Diff(kegs map[string]keg.IKeg) map[string]*keg.KegDiff
+	DiffRemote(k keg.IKeg, treeHash []byte, remote merkle.Remote) (*keg.KegDiff, error)
 }
 
 // NewStateService returns an initialised state service object
@@ -49,7 +80,7 @@ func NewStateService() IStateService {
 	for _, file := range files {
 		if file.IsDir() {
 			if keg, err := keg.FromDir(file.Name()); err == nil {
-				ss.addKeg(keg)
+				ss.loadKeg(keg)
 			}
 		}
 	}
@@ -57,10 +88,11 @@ func NewStateService() IStateService {
 	return ss
 }
 
-// GetState returns the current state of the server
+// GetState returns the current state of the server. It holds its own copy
+// of the set of kegs, so it isn't affected by kegs created afterwards.
 func (ss *StateService) GetState() state.IState {
 	state := state.NewState()
-	state.SetKegs(ss.kegByID)
+	state.SetKegs(ss.GetKegs())
 	return state
 }
 
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	pbConflict "kegr.io/protobuf/model/storage/conflict"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/conflict"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+)
+
+// ListConflicts returns the versions of a keg's liquids this node had to
+// discard for concurrent edits, oldest first
+func (ss *StateService) ListConflicts(kegID string) ([]*pbConflict.Conflict, error) {
+	if _, err := ss.GetKegByID(kegID); err != nil {
+		return nil, err
+	}
+	return conflict.List(kegID, config.C.ConflictRetention)
+}
+
+// ResolveConflict forgets a conflict. When restore is set the discarded
+// version is first written back as a new update over the one that was
+// kept, and returned so it can be replicated like any other write.
+func (ss *StateService) ResolveConflict(kegID, conflictID string, restore bool) (liquid.ILiquid, error) {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return nil, err
+	}
+	defer k.LockWrites()()
+
+	if _, err = conflict.FromFile(kegID, conflictID); err != nil {
+		return nil, err
+	}
+
+	var restored liquid.ILiquid
+	if restore {
+		l, err := liquid.FromFile(conflict.BodyFile(kegID, conflictID))
+		if err != nil {
+			return nil, err
+		}
+
+		l.Touch()
+		if current, err := k.GetLiquidInfoByID(l.GetID()); err == nil {
+			l.SetPrevious(current)
+		}
+		if err = l.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, kegID)); err != nil {
+			return nil, err
+		}
+		if err = k.UpdateLiquid(l.GetLiquidInfo()); err != nil {
+			return nil, err
+		}
+		restored = l
+	}
+
+	return restored, conflict.Remove(kegID, conflictID)
+}
+
+// recordConflicts keeps the versions of a keg's liquids that replicated
+// ones are about to overwrite without their writers having seen them. The
+// caller holds the keg's write lock.
+func recordConflicts(k keg.IKeg, liquids []liquid.ILiquid) {
+	recorded := false
+	for _, l := range liquids {
+		current, err := k.GetLiquidInfoByID(l.GetID())
+		if err != nil || !isConflict(current, l) {
+			continue
+		}
+
+		c := conflict.NewConflict(k.GetID(), l.GetLiquidInfo(), current)
+		if err = keepConflict(c); err != nil {
+			log.Printf("failed to record conflict on liquid %v of keg %v: %v\n", l.GetID(), k.GetID(), err)
+			continue
+		}
+		log.Printf("recorded conflict %v on liquid %v of keg %v, discarding the version by %v\n", c.GetID(), l.GetID(), k.GetID(), current.GetUpdatedBy())
+		recorded = true
+	}
+
+	// Listing drops the conflicts that have expired
+	if recorded {
+		if _, err := conflict.List(k.GetID(), config.C.ConflictRetention); err != nil {
+			log.Printf("failed to expire conflicts of keg %v: %v\n", k.GetID(), err)
+		}
+	}
+}
+
+// keepConflict saves a conflict along with the body of the version it
+// discards, which is still the liquid's file on disk
+func keepConflict(c conflict.IConflict) error {
+	if err := c.ToFile(); err != nil {
+		return err
+	}
+
+	source := fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, c.GetKegID(), c.GetLiquidID(), config.C.LiquidExtension)
+	if err := linkOrCopy(source, conflict.BodyFile(c.GetKegID(), c.GetID())); err != nil {
+		os.Remove(conflict.File(c.GetKegID(), c.GetID()))
+		return err
+	}
+	return nil
+}
+
+// isConflict reports whether a replicated version of a liquid overwrites
+// the current one without having been written over it, or over a later
+// version. Versions that don't name the one they were written over, such
+// as new liquids, are never conflicts.
+func isConflict(current liquid.IInfo, l liquid.ILiquid) bool {
+	if l.GetPreviousUpdated() == 0 {
+		return false
+	}
+	return clock.Newer(l.GetLastUpdated(), l.GetUpdatedBy(), current.GetLastUpdated(), current.GetUpdatedBy()) &&
+		clock.Newer(current.GetLastUpdated(), current.GetUpdatedBy(), l.GetPreviousUpdated(), l.GetPreviousBy())
+}
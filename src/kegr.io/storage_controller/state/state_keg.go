@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"kegr.io/storage_controller/clock"
 	"kegr.io/storage_controller/config"
 	"kegr.io/storage_controller/model/keg"
 )
@@ -21,32 +22,76 @@ func (ss *StateService) CreateKeg(options keg.IOptions) (keg.IKeg, error) {
 
 // UpdateKeg updates the options of a keg that is tracked
 func (ss *StateService) UpdateKeg(kegID string, options keg.IOptions) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
 	keg, exist := ss.kegByID[kegID]
 	if !exist {
 		return errors.New("Keg not found")
 	}
 
-	delete(ss.kegByPath, keg.GetOptions().GetPath())
+	if other, exist := ss.kegByPath[options.GetPath()]; exist && other != keg && !other.IsDeleted() {
+		return errors.New("keg already exist")
+	}
+
+	path := keg.GetOptions().GetPath()
 	keg.SetOptions(options)
-	ss.kegByPath[keg.GetOptions().GetPath()] = keg
+	ss.reindexPath(path)
+	ss.reindexPath(options.GetPath())
 
 	return nil
 }
 
-// DeleteKeg removes the underlying FS folder
+// DeleteKeg marks a keg and its liquids deleted. The keg is kept as a
+// tombstone so the deletion replicates, and its path is free to reuse.
 func (ss *StateService) DeleteKeg(kegID string) error {
-	keg, exist := ss.kegByID[kegID]
-	if !exist {
-		return errors.New("Keg not found")
+	keg, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return err
 	}
 
+	defer keg.LockWrites()()
 	keg.SetDeleted(true)
 
+	ss.mu.Lock()
+	ss.reindexPath(keg.GetOptions().GetPath())
+	ss.mu.Unlock()
+
+	return nil
+}
+
+// ApplyKegHeader applies a peer's options and tombstone of a keg from a
+// diff with it, if they're a later version than ours. Paths are indexed
+// again, as the keg may have moved, been deleted or been recreated.
+func (ss *StateService) ApplyKegHeader(kegID string, diff *keg.KegDiff) error {
+	if diff.Options == nil {
+		return nil
+	}
+
+	keg, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return err
+	}
+
+	defer keg.LockWrites()()
+	path := keg.GetOptions().GetPath()
+	if !keg.Replicate(diff.Options, diff.Deleted, diff.LastUpdated, diff.UpdatedBy) {
+		return nil
+	}
+
+	ss.mu.Lock()
+	ss.reindexPath(path)
+	ss.reindexPath(diff.Options.GetPath())
+	ss.mu.Unlock()
+
 	return nil
 }
 
 // GetKegByID returns the corresponding keg with that id or an error if not found
 func (ss *StateService) GetKegByID(kegID string) (keg.IKeg, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
 	keg, exist := ss.kegByID[kegID]
 	if !exist {
 		return nil, errors.New("Keg not found")
@@ -56,6 +101,9 @@ func (ss *StateService) GetKegByID(kegID string) (keg.IKeg, error) {
 
 // GetKegByPath returns the corresponding keg with that path or an error if not found
 func (ss *StateService) GetKegByPath(path string) (keg.IKeg, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
 	keg, exist := ss.kegByPath[path]
 	if !exist {
 		return nil, errors.New("Keg not found")
@@ -63,20 +111,86 @@ func (ss *StateService) GetKegByPath(path string) (keg.IKeg, error) {
 	return keg, nil
 }
 
-// GetKegs returns all kegs in a map where the key is the kegID
+// GetKegs returns all kegs in a map where the key is the kegID. The map is
+// a copy, so it can be ranged over while kegs are created.
 func (ss *StateService) GetKegs() map[string]keg.IKeg {
-	return ss.kegByID
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	kegs := make(map[string]keg.IKeg, len(ss.kegByID))
+	for id, k := range ss.kegByID {
+		kegs[id] = k
+	}
+	return kegs
 }
 
 func (ss *StateService) getKegPath(id string) string {
 	return fmt.Sprintf("%v/%v", config.C.DataRoot, id)
 }
 
+// addKeg adds a new keg, unless a keg that isn't deleted has its path
 func (ss *StateService) addKeg(keg keg.IKeg) error {
-	if _, exist := ss.kegByPath[keg.GetOptions().GetPath()]; exist {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if other, exist := ss.kegByPath[keg.GetOptions().GetPath()]; exist && !other.IsDeleted() {
 		return errors.New("keg already exist")
 	}
-	ss.kegByPath[keg.GetOptions().GetPath()] = keg
 	ss.kegByID[keg.GetID()] = keg
+	ss.reindexPath(keg.GetOptions().GetPath())
 	return nil
 }
+
+// loadKeg adds a keg read from the FS. Every keg is loaded, tombstones
+// sharing a path with a live keg included.
+func (ss *StateService) loadKeg(keg keg.IKeg) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.kegByID[keg.GetID()] = keg
+	ss.reindexPath(keg.GetOptions().GetPath())
+}
+
+// getOrAddKeg returns the keg with the id of k, adding a replica of it when
+// there's none yet. Checking and adding happen under one lock, so a keg
+// learned of from two peers at once is only added once.
+func (ss *StateService) getOrAddKeg(k keg.IKeg) keg.IKeg {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if localKeg, exist := ss.kegByID[k.GetID()]; exist {
+		return localKeg
+	}
+
+	localKeg := keg.NewReplica(k)
+	ss.kegByID[localKeg.GetID()] = localKeg
+	ss.reindexPath(localKeg.GetOptions().GetPath())
+	localKeg.ToDir()
+	return localKeg
+}
+
+// reindexPath points a path at the keg serving it. A keg that isn't deleted
+// wins over tombstones, otherwise the latest version wins, so every node
+// picks the same keg once their states match. The caller holds ss.mu.
+func (ss *StateService) reindexPath(path string) {
+	var winner keg.IKeg
+	for _, k := range ss.kegByID {
+		if k.GetOptions().GetPath() == path && (winner == nil || servesBefore(k, winner)) {
+			winner = k
+		}
+	}
+
+	if winner == nil {
+		delete(ss.kegByPath, path)
+		return
+	}
+	ss.kegByPath[path] = winner
+}
+
+// servesBefore reports whether k should serve a path rather than other
+func servesBefore(k, other keg.IKeg) bool {
+	if k.IsDeleted() != other.IsDeleted() {
+		return !k.IsDeleted()
+	}
+	return clock.Newer(k.GetLastUpdated(), k.GetUpdatedBy(), other.GetLastUpdated(), other.GetUpdatedBy())
+}
@@ -0,0 +1,327 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/model/snapshot"
+	"kegr.io/storage_controller/util"
+)
+
+// installDir is where a release being installed keeps the files it
+// replaces, under the keg's releases
+const installDir = "installing"
+
+// CreateReleaseDraft starts staging a new release for a keg
+func (ss *StateService) CreateReleaseDraft(kegID, description string) (release.IDraft, error) {
+	if _, err := ss.GetKegByID(kegID); err != nil {
+		return nil, err
+	}
+
+	d := release.NewDraft(kegID, description)
+	if err := d.ToFile(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// StageLiquid adds a liquid to a release draft. A liquid without an id is
+// created by the release and is assigned a new one, which is returned.
+func (ss *StateService) StageLiquid(kegID, draftID string, l liquid.ILiquid) (string, error) {
+	d, err := release.DraftFromFile(kegID, draftID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(l.GetID()) == 0 {
+		l.SetID(util.ID())
+	}
+	return l.GetID(), d.Stage(l)
+}
+
+// StageLiquidDeletion adds the deletion of a liquid to a release draft
+func (ss *StateService) StageLiquidDeletion(kegID, draftID, liquidID string) error {
+	d, err := release.DraftFromFile(kegID, draftID)
+	if err != nil {
+		return err
+	}
+	return d.StageDelete(liquidID)
+}
+
+// CommitRelease applies everything staged in a draft to the keg as the
+// next numbered release and discards the draft
+func (ss *StateService) CommitRelease(kegID, draftID string) (release.IRelease, error) {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return nil, err
+	}
+	defer k.LockWrites()()
+
+	d, err := release.DraftFromFile(kegID, draftID)
+	if err != nil {
+		return nil, err
+	}
+
+	staged, err := d.GetStaged()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := commitRelease(k, d.GetDescription(), staged, d.GetDeletes())
+	if err != nil {
+		return nil, err
+	}
+	return r, d.Remove()
+}
+
+// ListReleases returns every release of a keg in release order
+func (ss *StateService) ListReleases(kegID string) ([]release.IRelease, error) {
+	return ss.GetReleases(kegID, 0)
+}
+
+// GetReleases returns the releases of a keg numbered after the given one
+func (ss *StateService) GetReleases(kegID string, after int64) ([]release.IRelease, error) {
+	if _, err := ss.GetKegByID(kegID); err != nil {
+		return nil, err
+	}
+	return release.List(kegID, after)
+}
+
+// RevertRelease puts back the versions every liquid in a release had before
+// it, deleting the ones it created. The revert is itself committed as a new
+// release.
+func (ss *StateService) RevertRelease(kegID string, number int64) (release.IRelease, error) {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return nil, err
+	}
+	defer k.LockWrites()()
+
+	r, err := release.FromFile(kegID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	var staged []liquid.ILiquid
+	var deletes []string
+	for _, change := range r.GetChanges() {
+		if len(change.GetPreviousHash()) == 0 {
+			deletes = append(deletes, change.GetLiquidId())
+			continue
+		}
+
+		l, err := liquid.FromFile(snapshot.VersionFile(kegID, change.GetLiquidId(), change.GetPreviousHash()))
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, l)
+	}
+
+	return commitRelease(k, fmt.Sprintf("revert release %d", number), staged, deletes)
+}
+
+// ApplyReplicated writes liquids fetched from a peer to a keg as a single
+// unit, together with any of the peer's releases that produced them. The
+// versions they overwrite without having seen are kept as conflicts.
+func (ss *StateService) ApplyReplicated(kegID string, liquids []liquid.ILiquid, releases []release.IRelease) error {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return err
+	}
+	defer k.LockWrites()()
+
+	recordConflicts(k, liquids)
+
+	number := k.GetRelease()
+	for _, r := range releases {
+		if err = r.ToFile(); err != nil {
+			return err
+		}
+		if r.GetNumber() > number {
+			number = r.GetNumber()
+		}
+	}
+
+	var infos []liquid.IInfo
+	for _, l := range liquids {
+		clock.Observe(l.GetLastUpdated())
+
+		// Keep the replaced versions around so releases can be reverted
+		// on this node as well
+		if len(releases) > 0 {
+			if err = freezeCurrent(k, l.GetID()); err != nil {
+				return err
+			}
+		}
+		infos = append(infos, l.GetLiquidInfo())
+	}
+
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, kegID)
+	return k.ApplyRelease(number, infos, func() error {
+		for _, l := range liquids {
+			if err := l.ToFile(dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// commitRelease writes every new version aside first and only then switches
+// the keg over to all of them at once, so readers never see half a release
+func commitRelease(k keg.IKeg, description string, staged []liquid.ILiquid, deletes []string) (release.IRelease, error) {
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID())
+
+	for _, id := range deletes {
+		l, err := liquid.FromFile(fmt.Sprintf("%s/%s.%s", dir, id, config.C.LiquidExtension))
+		if err != nil {
+			return nil, err
+		}
+		if l.IsDeleted() {
+			continue
+		}
+		l.SetDeleted(true)
+		staged = append(staged, l)
+	}
+
+	var changes []*pbRelease.Change
+	var infos []liquid.IInfo
+	for _, l := range staged {
+		l.Touch()
+		if current, err := k.GetLiquidInfoByID(l.GetID()); err == nil {
+			l.SetPrevious(current)
+		}
+
+		change, err := prepareChange(k, l)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+		infos = append(infos, l.GetLiquidInfo())
+	}
+
+	r := release.NewRelease(k.GetID(), k.GetRelease()+1, time.Now().Unix(), description, changes)
+	if err := r.ToFile(); err != nil {
+		return nil, err
+	}
+
+	err := k.ApplyRelease(r.GetNumber(), infos, func() error {
+		return installVersions(k.GetID(), changes)
+	})
+	if err != nil {
+		r.Remove()
+		return nil, err
+	}
+	return r, nil
+}
+
+// installVersions puts the new version of every liquid in a release in
+// place of its current file. All of them are linked beside the liquids
+// before the first is swapped in, and the current files are kept aside
+// until the last one is, so a failed install puts every file back.
+func installVersions(kegID string, changes []*pbRelease.Change) error {
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, kegID)
+	backups := fmt.Sprintf("%s/%s/%s", dir, release.Dir, installDir)
+	if err := os.MkdirAll(backups, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(backups)
+
+	files := make([]string, len(changes))
+	for i, change := range changes {
+		files[i] = fmt.Sprintf("%s/%s.%s", dir, change.GetLiquidId(), config.C.LiquidExtension)
+	}
+	defer func() {
+		for _, file := range files {
+			os.Remove(file + ".tmp")
+		}
+	}()
+
+	for i, change := range changes {
+		os.Remove(files[i] + ".tmp")
+		if err := linkOrCopy(snapshot.VersionFile(kegID, change.GetLiquidId(), change.GetHash()), files[i]+".tmp"); err != nil {
+			return err
+		}
+		if info, err := os.Stat(files[i]); err == nil && info.Mode().IsRegular() {
+			if err = linkOrCopy(files[i], backups+"/"+change.GetLiquidId()); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, file := range files {
+		if err := os.Rename(file+".tmp", file); err != nil {
+			for j, swapped := range files[:i] {
+				backup := backups + "/" + changes[j].GetLiquidId()
+				if _, statErr := os.Stat(backup); statErr == nil {
+					os.Rename(backup, swapped)
+				} else {
+					os.Remove(swapped)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareChange freezes the current version of a liquid, if it has a live
+// one, and writes the new version beside it
+func prepareChange(k keg.IKeg, l liquid.ILiquid) (*pbRelease.Change, error) {
+	change := &pbRelease.Change{
+		LiquidId: l.GetID(),
+		Deleted:  l.IsDeleted(),
+	}
+
+	if err := freezeCurrent(k, l.GetID()); err != nil {
+		return nil, err
+	}
+	if previous, err := k.GetLiquidInfoByID(l.GetID()); err == nil && !previous.IsDeleted() {
+		if change.PreviousHash, err = versionHash(previous); err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := versionHash(l.GetLiquidInfo())
+	if err != nil {
+		return nil, err
+	}
+	change.Hash = hash
+
+	content, err := l.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	file := snapshot.VersionFile(k.GetID(), l.GetID(), hash)
+	if _, err = os.Stat(file); err == nil {
+		return change, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	return change, ioutil.WriteFile(file, content, 0644)
+}
+
+// freezeCurrent freezes the live version of a liquid, if there is one
+func freezeCurrent(k keg.IKeg, liquidID string) error {
+	info, err := k.GetLiquidInfoByID(liquidID)
+	if err != nil || info.IsDeleted() {
+		return nil
+	}
+
+	hash, err := versionHash(info)
+	if err != nil {
+		return err
+	}
+	return freezeVersion(k.GetID(), liquidID, hash)
+}
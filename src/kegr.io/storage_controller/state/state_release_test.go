@@ -0,0 +1,221 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	pbRelease "kegr.io/protobuf/model/storage/release"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/util"
+)
+
+const (
+	releaseRounds = 10
+	releaseAssets = 50
+)
+
+// commit stages liquids and deletions in a new draft and commits it
+func commit(t *testing.T, ss IStateService, kegID string, staged []liquid.ILiquid, deletes ...string) (release.IRelease, error) {
+	d, err := ss.CreateReleaseDraft(kegID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range staged {
+		if _, err = ss.StageLiquid(kegID, d.GetID(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range deletes {
+		if err = ss.StageLiquidDeletion(kegID, d.GetID(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ss.CommitRelease(kegID, d.GetID())
+}
+
+func withContent(id, content string) liquid.ILiquid {
+	l := newTestLiquid(id, id)
+	l.SetContent([]byte(content))
+	l.SetSize(int64(len(content)))
+	l.SetFileHash(util.GetContentHash([]byte(content)))
+	return l
+}
+
+func TestCommitAndRevertRelease(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, k, withContent("page", "old page"))
+	write(t, k, withContent("legacy", "legacy"))
+
+	r, err := commit(t, ss, k.GetID(), []liquid.ILiquid{withContent("page", "new page"), withContent("script", "script")}, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetNumber() != 1 || k.GetRelease() != 1 || len(r.GetChanges()) != 3 {
+		t.Errorf("expected release 1 with 3 changes, got %v with %v", r.GetNumber(), r.GetChanges())
+	}
+	if string(readLiquid(t, k.GetID(), "page").GetContent()) != "new page" || !readLiquid(t, k.GetID(), "legacy").IsDeleted() {
+		t.Error("expected the release to update page and delete legacy")
+	}
+	if info, err := k.GetLiquidInfoByID("script"); err != nil || info.IsDeleted() {
+		t.Error("expected the release to create script")
+	}
+
+	reverted, err := ss.RevertRelease(k.GetID(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.GetNumber() != 2 || k.GetRelease() != 2 {
+		t.Errorf("expected the revert to be release 2, got %v", reverted.GetNumber())
+	}
+	if string(readLiquid(t, k.GetID(), "page").GetContent()) != "old page" {
+		t.Error("expected the revert to put the old page back")
+	}
+	if legacy := readLiquid(t, k.GetID(), "legacy"); legacy.IsDeleted() || string(legacy.GetContent()) != "legacy" {
+		t.Error("expected the revert to bring legacy back")
+	}
+	if info, _ := k.GetLiquidInfoByID("script"); !info.IsDeleted() {
+		t.Error("expected the revert to delete the created script")
+	}
+
+	releases, err := ss.ListReleases(k.GetID())
+	if err != nil || len(releases) != 2 {
+		t.Errorf("expected 2 releases, got %v %v", releases, err)
+	}
+}
+
+func TestFailedReleaseInstall(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, k, withContent("page", "old page"))
+
+	// Nothing can be swapped in where script goes, which is after page
+	blocked := fmt.Sprintf("%s/%s/script.liquid/file", config.C.DataRoot, k.GetID())
+	if err = os.MkdirAll(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = commit(t, ss, k.GetID(), []liquid.ILiquid{withContent("page", "new page"), withContent("script", "script")}); err == nil {
+		t.Fatal("expected the release to fail")
+	}
+	if string(readLiquid(t, k.GetID(), "page").GetContent()) != "old page" {
+		t.Error("expected the page swapped in first to be put back")
+	}
+	if info, _ := k.GetLiquidInfoByID("page"); string(info.GetFileHash()) != string(withContent("page", "old page").GetFileHash()) {
+		t.Error("expected the keg to keep the old page")
+	}
+	if releases, _ := ss.ListReleases(k.GetID()); len(releases) != 0 || k.GetRelease() != 0 {
+		t.Errorf("expected no release to be left behind, got %v at %v", releases, k.GetRelease())
+	}
+}
+
+// TestReleaseAtomic checks a reader that gets a release's page always
+// finds the script that came with it
+func TestReleaseAtomic(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := func(l liquid.ILiquid) int {
+		i, _ := strconv.Atoi(strings.TrimPrefix(string(l.GetContent()), l.GetID()+" "))
+		return i
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			page, err := k.ReadLiquid("page")
+			if err != nil {
+				continue
+			}
+			script, err := k.ReadLiquid("script")
+			if err != nil || round(script) < round(page) {
+				t.Errorf("page of round %v read without its script", round(page))
+				return
+			}
+		}
+	}()
+
+	for i := 1; i <= releaseRounds; i++ {
+		staged := []liquid.ILiquid{
+			withContent("page", fmt.Sprintf("page %d", i)),
+			withContent("script", fmt.Sprintf("script %d", i)),
+		}
+		// Files installed between the two widen the window a reader could
+		// slip into
+		for j := 0; j < releaseAssets; j++ {
+			id := fmt.Sprintf("q%d", j)
+			staged = append(staged, withContent(id, fmt.Sprintf("%s %d", id, i)))
+		}
+		if _, err = commit(t, ss, k.GetID(), staged); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestApplyReplicatedRelease(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer's release arrives with every liquid it changed
+	liquids := []liquid.ILiquid{withContent("page", "page"), withContent("script", "script")}
+	var changes []*pbRelease.Change
+	for _, l := range liquids {
+		hash, err := versionHash(l.GetLiquidInfo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, &pbRelease.Change{LiquidId: l.GetID(), Hash: hash})
+	}
+	r := release.NewRelease(k.GetID(), 1, 1, "peer", changes)
+
+	if err = ss.ApplyReplicated(k.GetID(), liquids, []release.IRelease{r}); err != nil {
+		t.Fatal(err)
+	}
+	if k.GetRelease() != 1 {
+		t.Errorf("expected the keg at release 1, got %v", k.GetRelease())
+	}
+	for _, l := range liquids {
+		if string(readLiquid(t, k.GetID(), l.GetID()).GetContent()) != string(l.GetContent()) {
+			t.Errorf("expected %v to be applied with the release", l.GetID())
+		}
+	}
+	if releases, _ := ss.ListReleases(k.GetID()); len(releases) != 1 {
+		t.Errorf("expected the peer's release to be kept, got %v", releases)
+	}
+}
@@ -0,0 +1,196 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	pbSnapshot "kegr.io/protobuf/model/storage/snapshot"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/snapshot"
+)
+
+// CreateKegSnapshot freezes the current merkle tree, options and liquid
+// versions of a keg. Liquid files are hard linked rather than copied, and as
+// liquids are always replaced rather than rewritten, the linked versions stay
+// untouched by later updates.
+func (ss *StateService) CreateKegSnapshot(kegID, description string) (snapshot.ISnapshot, error) {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return nil, err
+	}
+	defer k.LockWrites()()
+
+	versions := make(map[string][]byte)
+	for id, info := range k.GetLiquids() {
+		if info.IsDeleted() {
+			continue
+		}
+
+		hash, err := versionHash(info)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = freezeVersion(kegID, id, hash); err != nil {
+			return nil, err
+		}
+		versions[id] = hash
+	}
+
+	s := snapshot.NewSnapshot(k, description, versions)
+	if err = s.ToFile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListKegSnapshots returns the snapshots of a keg, oldest first
+func (ss *StateService) ListKegSnapshots(kegID string) ([]*pbSnapshot.Info, error) {
+	if _, err := ss.GetKegByID(kegID); err != nil {
+		return nil, err
+	}
+	return snapshot.List(kegID)
+}
+
+// RestoreKegSnapshot brings a keg back to the state frozen in a snapshot.
+// Every change is made as an ordinary, newer update or deletion so it
+// replicates to the rest of the cluster like any other write. It returns
+// the ids of the liquids it updated and deleted.
+func (ss *StateService) RestoreKegSnapshot(kegID, snapshotID string) ([]string, []string, error) {
+	k, err := ss.GetKegByID(kegID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer k.LockWrites()()
+
+	s, err := snapshot.FromFile(kegID, snapshotID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var updated, deleted []string
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, kegID)
+	versions := s.GetVersions()
+
+	for id, hash := range versions {
+		info, err := k.GetLiquidInfoByID(id)
+		if err == nil && !info.IsDeleted() {
+			if current, err := versionHash(info); err == nil && bytes.Equal(current, hash) {
+				continue
+			}
+		}
+
+		l, err := liquid.FromFile(snapshot.VersionFile(kegID, id, hash))
+		if err != nil {
+			return updated, deleted, err
+		}
+
+		l.Touch()
+		if info != nil {
+			l.SetPrevious(info)
+		}
+		if err = l.ToFile(dir); err != nil {
+			return updated, deleted, err
+		}
+		if err = k.UpdateLiquid(l.GetLiquidInfo()); err != nil {
+			return updated, deleted, err
+		}
+		updated = append(updated, id)
+	}
+
+	var stale []string
+	for id, info := range k.GetLiquids() {
+		if _, exist := versions[id]; !exist && !info.IsDeleted() {
+			stale = append(stale, id)
+		}
+	}
+
+	for _, id := range stale {
+		l, err := liquid.FromFile(fmt.Sprintf("%s/%s.%s", dir, id, config.C.LiquidExtension))
+		if err != nil {
+			return updated, deleted, err
+		}
+
+		l.SetDeleted(true)
+		l.Touch()
+		if err = l.ToFile(dir); err != nil {
+			return updated, deleted, err
+		}
+		if err = k.UpdateLiquid(l.GetLiquidInfo()); err != nil {
+			return updated, deleted, err
+		}
+		deleted = append(deleted, id)
+	}
+
+	if !sameOptions(s.GetOptions(), k.GetOptions()) {
+		if err = ss.UpdateKeg(kegID, s.GetOptions()); err != nil {
+			return updated, deleted, err
+		}
+	}
+
+	return updated, deleted, nil
+}
+
+// versionHash identifies a version of a liquid by its merkle tree hash
+func versionHash(info liquid.IInfo) ([]byte, error) {
+	content, err := liquid.NewMerkleTreeLiquid(info)
+	if err != nil {
+		return nil, err
+	}
+	return content.GetHash(), nil
+}
+
+// freezeVersion links the current file of a liquid into the keg's frozen
+// versions
+func freezeVersion(kegID, liquidID string, hash []byte) error {
+	target := snapshot.VersionFile(kegID, liquidID, hash)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	source := fmt.Sprintf("%s/%s/%s.%s", config.C.DataRoot, kegID, liquidID, config.C.LiquidExtension)
+	return linkOrCopy(source, target)
+}
+
+// linkOrCopy hard links source to target, falling back to a copy on
+// filesystems without hard links
+func linkOrCopy(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func sameOptions(one, two keg.IOptions) bool {
+	return one.GetName() == two.GetName() &&
+		one.GetPath() == two.GetPath() &&
+		one.GetCache() == two.GetCache() &&
+		one.GetGzip() == two.GetGzip() &&
+		one.GetReplicas() == two.GetReplicas() &&
+		one.GetWriteConsistency() == two.GetWriteConsistency() &&
+		one.GetReadConsistency() == two.GetReadConsistency()
+}
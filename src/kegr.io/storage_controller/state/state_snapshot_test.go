@@ -0,0 +1,113 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/snapshot"
+)
+
+// write stores a liquid the way the external service does
+func write(t *testing.T, k keg.IKeg, l liquid.ILiquid) {
+	defer k.LockWrites()()
+	if err := l.ToFile(fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID())); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.UpdateLiquid(l.GetLiquidInfo()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLiquid(t *testing.T, kegID, liquidID string) liquid.ILiquid {
+	l, err := liquid.FromFile(fmt.Sprintf("%s/%s/%s.liquid", config.C.DataRoot, kegID, liquidID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kegID := k.GetID()
+
+	page, style := newTestLiquid("page", "page"), newTestLiquid("style", "style")
+	write(t, k, page)
+	write(t, k, style)
+
+	s, err := ss.CreateKegSnapshot(kegID, "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change everything the snapshot froze
+	edited := edit(page, "edited")
+	write(t, k, edited)
+	removed := liquid.FromProto(style.ToProto())
+	removed.SetDeleted(true)
+	removed.Touch()
+	write(t, k, removed)
+	write(t, k, newTestLiquid("added", "added"))
+	options := newTestOptions("site")
+	options.SetCache(60)
+	if err = ss.UpdateKeg(kegID, options); err != nil {
+		t.Fatal(err)
+	}
+
+	// The frozen version is a link to a file the edit replaced, not rewrote
+	frozen, err := liquid.FromFile(snapshot.VersionFile(kegID, "page", s.GetVersions()["page"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frozen.GetContent()) != string(page.GetContent()) {
+		t.Errorf("expected the frozen version to keep %q, got %q", page.GetContent(), frozen.GetContent())
+	}
+
+	updated, deleted, err := ss.RestoreKegSnapshot(kegID, s.GetID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(updated)
+	if fmt.Sprint(updated) != "[page style]" || fmt.Sprint(deleted) != "[added]" {
+		t.Errorf("expected page and style updated and added deleted, got %v %v", updated, deleted)
+	}
+
+	// The files and the keg's index are back to the snapshot, as newer
+	// versions than the changes they undo
+	if restored := readLiquid(t, kegID, "page"); string(restored.GetContent()) != string(page.GetContent()) {
+		t.Errorf("expected page to be restored, got %q", restored.GetContent())
+	}
+	if restored := readLiquid(t, kegID, "style"); restored.IsDeleted() || string(restored.GetContent()) != string(style.GetContent()) {
+		t.Error("expected style to be restored")
+	}
+	if !readLiquid(t, kegID, "added").IsDeleted() {
+		t.Error("expected added to be deleted on disk")
+	}
+
+	info, _ := k.GetLiquidInfoByID("page")
+	if info.GetLastUpdated() <= edited.GetLastUpdated() {
+		t.Error("expected the restore to be newer than the edit")
+	}
+	if id, err := k.GetLiquidIDByAccessName(page.GetAccessName()); err != nil || id != "page" {
+		t.Errorf("expected page to be found by its access name, got %v %v", id, err)
+	}
+	if info, _ = k.GetLiquidInfoByID("added"); !info.IsDeleted() {
+		t.Error("expected added to be deleted in the keg")
+	}
+	if liquids, _, _ := k.ListLiquids("", "", "", 10); len(liquids) != 2 {
+		t.Errorf("expected page and style to be listed, got %v", liquids)
+	}
+
+	if k.GetOptions().GetCache() != newTestOptions("site").GetCache() {
+		t.Errorf("expected the keg's options to be restored, got cache %v", k.GetOptions().GetCache())
+	}
+}
@@ -0,0 +1,365 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	pbConflict "kegr.io/protobuf/model/storage/conflict"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+const (
+	stressWriters = 8
+	stressWrites  = 50
+)
+
+func setupState(t *testing.T) (IStateService, func()) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.C = &config.Config{
+		DataRoot:        dir,
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+	}
+	return NewStateService(), func() { os.RemoveAll(dir) }
+}
+
+func newTestLiquid(id, name string) liquid.ILiquid {
+	content := []byte(fmt.Sprintf("%s %s", id, name))
+
+	options := liquid.NewOptions()
+	options.SetName(name)
+	options.SetExt("txt")
+
+	l := liquid.NewLiquid()
+	l.SetID(id)
+	l.SetContent(content)
+	l.SetSize(int64(len(content)))
+	l.SetFileHash(util.GetContentHash(content))
+	l.SetOptions(options)
+	l.Touch()
+	return l
+}
+
+func newTestOptions(name string) keg.IOptions {
+	options := keg.NewOptions()
+	options.SetName(name)
+	options.SetPath(name)
+	return options
+}
+
+// treeRemote serves a local tree the way a peer's internal service would
+type treeRemote struct {
+	tree merkle.ITree
+}
+
+func (r *treeRemote) GetNodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error) {
+	return r.tree.NodeHashes(path, depth)
+}
+
+func (r *treeRemote) GetLeaves(paths []string) ([]merkle.IContent, error) {
+	return r.tree.Leaves(paths)
+}
+
+// TestConcurrentWritesDuringSync writes to a keg through every path the
+// services use, external writes and replicated ones, while it's compared with
+// a peer's copy and hashed as the anti-entropy monitor does
+func TestConcurrentWritesDuringSync(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+
+	k, err := ss.CreateKeg(newTestOptions("stress"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := fmt.Sprintf("%s/%s", config.C.DataRoot, k.GetID())
+
+	peer := keg.NewKegWithID(k.GetID(), newTestOptions("stress"))
+	for i := 0; i < stressWrites; i++ {
+		peer.AddLiquid(newTestLiquid(util.ID(), fmt.Sprintf("peer-%d", i)).GetLiquidInfo())
+	}
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	for w := 0; w < stressWriters; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < stressWrites; i++ {
+				l := newTestLiquid(util.ID(), fmt.Sprintf("writer-%d-%d", w, i))
+
+				// Even writers go through the external path, odd ones apply
+				// what they'd have fetched from a peer
+				if w%2 == 0 {
+					unlock := k.LockWrites()
+					if err := l.ToFile(dir); err != nil {
+						t.Error(err)
+					}
+					if err := k.AddLiquid(l.GetLiquidInfo()); err != nil {
+						t.Error(err)
+					}
+					unlock()
+				} else if err := ss.ApplyReplicated(k.GetID(), []liquid.ILiquid{l}, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < stressWrites; i++ {
+			ss.UpdateKeg(k.GetID(), newTestOptions("stress"))
+			if _, err := ss.CreateKeg(newTestOptions(fmt.Sprintf("created-%d", i))); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	reader := func(read func()) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					read()
+				}
+			}
+		}()
+	}
+
+	reader(func() {
+		if _, err := ss.GetHash(); err != nil {
+			t.Error(err)
+		}
+		ss.GetState().ToProto()
+	})
+	reader(func() {
+		ss.Diff(map[string]keg.IKeg{peer.GetID(): peer})
+	})
+	reader(func() {
+		if _, err := ss.DiffRemote(peer, peer.GetTree().Hash(), &treeRemote{peer.GetTree()}); err != nil {
+			t.Error(err)
+		}
+	})
+	reader(func() {
+		for _, other := range ss.GetKegs() {
+			other.GetInfo()
+			other.ListLiquids("", "/", "", 100)
+			for id := range other.GetLiquids() {
+				other.GetLiquidInfoByID(id)
+			}
+		}
+	})
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	liquids := k.GetLiquids()
+	if len(liquids) != stressWriters*stressWrites {
+		t.Errorf("expected %d liquids, got %d", stressWriters*stressWrites, len(liquids))
+	}
+
+	// The tree must match the liquids it was built from
+	tree := merkle.NewAdaptiveTree()
+	for _, info := range liquids {
+		content, _ := liquid.NewMerkleTreeLiquid(info)
+		tree.Add(content)
+	}
+	if !bytes.Equal(tree.Hash(), k.GetTree().Hash()) {
+		t.Error("expected the merkle tree to match the keg's liquids")
+	}
+
+	if len(ss.GetKegs()) != stressWrites+1 {
+		t.Errorf("expected %d kegs, got %d", stressWrites+1, len(ss.GetKegs()))
+	}
+}
+
+// replicateKeg applies a peer's copy of a keg the way the sync service does
+// with the kegs it's told about
+func replicateKeg(t *testing.T, to IStateService, from keg.IKeg) {
+	other := keg.FromProto(from.ToProto())
+	diff := to.Diff(map[string]keg.IKeg{other.GetID(): other})
+	if err := to.ApplyKegHeader(other.GetID(), diff[other.GetID()]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKegChangesConverge deletes, recreates and moves a keg on one node and
+// checks another node ends up with the same kegs, served at the same paths
+func TestKegChangesConverge(t *testing.T) {
+	_, cleanup := setupState(t)
+	defer cleanup()
+	dirA := config.C.DataRoot
+	dirB, _ := ioutil.TempDir("", "state")
+	defer os.RemoveAll(dirB)
+
+	// Both nodes share the config, so point it at whichever one is acting
+	on := func(dir string, act func()) {
+		config.C.DataRoot = dir
+		act()
+	}
+
+	var a, b IStateService
+	on(dirA, func() { a = NewStateService() })
+	on(dirB, func() { b = NewStateService() })
+
+	servedAt := func(ss IStateService, path string) keg.IKeg {
+		k, err := ss.GetKegByPath(path)
+		if err != nil {
+			t.Fatalf("expected a keg at %v: %v", path, err)
+		}
+		return k
+	}
+
+	var first keg.IKeg
+	on(dirA, func() { first, _ = a.CreateKeg(newTestOptions("site")) })
+	stale := keg.FromProto(first.ToProto())
+	on(dirB, func() { replicateKeg(t, b, first) })
+	if servedAt(b, "site").GetID() != first.GetID() {
+		t.Fatal("expected the keg to be created on the other node")
+	}
+
+	on(dirA, func() { a.DeleteKeg(first.GetID()) })
+	on(dirB, func() { replicateKeg(t, b, first) })
+	replica, _ := b.GetKegByID(first.GetID())
+	if !replica.IsDeleted() {
+		t.Fatal("expected the deletion to replicate")
+	}
+	if replica.GetLastUpdated() != first.GetLastUpdated() || replica.GetUpdatedBy() != first.GetUpdatedBy() {
+		t.Error("expected the tombstone to keep the version it was made with")
+	}
+
+	// A copy from before the deletion mustn't bring the keg back
+	on(dirB, func() { replicateKeg(t, b, stale) })
+	if !replica.IsDeleted() {
+		t.Fatal("expected an older copy not to resurrect the keg")
+	}
+
+	var second keg.IKeg
+	on(dirA, func() {
+		var err error
+		if second, err = a.CreateKeg(newTestOptions("site")); err != nil {
+			t.Fatalf("expected the path of a deleted keg to be reusable: %v", err)
+		}
+	})
+	on(dirB, func() {
+		replicateKeg(t, b, second)
+		replicateKeg(t, b, first)
+	})
+	if servedAt(b, "site").GetID() != second.GetID() {
+		t.Fatal("expected the recreated keg to serve the path")
+	}
+
+	moved := newTestOptions("site")
+	moved.SetPath("moved")
+	on(dirA, func() { a.UpdateKeg(second.GetID(), moved) })
+	on(dirB, func() { replicateKeg(t, b, second) })
+
+	for _, ss := range []IStateService{a, b} {
+		if servedAt(ss, "moved").GetID() != second.GetID() {
+			t.Error("expected the moved keg to serve its new path")
+		}
+		if servedAt(ss, "site").GetID() != first.GetID() {
+			t.Error("expected the tombstone to be left at the old path")
+		}
+	}
+
+	// Restarting keeps the tombstone and the path it gave up
+	on(dirA, func() { a = NewStateService() })
+	if k, _ := a.GetKegByID(first.GetID()); k == nil || !k.IsDeleted() {
+		t.Error("expected the tombstone to be loaded")
+	}
+	if servedAt(a, "moved").GetID() != second.GetID() {
+		t.Error("expected the moved keg to be loaded at its new path")
+	}
+}
+
+// edit returns a later version of a liquid, written over it
+func edit(l liquid.ILiquid, content string) liquid.ILiquid {
+	edited := liquid.FromProto(l.ToProto())
+	edited.SetContent([]byte(content))
+	edited.SetFileHash(util.GetContentHash([]byte(content)))
+	edited.Touch()
+	return edited
+}
+
+func TestConflicts(t *testing.T) {
+	ss, cleanup := setupState(t)
+	defer cleanup()
+	config.C.ConflictRetention = time.Hour
+
+	k, err := ss.CreateKeg(newTestOptions("site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(l liquid.ILiquid) {
+		if err := ss.ApplyReplicated(k.GetID(), []liquid.ILiquid{l}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conflicts := func() []*pbConflict.Conflict {
+		conflicts, err := ss.ListConflicts(k.GetID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conflicts
+	}
+
+	// An edit written over the current version follows it
+	original := newTestLiquid("page", "page")
+	later := edit(original, "later")
+	apply(original)
+	apply(later)
+	if len(conflicts()) != 0 {
+		t.Fatal("expected a later edit not to conflict")
+	}
+
+	// Two edits written over the same version are concurrent, the
+	// newer one wins and the other is kept
+	ours := edit(later, "ours")
+	apply(ours)
+	apply(edit(later, "theirs"))
+
+	found := conflicts()
+	if len(found) != 1 {
+		t.Fatalf("expected the overwritten edit to be recorded, got %v", found)
+	}
+	if found[0].GetDiscarded().GetLastUpdated() != ours.GetLastUpdated() {
+		t.Errorf("expected our edit to be the discarded one, got %v", found[0])
+	}
+
+	restored, err := ss.ResolveConflict(k.GetID(), found[0].GetId(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := liquid.FromFile(fmt.Sprintf("%s/%s/page.liquid", config.C.DataRoot, k.GetID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current.GetContent()) != "ours" || current.GetLastUpdated() != restored.GetLastUpdated() {
+		t.Errorf("expected the discarded edit to be restored, got %q", current.GetContent())
+	}
+	if len(conflicts()) != 0 {
+		t.Error("expected the resolved conflict to be forgotten")
+	}
+}
@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"errors"
+
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/model/keg"
+)
+
+// Status returns the health of every peer we hold a client for, along with
+// what the membership protocol thinks of it
+func (ss *SyncService) Status() []*pbServer.PeerStatus {
+	ss.mu.RLock()
+	clients := make([]IInternalClient, 0, len(ss.clients))
+	for _, client := range ss.clients {
+		clients = append(clients, client)
+	}
+	ss.mu.RUnlock()
+
+	statuses := make([]*pbServer.PeerStatus, len(clients))
+	for i, client := range clients {
+		statuses[i] = client.GetStatus()
+		if m, exist := ss.members.Get(client.GetID()); exist {
+			statuses[i].Member = m.State
+			statuses[i].Zone = m.Zone
+		}
+	}
+	return statuses
+}
+
+// ForceSync compares every keg with a peer's right away, rather than
+// waiting for the next anti-entropy round
+func (ss *SyncService) ForceSync(peerID string) error {
+	client, exist := ss.getClient(peerID)
+	if !exist {
+		return errors.New("Peer not found")
+	}
+	return ss.forceRecheck(client)
+}
+
+// EvictPeer declares a peer dead, handing its share of the kegs over to
+// the others. The rest of the cluster learns of it through gossip.
+func (ss *SyncService) EvictPeer(peerID string) error {
+	if peerID == ss.id {
+		return errors.New("Can't evict this node")
+	}
+	return ss.members.Evict(peerID)
+}
+
+// DiffWithPeer returns what syncing with a peer would take from it, without
+// applying any of it. Kegs we don't know of yet are compared with an empty
+// copy rather than being added.
+func (ss *SyncService) DiffWithPeer(peerID string) ([]*pbServer.KegTransfer, error) {
+	client, exist := ss.getClient(peerID)
+	if !exist {
+		return nil, errors.New("Peer not found")
+	}
+
+	summaries, err := client.GetKegSummaries()
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []*pbServer.KegTransfer
+	for _, summary := range summaries {
+		other := keg.FromProto(summary.GetKeg())
+
+		local, err := ss.ss.GetKegByID(other.GetID())
+		created := err != nil
+		if created {
+			local = keg.NewReplica(other)
+		}
+
+		var remote merkle.Remote
+		if ss.IsOwner(other) {
+			remote = newRemoteTree(client, other.GetID())
+		}
+
+		kegDiff, err := local.DiffRemote(other, summary.GetTreeHash(), remote)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer := &pbServer.KegTransfer{
+			KegId:   other.GetID(),
+			Path:    other.GetOptions().GetPath(),
+			Created: created,
+			Release: kegDiff.Release,
+		}
+		if created || kegDiff.Options != nil {
+			transfer.Options = other.GetOptions().ToProto()
+			transfer.Deleted = other.IsDeleted()
+		}
+		for _, content := range kegDiff.Content {
+			transfer.Liquids = append(transfer.Liquids, &pbMerkle.Content{
+				ID:          content.GetID(),
+				Hash:        content.GetHash(),
+				LastUpdated: content.GetLastUpdated(),
+				UpdatedBy:   content.GetUpdatedBy(),
+				Size:        sizeOf(content),
+			})
+		}
+
+		if created || transfer.Options != nil || transfer.Release > 0 || len(transfer.Liquids) > 0 {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, nil
+}
@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/discovery"
+)
+
+const seedLookupTimeout = 5 * time.Second
+
+// bootstrap joins the cluster through the first seed or previously known
+// member that answers. Rounds are retried with backoff until one does, or
+// until another node has joined the cluster through us.
+func (ss *SyncService) bootstrap() {
+	backoff := minJoinBackoff
+
+	for {
+		candidates := ss.joinCandidates()
+		if len(candidates) == 0 {
+			log.Printf("no seeds configured, starting a new cluster\n")
+			return
+		}
+
+		for _, address := range candidates {
+			err := ss.Register(address)
+			if err == nil {
+				log.Printf("joined the cluster through %v\n", address)
+				return
+			}
+			log.Printf("failed to join through %v: %v\n", address, err)
+		}
+
+		log.Printf("no seed answered, retrying in %v\n", backoff)
+		select {
+		case <-ss.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		if len(ss.members.Members()) > 1 {
+			log.Printf("joined the cluster through a node registering with us\n")
+			return
+		}
+
+		if backoff *= 2; backoff > maxJoinBackoff {
+			backoff = maxJoinBackoff
+		}
+	}
+}
+
+// joinCandidates lists the addresses to join through: the configured seeds
+// first, then the ones published in DNS and lastly the members we knew of
+// before a restart. They're looked up again every round so changes to the
+// seed file or DNS are picked up while we retry.
+func (ss *SyncService) joinCandidates() []string {
+	seeds, err := discovery.ReadList(config.C.SeedsFile)
+	if err != nil {
+		log.Printf("failed to read seeds from %v: %v\n", config.C.SeedsFile, err)
+	}
+
+	var published []string
+	if len(config.C.SeedsDNS) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), seedLookupTimeout)
+		published, err = discovery.Resolve(ctx, net.DefaultResolver, config.C.SeedsDNS, config.C.InternalGrpcPort)
+		cancel()
+		if err != nil {
+			log.Printf("failed to resolve seeds from %v: %v\n", config.C.SeedsDNS, err)
+		}
+	}
+
+	known, err := discovery.ReadList(config.C.PeersFile)
+	if err != nil {
+		log.Printf("failed to read known members from %v: %v\n", config.C.PeersFile, err)
+	}
+
+	return discovery.Merge(ss.address, config.C.Seeds, seeds, published, known)
+}
+
+// savePeers persists the addresses of every other member we know of, so a
+// restarted node can rejoin even if every seed has changed since. The last
+// list is kept when we know of no one, as it's still the best guess.
+func (ss *SyncService) savePeers() {
+	var addresses []string
+	for _, m := range ss.members.Members() {
+		if m.ID != ss.id {
+			addresses = append(addresses, m.Address)
+		}
+	}
+	if len(addresses) == 0 {
+		return
+	}
+
+	ss.peersMu.Lock()
+	defer ss.peersMu.Unlock()
+
+	if err := discovery.WriteList(config.C.PeersFile, addresses); err != nil {
+		log.Printf("failed to save known members to %v: %v\n", config.C.PeersFile, err)
+	}
+}
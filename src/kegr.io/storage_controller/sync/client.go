@@ -3,25 +3,33 @@ package sync
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	grpc "google.golang.org/grpc"
+	pbMerkle "kegr.io/protobuf/model/merkle"
 	pbModel "kegr.io/protobuf/model/storage/server"
 	pbServer "kegr.io/protobuf/server/storage"
 
-	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/merkle"
 	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
 	"kegr.io/storage_controller/model/state"
+	"kegr.io/storage_controller/security"
 )
 
 type clientStatus int
 
 const (
-	ok       clientStatus = 0
-	mismatch clientStatus = 1
-	forward  clientStatus = 2
-	down     clientStatus = 3
+	ok           clientStatus = 0
+	mismatch     clientStatus = 1
+	forward      clientStatus = 2
+	down         clientStatus = 3
+	incompatible clientStatus = 4
 )
 
 // InternalClient holds the information for another kegr instance
@@ -31,7 +39,13 @@ type InternalClient struct {
 	address string
 	client  pbServer.InternalClient
 	conn    *grpc.ClientConn
-	status  clientStatus
+
+	// mu guards what's known of the peer's health
+	mu       sync.Mutex
+	status   clientStatus
+	lastPing time.Time
+	state    []byte
+	lastSync time.Time
 }
 
 // IInternalClient is the InternalClient interface
@@ -42,17 +56,35 @@ type IInternalClient interface {
 	GetAddress() string
 	Shutdown()
 
+	GetStatus() *pbServer.PeerStatus
+	SetSynced(at time.Time)
+
 	Ping(state state.IState) bool
-	Register(ourID, ourAddress, ourPort string) ([]*pbModel.ServerInfo, error)
-	GetState() map[string]keg.IKeg
-	GetLiquid(kegID, liquidID string) liquid.ILiquid
+	Register(ourID, ourAddress, ourPort, ourZone string, incarnation uint64) (*pbServer.RegisterResponse, error)
+	Probe(ctx context.Context, ourID string, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error)
+	IndirectProbe(ctx context.Context, ourID string, target *pbModel.ServerInfo, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error)
+	GetKegSummaries() ([]*pbServer.KegSummary, error)
+	GetNodeHashes(kegID, path string, depth int) ([]*pbMerkle.NodeHash, error)
+	GetLeaves(kegID string, paths []string) ([]*pbMerkle.Content, error)
+	GetLiquid(kegID, liquidID string, localOnly bool) (liquid.ILiquid, error)
+	StoreLiquid(ctx context.Context, kegID string, l liquid.ILiquid) error
+	GetKegLiquids(req *pbServer.GetKegLiquidsRequest) (*pbServer.GetKegLiquidsResponse, error)
+	ListLiquids(req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error)
+	GetLiquidProof(req *pbServer.GetLiquidProofRequest) (*pbServer.GetLiquidProofResponse, error)
+	UpdateLiquidOptions(req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error)
+	DeleteLiquid(req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error)
+	CreateKegSnapshot(req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error)
+	ListKegSnapshots(req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error)
+	RestoreKegSnapshot(req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error)
+	GetReleases(kegID string, after int64) []release.IRelease
+	Subscribe(ctx context.Context, ourID string, epoch int64, from uint64) (pbServer.Internal_SubscribeClient, error)
 }
 
 // NewInternalClient initialises connection to the remote cerberus instance
-func NewInternalClient(address string) IInternalClient {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+func NewInternalClient(address string) (IInternalClient, error) {
+	conn, err := grpc.Dial(address, security.InternalDialOption())
 	if err != nil {
-		log.Fatalf("fail to dial: %v", err)
+		return nil, err
 	}
 	client := pbServer.NewInternalClient(conn)
 
@@ -61,7 +93,7 @@ func NewInternalClient(address string) IInternalClient {
 		client:  client,
 		conn:    conn,
 		status:  ok,
-	}
+	}, nil
 }
 
 func (c *InternalClient) GetServerInfo() *pbModel.ServerInfo {
@@ -75,17 +107,59 @@ func (c *InternalClient) Shutdown() {
 	c.conn.Close()
 }
 
+// GetStatus returns the peer's health as of the last ping and sync
+func (c *InternalClient) GetStatus() *pbServer.PeerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &pbServer.PeerStatus{
+		Id:       c.id,
+		Address:  c.address,
+		Status:   pbServer.PeerStatus_Status(c.status),
+		LastPing: unix(c.lastPing),
+		State:    c.state,
+		LastSync: unix(c.lastSync),
+	}
+}
+
+// SetSynced records a successful full comparison with the peer
+func (c *InternalClient) SetSynced(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSync = at
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 // Ping pings the remote host to establish if they're still active
 func (c *InternalClient) Ping(state state.IState) bool {
 	s, err := state.GetHash()
 	if err != nil {
+		c.mu.Lock()
 		c.status = mismatch
+		c.mu.Unlock()
 		return false
 	}
 
+	res, err := c.client.Ping(context.Background(), &pbServer.PingRequest{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	prev := c.status
-	if res, err := c.client.Ping(context.Background(), &pbServer.PingRequest{}); err == nil {
-		if bytes.Equal(s, res.GetState()) {
+	if err == nil {
+		clock.Observe(res.GetTimestamp())
+		c.lastPing = time.Now()
+		c.state = res.GetState()
+		if res.GetHashVersion() != merkle.HashVersion {
+			// Its states and trees never match ours, so they aren't compared
+			c.status = incompatible
+		} else if bytes.Equal(s, res.GetState()) {
 			c.status = ok
 		} else {
 			c.status = mismatch
@@ -94,6 +168,10 @@ func (c *InternalClient) Ping(state state.IState) bool {
 		c.status = down
 	}
 
+	if prev != incompatible && c.status == incompatible {
+		log.Printf("%v at %v hashes with version %v, ours is %v, not comparing states\n", c.id, c.address, res.GetHashVersion(), merkle.HashVersion)
+	}
+
 	if c.status == mismatch {
 		log.Printf("state mismatch %v at %v\n", c.id, c.address)
 	}
@@ -114,52 +192,183 @@ func (c *InternalClient) Ping(state state.IState) bool {
 }
 
 // Register lets other instances on the cluster know we've joined
-func (c *InternalClient) Register(ourID, ourAddress, ourPort string) ([]*pbModel.ServerInfo, error) {
+func (c *InternalClient) Register(ourID, ourAddress, ourPort, ourZone string, incarnation uint64) (*pbServer.RegisterResponse, error) {
 	res, err := c.client.Register(context.Background(), &pbServer.RegisterRequest{
-		Id:      ourID,
-		Address: fmt.Sprintf("%v:%v", ourAddress, ourPort),
+		Id:          ourID,
+		Address:     fmt.Sprintf("%v:%v", ourAddress, ourPort),
+		Zone:        ourZone,
+		Incarnation: incarnation,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	var others []*pbModel.ServerInfo
-	for _, si := range res.Others {
-		others = append(others, &pbModel.ServerInfo{ID: si.ID, Address: si.Address})
-	}
 	c.id = res.Id
-	return others, nil
+	return res, nil
 }
 
-func (c *InternalClient) GetState() map[string]keg.IKeg {
-	log.Printf("forcing recheck with %v at %v\n", c.id, c.address)
-	state, err := c.client.GetState(context.Background(), &pbServer.GetStateRequest{})
+// Probe checks the peer is alive, exchanging membership updates with it
+func (c *InternalClient) Probe(ctx context.Context, ourID string, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	res, err := c.client.Probe(ctx, &pbServer.ProbeRequest{
+		Id:      ourID,
+		Updates: updates,
+	})
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+	return res.GetUpdates(), nil
+}
 
-	kegs := make(map[string]keg.IKeg)
+// IndirectProbe asks the peer to probe the target for us
+func (c *InternalClient) IndirectProbe(ctx context.Context, ourID string, target *pbModel.ServerInfo, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	res, err := c.client.IndirectProbe(ctx, &pbServer.IndirectProbeRequest{
+		Id:      ourID,
+		Target:  target,
+		Updates: updates,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetUpdates(), nil
+}
 
-	for id, k := range state.State.Kegs {
-		kegs[id] = keg.FromProto(k)
+// GetKegSummaries returns the peer's kegs without their merkle trees
+func (c *InternalClient) GetKegSummaries() ([]*pbServer.KegSummary, error) {
+	res, err := c.client.GetKegSummaries(context.Background(), &pbServer.GetKegSummariesRequest{})
+	if err != nil {
+		return nil, err
 	}
+	return res.GetKegs(), nil
+}
 
-	return kegs
+// GetNodeHashes returns the hashes of a subtree of one of the peer's kegs
+func (c *InternalClient) GetNodeHashes(kegID, path string, depth int) ([]*pbMerkle.NodeHash, error) {
+	res, err := c.client.GetNodeHashes(
+		context.Background(),
+		&pbServer.GetNodeHashesRequest{
+			KegId: kegID,
+			Path:  path,
+			Depth: int64(depth),
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetNodes(), nil
 }
 
-func (c *InternalClient) GetLiquid(kegID, liquidID string) liquid.ILiquid {
+// GetLeaves returns the merkle tree content below the given paths of one
+// of the peer's kegs
+func (c *InternalClient) GetLeaves(kegID string, paths []string) ([]*pbMerkle.Content, error) {
+	res, err := c.client.GetLeaves(
+		context.Background(),
+		&pbServer.GetLeavesRequest{
+			KegId: kegID,
+			Paths: paths,
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetContent(), nil
+}
+
+// GetLiquid fetches a liquid along with its content from the peer. Unless
+// localOnly is set the peer repairs its copy from the other owners first
+// when it's missing.
+func (c *InternalClient) GetLiquid(kegID, liquidID string, localOnly bool) (liquid.ILiquid, error) {
 	log.Printf("getting file %v from keg %v from %v", liquidID, kegID, c.address)
 	res, err := c.client.GetLiquid(
 		context.Background(),
 		&pbServer.GetLiquidRequest{
-			KegId:    kegID,
-			LiquidId: liquidID,
+			KegId:     kegID,
+			LiquidId:  liquidID,
+			LocalOnly: localOnly,
+		})
+	if err != nil {
+		return nil, err
+	}
+	if res.GetLiquid() == nil {
+		return nil, errors.New("Peer returned no liquid")
+	}
+	return liquid.FromProto(res.GetLiquid()), nil
+}
+
+// StoreLiquid has the peer durably store a liquid written through us
+func (c *InternalClient) StoreLiquid(ctx context.Context, kegID string, l liquid.ILiquid) error {
+	_, err := c.client.StoreLiquid(ctx, &pbServer.StoreLiquidRequest{
+		KegId:  kegID,
+		Liquid: l.ToProto(),
+	})
+	return err
+}
+
+// GetKegLiquids lists all liquids of a keg stored on the peer
+func (c *InternalClient) GetKegLiquids(req *pbServer.GetKegLiquidsRequest) (*pbServer.GetKegLiquidsResponse, error) {
+	return c.client.GetKegLiquids(context.Background(), req)
+}
+
+// ListLiquids pages through the liquids of a keg stored on the peer
+func (c *InternalClient) ListLiquids(req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error) {
+	return c.client.ListLiquids(context.Background(), req)
+}
+
+// GetLiquidProof asks the peer to prove a liquid is part of its keg's tree
+func (c *InternalClient) GetLiquidProof(req *pbServer.GetLiquidProofRequest) (*pbServer.GetLiquidProofResponse, error) {
+	return c.client.GetLiquidProof(context.Background(), req)
+}
+
+// UpdateLiquidOptions has the peer update the options of a liquid of a keg
+// it owns
+func (c *InternalClient) UpdateLiquidOptions(req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error) {
+	return c.client.UpdateLiquidOptions(context.Background(), req)
+}
+
+// DeleteLiquid has the peer delete a liquid of a keg it owns
+func (c *InternalClient) DeleteLiquid(req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error) {
+	return c.client.DeleteLiquid(context.Background(), req)
+}
+
+// CreateKegSnapshot has the peer freeze the contents of a keg it owns
+func (c *InternalClient) CreateKegSnapshot(req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error) {
+	return c.client.CreateKegSnapshot(context.Background(), req)
+}
+
+// ListKegSnapshots lists the snapshots the peer keeps of a keg
+func (c *InternalClient) ListKegSnapshots(req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error) {
+	return c.client.ListKegSnapshots(context.Background(), req)
+}
+
+// RestoreKegSnapshot has the peer roll a keg it owns back to a snapshot
+func (c *InternalClient) RestoreKegSnapshot(req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error) {
+	return c.client.RestoreKegSnapshot(context.Background(), req)
+}
+
+func (c *InternalClient) GetReleases(kegID string, after int64) []release.IRelease {
+	res, err := c.client.GetReleases(
+		context.Background(),
+		&pbServer.GetReleasesRequest{
+			KegId: kegID,
+			After: after,
 		})
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
-	return liquid.FromProto(res.Liquid)
+
+	var releases []release.IRelease
+	for _, r := range res.GetReleases() {
+		releases = append(releases, release.FromProto(r))
+	}
+	return releases
+}
+
+// Subscribe opens the peer's stream of change events from the given
+// sequence number on
+func (c *InternalClient) Subscribe(ctx context.Context, ourID string, epoch int64, from uint64) (pbServer.Internal_SubscribeClient, error) {
+	return c.client.Subscribe(ctx, &pbServer.SubscribeRequest{
+		Id:    ourID,
+		From:  from,
+		Epoch: epoch,
+	})
 }
 
 func (c *InternalClient) GetID() string {
@@ -0,0 +1,255 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+const maxFetchBackoff = 10 * time.Second
+
+// wanted is a version of a liquid a peer has advertised, either as a leaf
+// of its merkle tree or in a change event
+type wanted interface {
+	GetID() []byte
+	GetHash() []byte
+	GetLastUpdated() int64
+	GetUpdatedBy() string
+}
+
+// fetcher fetches liquids from peers. Every batch is spread over a bounded
+// pool of workers, each liquid is retried with backoff and checked against
+// the version the peer advertised before it's handed back. The ones that
+// still fail are kept and fetched again with the peer's next batch.
+type fetcher struct {
+	concurrency int
+	retries     int
+	backoff     time.Duration
+	bandwidth   int64
+
+	mu       sync.Mutex
+	limiters map[string]*limiter
+	failed   map[string]map[string]map[string]wanted
+}
+
+func newFetcher(concurrency, retries int, backoff time.Duration, bandwidth int64) *fetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &fetcher{
+		concurrency: concurrency,
+		retries:     retries,
+		backoff:     backoff,
+		bandwidth:   bandwidth,
+		limiters:    make(map[string]*limiter),
+		failed:      make(map[string]map[string]map[string]wanted),
+	}
+}
+
+// fetch fetches a keg's liquids from a peer, the smallest first so a few
+// large ones don't hold back the rest. The ones fetched and verified are
+// returned, the rest are recorded as failed.
+func (f *fetcher) fetch(client IInternalClient, kegID string, items []wanted) []liquid.ILiquid {
+	if len(items) == 0 {
+		return nil
+	}
+
+	items = append([]wanted(nil), items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return sizeOf(items[i]) < sizeOf(items[j])
+	})
+
+	workers := f.concurrency
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	results := make([]liquid.ILiquid, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = f.fetchOne(client, kegID, items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var liquids []liquid.ILiquid
+	for i, l := range results {
+		if l == nil {
+			f.fail(client.GetID(), kegID, items[i])
+			continue
+		}
+		liquids = append(liquids, l)
+	}
+	return liquids
+}
+
+// fetchOne fetches a single liquid, retrying until it's verified or the
+// retries run out
+func (f *fetcher) fetchOne(client IInternalClient, kegID string, item wanted) liquid.ILiquid {
+	id := string(item.GetID())
+	limiter := f.limiter(client.GetID())
+	backoff := f.backoff
+
+	for attempt := 0; ; attempt++ {
+		l, err := client.GetLiquid(kegID, id, true)
+		if err == nil {
+			limiter.wait(len(l.GetContent()))
+			if err = verify(l, item); err == nil {
+				return l
+			}
+		}
+
+		if attempt >= f.retries {
+			log.Printf("failed to fetch liquid %v of keg %v from %v: %v\n", id, kegID, client.GetID(), err)
+			return nil
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxFetchBackoff {
+			backoff = maxFetchBackoff
+		}
+	}
+}
+
+// sizeOf returns the size a peer advertised for a liquid, 0 when it didn't
+func sizeOf(item wanted) int64 {
+	if sized, ok := item.(interface{ GetSize() int64 }); ok {
+		return sized.GetSize()
+	}
+	return 0
+}
+
+// verify checks a fetched liquid is the version that was advertised, or a
+// later one if it has changed since, and that its content matches its hash.
+// Without an advertised version only the content is checked.
+func verify(l liquid.ILiquid, item wanted) error {
+	info := l.GetLiquidInfo()
+
+	if item != nil {
+		content, err := liquid.NewMerkleTreeLiquid(info)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(content.GetHash(), item.GetHash()) &&
+			!clock.Newer(info.GetLastUpdated(), info.GetUpdatedBy(), item.GetLastUpdated(), item.GetUpdatedBy()) {
+			return errors.New("Fetched liquid isn't the advertised version")
+		}
+	}
+
+	// Deleted liquids keep their hash but not necessarily their content
+	if len(l.GetFileHash()) > 0 && !info.IsDeleted() &&
+		!bytes.Equal(util.GetContentHash(l.GetContent()), l.GetFileHash()) {
+		return errors.New("Fetched liquid content doesn't match its hash")
+	}
+	return nil
+}
+
+func (f *fetcher) limiter(peerID string) *limiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, exist := f.limiters[peerID]
+	if !exist {
+		l = newLimiter(f.bandwidth)
+		f.limiters[peerID] = l
+	}
+	return l
+}
+
+func (f *fetcher) fail(peerID, kegID string, item wanted) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	kegs, exist := f.failed[peerID]
+	if !exist {
+		kegs = make(map[string]map[string]wanted)
+		f.failed[peerID] = kegs
+	}
+	items, exist := kegs[kegID]
+	if !exist {
+		items = make(map[string]wanted)
+		kegs[kegID] = items
+	}
+	items[string(item.GetID())] = item
+}
+
+// takeFailed returns the liquids of a keg that failed to be fetched from a
+// peer and forgets about them
+func (f *fetcher) takeFailed(peerID, kegID string) []wanted {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var items []wanted
+	for _, item := range f.failed[peerID][kegID] {
+		items = append(items, item)
+	}
+	delete(f.failed[peerID], kegID)
+	return items
+}
+
+// hasFailed reports whether anything failed to be fetched from a peer
+func (f *fetcher) hasFailed(peerID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.failed[peerID]) > 0
+}
+
+// forget drops everything kept about a peer that has left the cluster
+func (f *fetcher) forget(peerID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.failed, peerID)
+	delete(f.limiters, peerID)
+}
+
+// fetchLiquids fetches the liquids of a keg a peer has newer versions of,
+// along with the ones that failed to be fetched from it before. Only the
+// keg's owners store its liquids, so nothing is fetched for other kegs.
+func (ss *SyncService) fetchLiquids(client IInternalClient, k keg.IKeg, advertised []wanted) []liquid.ILiquid {
+	retried := ss.fetcher.takeFailed(client.GetID(), k.GetID())
+	if !ss.IsOwner(k) {
+		return nil
+	}
+
+	latest := make(map[string]wanted)
+	for _, item := range append(advertised, retried...) {
+		id := string(item.GetID())
+		if prev, exist := latest[id]; exist &&
+			!clock.Newer(item.GetLastUpdated(), item.GetUpdatedBy(), prev.GetLastUpdated(), prev.GetUpdatedBy()) {
+			continue
+		}
+		latest[id] = item
+	}
+
+	var items []wanted
+	for id, item := range latest {
+		if info, err := k.GetLiquidInfoByID(id); err == nil &&
+			!clock.Newer(item.GetLastUpdated(), item.GetUpdatedBy(), info.GetLastUpdated(), info.GetUpdatedBy()) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return ss.fetcher.fetch(client, k.GetID(), items)
+}
@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/util"
+)
+
+// flakyClient serves liquids the way a peer would, failing the first
+// requests for each one and corrupting the ones it's told to
+type flakyClient struct {
+	IInternalClient
+
+	mu       gosync.Mutex
+	liquids  map[string]liquid.ILiquid
+	failures map[string]int
+	corrupt  map[string]bool
+	requests map[string]int
+	order    []string
+}
+
+func (c *flakyClient) GetID() string {
+	return "peer"
+}
+
+func (c *flakyClient) GetLiquid(kegID, liquidID string, localOnly bool) (liquid.ILiquid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests[liquidID]++
+	c.order = append(c.order, liquidID)
+	if c.requests[liquidID] <= c.failures[liquidID] {
+		return nil, errors.New("unavailable")
+	}
+
+	original, exist := c.liquids[liquidID]
+	if !exist {
+		return nil, errors.New("not found")
+	}
+	l := liquid.FromProto(original.ToProto())
+	if c.corrupt[liquidID] {
+		l.SetContent([]byte("corrupted"))
+	}
+	return l, nil
+}
+
+func newTestLiquid(id string) liquid.ILiquid {
+	content := []byte(fmt.Sprintf("liquid %s", id))
+
+	options := liquid.NewOptions()
+	options.SetName(id)
+	options.SetExt("txt")
+
+	l := liquid.NewLiquid()
+	l.SetID(id)
+	l.SetContent(content)
+	l.SetSize(int64(len(content)))
+	l.SetFileHash(util.GetContentHash(content))
+	l.SetOptions(options)
+	l.Touch()
+	return l
+}
+
+func TestFetcherRetriesAndVerifies(t *testing.T) {
+	client := &flakyClient{
+		liquids:  make(map[string]liquid.ILiquid),
+		failures: map[string]int{"flaky": 2, "down": 10},
+		corrupt:  map[string]bool{"corrupt": true},
+		requests: make(map[string]int),
+	}
+
+	var items []wanted
+	for _, id := range []string{"a", "b", "c", "flaky", "down", "corrupt"} {
+		l := newTestLiquid(id)
+		client.liquids[id] = l
+		content, _ := liquid.NewMerkleTreeLiquid(l.GetLiquidInfo())
+		items = append(items, content)
+	}
+
+	f := newFetcher(3, 2, time.Millisecond, 0)
+	fetched := f.fetch(client, "keg", items)
+
+	got := make(map[string]bool)
+	for _, l := range fetched {
+		got[l.GetID()] = true
+	}
+	for _, id := range []string{"a", "b", "c", "flaky"} {
+		if !got[id] {
+			t.Errorf("expected %v to be fetched", id)
+		}
+	}
+	if got["down"] || got["corrupt"] {
+		t.Errorf("expected failing and corrupted liquids to be left out, got %v", got)
+	}
+	if client.requests["down"] != 3 || client.requests["corrupt"] != 3 {
+		t.Errorf("expected 3 attempts, got %v", client.requests)
+	}
+
+	if !f.hasFailed("peer") {
+		t.Fatal("expected the failed liquids to be recorded")
+	}
+	failed := f.takeFailed("peer", "keg")
+	if len(failed) != 2 {
+		t.Errorf("expected 2 failed liquids, got %d", len(failed))
+	}
+	if f.hasFailed("peer") {
+		t.Error("expected the failed liquids to be taken")
+	}
+}
+
+func TestFetcherSmallestFirst(t *testing.T) {
+	client := &flakyClient{
+		liquids:  make(map[string]liquid.ILiquid),
+		requests: make(map[string]int),
+	}
+
+	var items []wanted
+	for _, id := range []string{"ccc", "bb", "a"} {
+		l := newTestLiquid(id)
+		client.liquids[id] = l
+		content, _ := liquid.NewMerkleTreeLiquid(l.GetLiquidInfo())
+		items = append(items, content)
+	}
+
+	f := newFetcher(1, 0, time.Millisecond, 0)
+	if fetched := f.fetch(client, "keg", items); len(fetched) != 3 {
+		t.Fatalf("expected 3 liquids, got %d", len(fetched))
+	}
+	if fmt.Sprint(client.order) != "[a bb ccc]" {
+		t.Errorf("expected the smallest liquids first, got %v", client.order)
+	}
+}
+
+// TestVerifyUploadedLiquid checks a liquid uploaded through the REST API,
+// whose hash is read from the uploaded file, passes the checks its
+// replicas go through
+func TestVerifyUploadedLiquid(t *testing.T) {
+	l := newTestLiquid("uploaded")
+	l.SetFileHash(util.GetFileHash(bytes.NewReader(l.GetContent())))
+
+	item, _ := liquid.NewMerkleTreeLiquid(l.GetLiquidInfo())
+	if err := verify(l, item); err != nil {
+		t.Errorf("expected the uploaded liquid to be verified: %v", err)
+	}
+
+	// The hash of a file read before it's hashed covers nothing
+	l.SetFileHash(util.GetFileHash(bytes.NewReader(nil)))
+	if err := verify(l, nil); err == nil {
+		t.Error("expected content not matching its hash to be refused")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000)
+
+	start := time.Now()
+	l.wait(1000)
+	l.wait(500)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expected going over the limit to wait, took %v", elapsed)
+	}
+
+	var unlimited *limiter
+	unlimited.wait(1 << 30)
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter caps the bandwidth used to fetch from a peer. Reads are let
+// through and paid for after the fact, since a liquid's size is only known
+// once it has been fetched. The next read then waits until the debt is
+// paid off. Up to a second's worth of unused bandwidth can be saved up.
+type limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	available float64
+	last      time.Time
+}
+
+// newLimiter returns a limiter allowing rate bytes per second, or nil for
+// no limit
+func newLimiter(rate int64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{
+		rate:      float64(rate),
+		available: float64(rate),
+		last:      time.Now(),
+	}
+}
+
+// wait pays for n bytes read, blocking until the bandwidth used stays
+// within the limit
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.available += now.Sub(l.last).Seconds() * l.rate
+	if l.available > l.rate {
+		l.available = l.rate
+	}
+	l.last = now
+	l.available -= float64(n)
+
+	var delay time.Duration
+	if l.available < 0 {
+		delay = time.Duration(-l.available / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
@@ -0,0 +1,164 @@
+package sync
+
+import (
+	"context"
+	"log"
+
+	pbModel "kegr.io/protobuf/model/storage/server"
+	"kegr.io/storage_controller/membership"
+)
+
+// GetMembers returns every member of the cluster with its state and
+// incarnation
+func (ss *SyncService) GetMembers() []*pbModel.ServerInfo {
+	var members []*pbModel.ServerInfo
+	for _, m := range ss.members.Members() {
+		members = append(members, m.ToProto())
+	}
+	return members
+}
+
+// Leave announces to the cluster that this node is leaving and stops taking
+// part in it: probing, anti-entropy and joining through seeds all end
+func (ss *SyncService) Leave(ctx context.Context) {
+	close(ss.stop)
+	ss.members.Leave(ctx)
+	log.Printf("left the cluster\n")
+}
+
+// Join adds a node registering with us to the cluster and returns the
+// incarnation it should carry on with
+func (ss *SyncService) Join(id, address, zone string, incarnation uint64) uint64 {
+	return ss.members.Join(id, address, zone, incarnation)
+}
+
+// Probe answers a peer checking we're alive, exchanging membership updates
+func (ss *SyncService) Probe(updates []*pbModel.ServerInfo) []*pbModel.ServerInfo {
+	ss.members.Apply(updates)
+	return ss.members.Updates()
+}
+
+// IndirectProbe probes a member on behalf of a peer that couldn't reach it
+func (ss *SyncService) IndirectProbe(ctx context.Context, target *pbModel.ServerInfo, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	ss.members.Apply(updates)
+	if err := ss.members.ProbeDirect(ctx, membership.FromProto(target)); err != nil {
+		return nil, err
+	}
+	return ss.members.Updates(), nil
+}
+
+// MemberChanged keeps the clients, the placement ring and the persisted
+// member list in line with the membership. Suspects keep their share of
+// the ring, only members confirmed dead hand it over to the others.
+func (ss *SyncService) MemberChanged(m membership.Member) {
+	log.Printf("member %v at %v in zone %q is %v at incarnation %d\n", m.ID, m.Address, m.Zone, m.State, m.Incarnation)
+
+	switch m.State {
+	case pbModel.ServerInfo_ALIVE, pbModel.ServerInfo_SUSPECT:
+		ss.ring.Add(m.ID, m.Zone)
+		ss.connect(m)
+	case pbModel.ServerInfo_DEAD:
+		ss.ring.Remove(m.ID)
+	}
+
+	ss.savePeers()
+}
+
+// MemberRemoved drops the client of a member that has been dead for long
+// enough, which also stops following its changes
+func (ss *SyncService) MemberRemoved(id string) {
+	log.Printf("removing member %v\n", id)
+
+	ss.mu.Lock()
+	client, exist := ss.clients[id]
+	delete(ss.clients, id)
+	ss.mu.Unlock()
+
+	if exist {
+		client.Shutdown()
+	}
+	ss.fetcher.forget(id)
+
+	ss.savePeers()
+}
+
+// connect returns the client of a member, connecting to it if we haven't
+// yet or it has moved to another address
+func (ss *SyncService) connect(m membership.Member) (IInternalClient, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	client, exist := ss.clients[m.ID]
+	if exist && client.GetAddress() == m.Address {
+		return client, nil
+	}
+
+	if exist {
+		client.Shutdown()
+	}
+
+	client, err := NewInternalClient(m.Address)
+	if err != nil {
+		delete(ss.clients, m.ID)
+		return nil, err
+	}
+	client.SetID(m.ID)
+
+	log.Printf("connected to client %v at %v\n", client.GetID(), client.GetAddress())
+	ss.clients[m.ID] = client
+	go ss.follow(client)
+	go ss.antiEntropy(client)
+	return client, nil
+}
+
+// isConnected reports whether the client is still the one used for its
+// member
+func (ss *SyncService) isConnected(client IInternalClient) bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.clients[client.GetID()] == client
+}
+
+// getClient returns the client of a member
+func (ss *SyncService) getClient(id string) (IInternalClient, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	client, exist := ss.clients[id]
+	return client, exist
+}
+
+// aliveClients returns the clients of every member currently alive
+func (ss *SyncService) aliveClients() []IInternalClient {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var clients []IInternalClient
+	for id, client := range ss.clients {
+		if m, exist := ss.members.Get(id); exist && m.State == pbModel.ServerInfo_ALIVE {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// transport carries the membership protocol's probes over the internal
+// service
+type transport struct {
+	ss *SyncService
+}
+
+func (t *transport) Probe(ctx context.Context, target membership.Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	client, err := t.ss.connect(target)
+	if err != nil {
+		return nil, err
+	}
+	return client.Probe(ctx, t.ss.id, updates)
+}
+
+func (t *transport) IndirectProbe(ctx context.Context, via, target membership.Member, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error) {
+	client, err := t.ss.connect(via)
+	if err != nil {
+		return nil, err
+	}
+	return client.IndirectProbe(ctx, t.ss.id, target.ToProto(), updates)
+}
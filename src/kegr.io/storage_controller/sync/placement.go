@@ -0,0 +1,201 @@
+package sync
+
+import (
+	"errors"
+	"log"
+
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+)
+
+// ringVirtualNodes is how many points each node takes on the placement ring
+const ringVirtualNodes = 64
+
+// Owners returns the IDs of the nodes that store a keg's liquids
+func (ss *SyncService) Owners(k keg.IKeg) []string {
+	return ss.ring.Owners(k.GetID(), int(k.GetOptions().GetReplicas()))
+}
+
+// IsOwner reports whether this node stores a keg's liquids. Every node
+// knows about every keg, but only the owners hold its liquids.
+func (ss *SyncService) IsOwner(k keg.IKeg) bool {
+	return ss.isOwnedBy(k, ss.id)
+}
+
+// isOwnedBy reports whether a node stores a keg's liquids
+func (ss *SyncService) isOwnedBy(k keg.IKeg, id string) bool {
+	for _, owner := range ss.Owners(k) {
+		if owner == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLiquidFromOwners reads a liquid from the first owner of its keg that
+// has it
+func (ss *SyncService) GetLiquidFromOwners(k keg.IKeg, liquidID string) (liquid.ILiquid, error) {
+	for _, client := range ss.ownerClients(k) {
+		if l, err := client.GetLiquid(k.GetID(), liquidID, false); err == nil {
+			return l, nil
+		}
+	}
+	return nil, errors.New("Liquid not found on any owner")
+}
+
+// RepairLiquid fetches a liquid missing from disk from the keg's other
+// owners, so a read reaching this node before the write does still finds
+// it. A version older than the one our merkle tree knows of isn't taken.
+// The liquid is kept when this node owns the keg.
+func (ss *SyncService) RepairLiquid(k keg.IKeg, liquidID string) (liquid.ILiquid, error) {
+	var known wanted
+	if info, err := k.GetLiquidInfoByID(liquidID); err == nil {
+		if known, err = liquid.NewMerkleTreeLiquid(info); err != nil {
+			return nil, err
+		}
+	}
+
+	err := errors.New("Liquid not found on any owner")
+	for _, client := range ss.ownerClients(k) {
+		var l liquid.ILiquid
+		if l, err = client.GetLiquid(k.GetID(), liquidID, true); err != nil {
+			continue
+		}
+		if err = verify(l, known); err != nil {
+			continue
+		}
+
+		log.Printf("repaired liquid %v of keg %v from %v\n", liquidID, k.GetID(), client.GetID())
+		if ss.IsOwner(k) {
+			if err = ss.ss.ApplyReplicated(k.GetID(), []liquid.ILiquid{l}, nil); err != nil {
+				log.Printf("failed to keep repaired liquid %v of keg %v: %v\n", liquidID, k.GetID(), err)
+			}
+		}
+		return l, nil
+	}
+	return nil, err
+}
+
+// GetKegLiquidsFromOwners lists a keg's liquids on the first owner that
+// answers
+func (ss *SyncService) GetKegLiquidsFromOwners(k keg.IKeg, req *pbServer.GetKegLiquidsRequest) (*pbServer.GetKegLiquidsResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.GetKegLiquidsResponse
+		if res, err = client.GetKegLiquids(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// ListLiquidsFromOwners pages through a keg's liquids on the first owner
+// that answers
+func (ss *SyncService) ListLiquidsFromOwners(k keg.IKeg, req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.ListLiquidsResponse
+		if res, err = client.ListLiquids(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// GetLiquidProofFromOwners has the first owner that answers prove a liquid
+// is part of its keg's tree
+func (ss *SyncService) GetLiquidProofFromOwners(k keg.IKeg, req *pbServer.GetLiquidProofRequest) (*pbServer.GetLiquidProofResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.GetLiquidProofResponse
+		if res, err = client.GetLiquidProof(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// UpdateLiquidOptionsOnOwners has the first owner that answers update the
+// options of a liquid, which only the owners hold
+func (ss *SyncService) UpdateLiquidOptionsOnOwners(k keg.IKeg, req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.UpdateLiquidOptionsResponse
+		if res, err = client.UpdateLiquidOptions(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// DeleteLiquidOnOwners has the first owner that answers delete a liquid
+func (ss *SyncService) DeleteLiquidOnOwners(k keg.IKeg, req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.DeleteLiquidResponse
+		if res, err = client.DeleteLiquid(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// CreateKegSnapshotOnOwners has the first owner that answers freeze a keg.
+// The snapshot stays on that owner, which the other snapshot calls try
+// first as well.
+func (ss *SyncService) CreateKegSnapshotOnOwners(k keg.IKeg, req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.CreateKegSnapshotResponse
+		if res, err = client.CreateKegSnapshot(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// ListKegSnapshotsFromOwners lists the snapshots of a keg on the first owner
+// that answers
+func (ss *SyncService) ListKegSnapshotsFromOwners(k keg.IKeg, req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.ListKegSnapshotsResponse
+		if res, err = client.ListKegSnapshots(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// RestoreKegSnapshotOnOwners has the first owner holding the snapshot roll
+// the keg back to it
+func (ss *SyncService) RestoreKegSnapshotOnOwners(k keg.IKeg, req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error) {
+	err := errors.New("No owner of the keg is reachable")
+	for _, client := range ss.ownerClients(k) {
+		var res *pbServer.RestoreKegSnapshotResponse
+		if res, err = client.RestoreKegSnapshot(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// ownerClients returns the clients of the keg's owners other than us, the
+// ones in our zone first so reads stay within it when they can
+func (ss *SyncService) ownerClients(k keg.IKeg) []IInternalClient {
+	zone := ss.ring.Zone(ss.id)
+
+	var local, remote []IInternalClient
+	for _, owner := range ss.Owners(k) {
+		client, exist := ss.getClient(owner)
+		switch {
+		case !exist:
+		case ss.ring.Zone(owner) == zone:
+			local = append(local, client)
+		default:
+			remote = append(remote, client)
+		}
+	}
+	return append(local, remote...)
+}
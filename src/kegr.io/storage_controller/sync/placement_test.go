@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/placement"
+	"kegr.io/storage_controller/state"
+	"kegr.io/storage_controller/util"
+)
+
+func TestRepairLiquid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sync")
+	defer os.RemoveAll(dir)
+	config.C = &config.Config{
+		DataRoot:        dir,
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+	}
+
+	states := state.NewStateService()
+	options := keg.NewOptions()
+	options.SetName("site")
+	options.SetPath("site")
+	k, err := states.CreateKeg(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The write has reached our merkle tree but not our disk
+	written := newTestLiquid("written")
+	k.AddLiquid(written.GetLiquidInfo())
+
+	// Uploads through the REST API are hashed from the file read
+	uploaded := newTestLiquid("uploaded")
+	uploaded.SetFileHash(util.GetFileHash(bytes.NewReader(uploaded.GetContent())))
+
+	peer := &flakyClient{
+		liquids: map[string]liquid.ILiquid{
+			"written":   written,
+			"elsewhere": newTestLiquid("elsewhere"),
+			"uploaded":  uploaded,
+		},
+		requests: make(map[string]int),
+	}
+	ss := &SyncService{
+		id:      "self",
+		clients: map[string]IInternalClient{"peer": peer},
+		ring:    placement.NewRing(ringVirtualNodes),
+		ss:      states,
+	}
+	ss.ring.Add("self", "")
+	ss.ring.Add("peer", "")
+
+	for _, id := range []string{"written", "elsewhere", "uploaded"} {
+		l, err := ss.RepairLiquid(k, id)
+		if err != nil {
+			t.Fatalf("%v: %v", id, err)
+		}
+		if l.GetID() != id {
+			t.Errorf("expected %v, got %v", id, l.GetID())
+		}
+		if _, err = os.Stat(fmt.Sprintf("%s/%s/%s.liquid", dir, k.GetID(), id)); err != nil {
+			t.Errorf("expected the repaired %v to be kept: %v", id, err)
+		}
+	}
+
+	// A peer still holding an older version than the one we know of
+	// can't repair it
+	updated := liquid.FromProto(written.ToProto())
+	updated.Touch()
+	k.UpdateLiquid(updated.GetLiquidInfo())
+	if _, err = ss.RepairLiquid(k, "written"); err == nil {
+		t.Error("expected an older version to be refused")
+	}
+
+	if _, err = ss.RepairLiquid(k, "missing"); err == nil {
+		t.Error("expected a liquid no one has to stay missing")
+	}
+}
+
+func TestDeleteLiquidOnOwners(t *testing.T) {
+	options := keg.NewOptions()
+	options.SetReplicas(2)
+	k := keg.NewKeg(options)
+
+	up := &replicaClient{id: "up", liquids: map[string]liquid.ILiquid{"written": newTestLiquid("written")}}
+	down := &replicaClient{id: "down", down: true, liquids: make(map[string]liquid.ILiquid)}
+	ss := &SyncService{
+		id:      "self",
+		clients: map[string]IInternalClient{"up": up, "down": down},
+		ring:    placement.NewRing(ringVirtualNodes),
+	}
+
+	// We hold none of the keg's liquids, so the delete is made by an owner
+	ss.ring.Add("up", "")
+	ss.ring.Add("down", "")
+	req := &pbServer.DeleteLiquidRequest{KegId: k.GetID(), LiquidId: "written"}
+	if _, err := ss.DeleteLiquidOnOwners(k, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := up.liquids["written"]; exist {
+		t.Error("expected the owner that's up to make the delete")
+	}
+
+	up.down = true
+	if _, err := ss.DeleteLiquidOnOwners(k, req); err == nil {
+		t.Error("expected the delete to fail with no owner reachable")
+	}
+}
@@ -0,0 +1,156 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	pbKeg "kegr.io/protobuf/model/storage/keg"
+	"kegr.io/storage_controller/clock"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+)
+
+// Consistency returns the level a request is served at, the keg's own when
+// the request doesn't ask for one, and ONE when neither does
+func Consistency(requested, kegDefault pbKeg.Consistency) pbKeg.Consistency {
+	if requested != pbKeg.Consistency_DEFAULT {
+		return requested
+	}
+	if kegDefault != pbKeg.Consistency_DEFAULT {
+		return kegDefault
+	}
+	return pbKeg.Consistency_ONE
+}
+
+// required returns how many of a keg's replicas a level has to hear from
+func required(level pbKeg.Consistency, replicas int) int {
+	switch level {
+	case pbKeg.Consistency_QUORUM:
+		return replicas/2 + 1
+	case pbKeg.Consistency_ALL:
+		return replicas
+	}
+	return 1
+}
+
+// StoreOnOwners makes sure a liquid written through this node is durably
+// stored on as many of its keg's owners as the level requires, our own copy
+// counting when we're one of them. The write still reaches the rest of the
+// owners through replication.
+func (ss *SyncService) StoreOnOwners(k keg.IKeg, l liquid.ILiquid, level pbKeg.Consistency) error {
+	owners := ss.Owners(k)
+	need := required(level, len(owners))
+
+	acks := 0
+	if ss.IsOwner(k) {
+		acks++
+	}
+	if acks >= need {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.QuorumTimeout)
+	defer cancel()
+
+	clients := ss.ownerClients(k)
+	results := make(chan error, len(clients))
+	for _, client := range clients {
+		client := client
+		go func() {
+			results <- client.StoreLiquid(ctx, k.GetID(), l)
+		}()
+	}
+
+	for range clients {
+		err := <-results
+		if err != nil {
+			log.Printf("failed to store liquid %v of keg %v on a replica: %v\n", l.GetID(), k.GetID(), err)
+			continue
+		}
+		if acks++; acks >= need {
+			return nil
+		}
+	}
+	return fmt.Errorf("Write stored on %d of %d required replicas", acks, need)
+}
+
+// ReadFromOwners reads a liquid from as many of its keg's owners as the
+// level requires and returns the latest version they have. Our copy is
+// repaired when it turns out to be behind.
+func (ss *SyncService) ReadFromOwners(k keg.IKeg, liquidID string, level pbKeg.Consistency) (liquid.ILiquid, error) {
+	owners := ss.Owners(k)
+	need := required(level, len(owners))
+
+	var local liquid.ILiquid
+	answers := 0
+	if ss.IsOwner(k) {
+		if l, err := k.ReadLiquid(liquidID); err == nil {
+			local = l
+			answers++
+		}
+	}
+	latest := local
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.QuorumTimeout)
+	defer cancel()
+
+	clients := ss.ownerClients(k)
+	results := make(chan liquid.ILiquid, len(clients))
+	for _, client := range clients {
+		client := client
+		go func() {
+			l, err := client.GetLiquid(k.GetID(), liquidID, true)
+			if err == nil && verify(l, nil) != nil {
+				l = nil
+			}
+			results <- l
+		}()
+	}
+
+	for pending := len(clients); answers < need && pending > 0; pending-- {
+		var l liquid.ILiquid
+		select {
+		case l = <-results:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Read answered by %d of %d required replicas", answers, need)
+		}
+		if l == nil {
+			continue
+		}
+		answers++
+		if latest == nil || clock.Newer(l.GetLastUpdated(), l.GetUpdatedBy(), latest.GetLastUpdated(), latest.GetUpdatedBy()) {
+			latest = l
+		}
+	}
+	if answers < need {
+		return nil, fmt.Errorf("Read answered by %d of %d required replicas", answers, need)
+	}
+
+	if latest != local && ss.IsOwner(k) {
+		if err := ss.StoreReplica(k.GetID(), latest); err != nil {
+			log.Printf("failed to repair liquid %v of keg %v: %v\n", liquidID, k.GetID(), err)
+		}
+	}
+	return latest, nil
+}
+
+// StoreReplica durably stores a liquid a peer wrote, unless we already
+// have a later version of it. The same version is written again, as our
+// merkle tree may know of it before our disk holds it.
+func (ss *SyncService) StoreReplica(kegID string, l liquid.ILiquid) error {
+	k, err := ss.ss.GetKegByID(kegID)
+	if err != nil {
+		return err
+	}
+	if err = verify(l, nil); err != nil {
+		return err
+	}
+
+	if info, err := k.GetLiquidInfoByID(l.GetID()); err == nil &&
+		clock.Newer(info.GetLastUpdated(), info.GetUpdatedBy(), l.GetLastUpdated(), l.GetUpdatedBy()) {
+		return nil
+	}
+	return ss.ss.ApplyReplicated(kegID, []liquid.ILiquid{l}, nil)
+}
@@ -0,0 +1,213 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	gosync "sync"
+	"testing"
+	"time"
+
+	pbKeg "kegr.io/protobuf/model/storage/keg"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/placement"
+	"kegr.io/storage_controller/state"
+	"kegr.io/storage_controller/util"
+)
+
+// replicaClient is an owner of a keg storing what it's sent, or failing
+// every call when it's down
+type replicaClient struct {
+	IInternalClient
+
+	id      string
+	down    bool
+	mu      gosync.Mutex
+	liquids map[string]liquid.ILiquid
+}
+
+func (c *replicaClient) GetID() string {
+	return c.id
+}
+
+func (c *replicaClient) StoreLiquid(ctx context.Context, kegID string, l liquid.ILiquid) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.down {
+		return errors.New("unavailable")
+	}
+	c.liquids[l.GetID()] = l
+	return nil
+}
+
+func (c *replicaClient) GetLiquid(kegID, liquidID string, localOnly bool) (liquid.ILiquid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, exist := c.liquids[liquidID]
+	if c.down || !exist {
+		return nil, errors.New("unavailable")
+	}
+	return l, nil
+}
+
+func (c *replicaClient) DeleteLiquid(req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.down {
+		return nil, errors.New("unavailable")
+	}
+	delete(c.liquids, req.GetLiquidId())
+	return &pbServer.DeleteLiquidResponse{}, nil
+}
+
+func TestConsistency(t *testing.T) {
+	cases := []struct {
+		requested, kegDefault, expected pbKeg.Consistency
+	}{
+		{pbKeg.Consistency_DEFAULT, pbKeg.Consistency_DEFAULT, pbKeg.Consistency_ONE},
+		{pbKeg.Consistency_DEFAULT, pbKeg.Consistency_QUORUM, pbKeg.Consistency_QUORUM},
+		{pbKeg.Consistency_ALL, pbKeg.Consistency_QUORUM, pbKeg.Consistency_ALL},
+	}
+	for _, c := range cases {
+		if level := Consistency(c.requested, c.kegDefault); level != c.expected {
+			t.Errorf("expected %v for %v on a %v keg, got %v", c.expected, c.requested, c.kegDefault, level)
+		}
+	}
+
+	for level, expected := range map[pbKeg.Consistency]int{
+		pbKeg.Consistency_ONE:    1,
+		pbKeg.Consistency_QUORUM: 2,
+		pbKeg.Consistency_ALL:    3,
+	} {
+		if need := required(level, 3); need != expected {
+			t.Errorf("expected %v of 3 replicas for %v, got %v", expected, level, need)
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sync")
+	defer os.RemoveAll(dir)
+	config.C = &config.Config{
+		DataRoot:        dir,
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+		QuorumTimeout:   time.Second,
+	}
+
+	states := state.NewStateService()
+	options := keg.NewOptions()
+	options.SetName("site")
+	options.SetPath("site")
+	options.SetReplicas(3)
+	k, err := states.CreateKeg(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := &replicaClient{id: "up", liquids: make(map[string]liquid.ILiquid)}
+	down := &replicaClient{id: "down", down: true, liquids: make(map[string]liquid.ILiquid)}
+	ss := &SyncService{
+		id:      "self",
+		clients: map[string]IInternalClient{"up": up, "down": down},
+		ring:    placement.NewRing(ringVirtualNodes),
+		ss:      states,
+	}
+	for _, id := range []string{"self", "up", "down"} {
+		ss.ring.Add(id, "")
+	}
+
+	written := newTestLiquid("written")
+	if err = states.ApplyReplicated(k.GetID(), []liquid.ILiquid{written}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ss.StoreOnOwners(k, written, pbKeg.Consistency_QUORUM); err != nil {
+		t.Errorf("expected our copy and one replica to make a quorum: %v", err)
+	}
+	if _, exist := up.liquids["written"]; !exist {
+		t.Error("expected the write to be stored on the replica that's up")
+	}
+	if err = ss.StoreOnOwners(k, written, pbKeg.Consistency_ALL); err == nil {
+		t.Error("expected a write to every replica to fail with one down")
+	}
+
+	// A replica holding a later version wins the read, and repairs ours
+	updated := liquid.FromProto(written.ToProto())
+	updated.SetContent([]byte("updated"))
+	updated.SetFileHash(util.GetContentHash(updated.GetContent()))
+	updated.Touch()
+	up.liquids["written"] = updated
+
+	l, err := ss.ReadFromOwners(k, "written", pbKeg.Consistency_QUORUM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(l.GetContent()) != "updated" {
+		t.Errorf("expected the latest version, got %q", l.GetContent())
+	}
+	if info, _ := k.GetLiquidInfoByID("written"); info.GetLastUpdated() != updated.GetLastUpdated() {
+		t.Error("expected our copy to be repaired")
+	}
+
+	if _, err = ss.ReadFromOwners(k, "written", pbKeg.Consistency_ALL); err == nil {
+		t.Error("expected a read from every replica to fail with one down")
+	}
+}
+
+// TestQuorumUploadedLiquid checks liquids uploaded through the REST API,
+// whose hash is read from the uploaded file, are taken by replicas and
+// count towards a quorum read
+func TestQuorumUploadedLiquid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sync")
+	defer os.RemoveAll(dir)
+	config.C = &config.Config{
+		DataRoot:        dir,
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+		QuorumTimeout:   time.Second,
+	}
+
+	states := state.NewStateService()
+	options := keg.NewOptions()
+	options.SetName("site")
+	options.SetPath("site")
+	options.SetReplicas(2)
+	k, err := states.CreateKeg(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := &replicaClient{id: "up", liquids: make(map[string]liquid.ILiquid)}
+	ss := &SyncService{
+		id:      "self",
+		clients: map[string]IInternalClient{"up": up},
+		ring:    placement.NewRing(ringVirtualNodes),
+		ss:      states,
+	}
+	ss.ring.Add("self", "")
+	ss.ring.Add("up", "")
+
+	uploaded := newTestLiquid("uploaded")
+	uploaded.SetFileHash(util.GetFileHash(bytes.NewReader(uploaded.GetContent())))
+	if err = ss.StoreReplica(k.GetID(), uploaded); err != nil {
+		t.Fatalf("expected the uploaded liquid to be stored: %v", err)
+	}
+
+	up.liquids["uploaded"] = uploaded
+	l, err := ss.ReadFromOwners(k, "uploaded", pbKeg.Consistency_ALL)
+	if err != nil {
+		t.Fatalf("expected both copies to answer: %v", err)
+	}
+	if string(l.GetContent()) != string(uploaded.GetContent()) {
+		t.Errorf("expected the uploaded content, got %q", l.GetContent())
+	}
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	pbMerkle "kegr.io/protobuf/model/merkle"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/model/liquid"
+)
+
+// remoteTree is the merkle tree of a keg on a peer, read through its
+// internal service
+type remoteTree struct {
+	client IInternalClient
+	kegID  string
+}
+
+func newRemoteTree(client IInternalClient, kegID string) merkle.Remote {
+	return &remoteTree{
+		client: client,
+		kegID:  kegID,
+	}
+}
+
+// GetNodeHashes returns the hashes of a subtree of the peer's tree
+func (rt *remoteTree) GetNodeHashes(path string, depth int) ([]*pbMerkle.NodeHash, error) {
+	return rt.client.GetNodeHashes(rt.kegID, path, depth)
+}
+
+// GetLeaves returns the peer's content below the given paths
+func (rt *remoteTree) GetLeaves(paths []string) ([]merkle.IContent, error) {
+	pbContent, err := rt.client.GetLeaves(rt.kegID, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []merkle.IContent
+	for _, c := range pbContent {
+		l := merkle.ContentFromProto(c, newContent).(*liquid.MerkleTreeLiquid)
+		l.SetSize(c.GetSize())
+		content = append(content, l)
+	}
+	return content, nil
+}
+
+func newContent() merkle.IContent {
+	return liquid.NewEmptyMerkleTreeLiquid()
+}
@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"bytes"
+	"math/rand"
+	"time"
+
+	pbModel "kegr.io/protobuf/model/storage/server"
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+)
+
+// checkOutcome is what comparing a peer's state with ours found
+type checkOutcome int
+
+const (
+	inSync   checkOutcome = 0
+	resynced checkOutcome = 1
+	peerDown checkOutcome = 2
+)
+
+// schedule decides when a peer's state is next compared with ours. Peers in
+// sync are checked every interval. Right after a difference was resynced
+// the peer is checked again within mismatch, backing off to the interval
+// for as long as differences keep turning up. Peers that are down are
+// checked less and less often, up to max apart.
+type schedule struct {
+	interval time.Duration
+	mismatch time.Duration
+	max      time.Duration
+
+	mismatches int
+	failures   int
+}
+
+func newSchedule(interval, mismatch, max time.Duration) *schedule {
+	if mismatch <= 0 || mismatch > interval {
+		mismatch = interval
+	}
+	if max < interval {
+		max = interval
+	}
+	return &schedule{
+		interval: interval,
+		mismatch: mismatch,
+		max:      max,
+	}
+}
+
+// next returns how long to wait before the next check, given the outcome
+// of the last one
+func (s *schedule) next(outcome checkOutcome) time.Duration {
+	switch outcome {
+	case resynced:
+		s.failures = 0
+		delay := backoff(s.mismatch, s.interval, s.mismatches)
+		s.mismatches++
+		return jitter(delay)
+	case peerDown:
+		s.mismatches = 0
+		delay := backoff(s.interval, s.max, s.failures)
+		s.failures++
+		return jitter(delay)
+	}
+
+	s.mismatches, s.failures = 0, 0
+	return jitter(s.interval)
+}
+
+// backoff doubles base attempts times, up to max
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 0; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// jitter moves a delay by up to a fifth either way, so the checks of the
+// cluster's nodes don't line up
+func jitter(delay time.Duration) time.Duration {
+	spread := int64(delay) / 5
+	if spread <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// antiEntropy is the anti-entropy safety net for one peer. Changes normally
+// arrive through follow as they happen, so the peer's state is only
+// compared with ours on its schedule to catch anything the change stream
+// missed. Every peer runs its own loop, so a slow one doesn't hold back
+// the checks of the others. It stops once the client is replaced.
+func (ss *SyncService) antiEntropy(client IInternalClient) {
+	s := newSchedule(config.C.AntiEntropyInterval, config.C.AntiEntropyMismatchInterval, config.C.AntiEntropyMaxBackoff)
+	timer := time.NewTimer(jitter(s.interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ss.stop:
+			return
+		case <-timer.C:
+		}
+
+		if !ss.isConnected(client) {
+			return
+		}
+		timer.Reset(s.next(ss.checkPeer(client)))
+	}
+}
+
+// resyncConcurrency returns how many rechecks may run at once, at least one
+func resyncConcurrency(configured int) int {
+	if configured < 1 {
+		return 1
+	}
+	return configured
+}
+
+// checkPeer compares a peer's state with ours and resyncs when they differ.
+// The whole states never match when the two nodes own different kegs, so
+// then only the kegs they both own are compared before resyncing.
+func (ss *SyncService) checkPeer(client IInternalClient) checkOutcome {
+	if m, exist := ss.members.Get(client.GetID()); !exist || m.State != pbModel.ServerInfo_ALIVE {
+		return peerDown
+	}
+
+	same := client.Ping(ss.ss.GetState())
+	if client.GetStatus().GetStatus() == pbServer.PeerStatus_DOWN {
+		return peerDown
+	}
+
+	// Liquids that failed to be fetched are retried even when the states
+	// match, which they can without us owning every keg
+	if !ss.fetcher.hasFailed(client.GetID()) {
+		if same {
+			return inSync
+		}
+		differ, err := ss.kegsDiffer(client)
+		if err != nil {
+			return peerDown
+		}
+		if !differ {
+			return inSync
+		}
+	}
+
+	if err := ss.forceRecheck(client); err != nil {
+		return peerDown
+	}
+	return resynced
+}
+
+// kegsDiffer reports whether a recheck would take anything from a peer: a
+// keg we don't know of, a later version of a keg, or another merkle tree
+// for a keg we both own. The trees of kegs only one of us owns are left out.
+func (ss *SyncService) kegsDiffer(client IInternalClient) (bool, error) {
+	summaries, err := client.GetKegSummaries()
+	if err != nil {
+		return false, err
+	}
+
+	for _, summary := range summaries {
+		other := keg.FromProto(summary.GetKeg())
+		local, err := ss.ss.GetKegByID(other.GetID())
+		if err != nil {
+			return true, nil
+		}
+
+		kegDiff, err := local.DiffRemote(other, summary.GetTreeHash(), nil)
+		if err != nil || kegDiff.Options != nil || kegDiff.Deleted || kegDiff.Release > 0 {
+			return true, nil
+		}
+
+		if ss.IsOwner(local) && ss.isOwnedBy(local, client.GetID()) &&
+			!bytes.Equal(local.GetTree().Hash(), summary.GetTreeHash()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	pbServer "kegr.io/protobuf/server/storage"
+	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/placement"
+	"kegr.io/storage_controller/state"
+)
+
+func TestSchedule(t *testing.T) {
+	s := newSchedule(30*time.Second, 2*time.Second, 5*time.Minute)
+
+	within := func(delay, expected time.Duration) bool {
+		return delay >= expected-expected/5 && delay <= expected+expected/5
+	}
+
+	if delay := s.next(inSync); !within(delay, 30*time.Second) {
+		t.Errorf("expected a peer in sync to be checked every interval, got %v", delay)
+	}
+
+	// Rechecks come quickly after a difference, backing off to the interval
+	// while they keep finding one
+	for _, expected := range []time.Duration{2, 4, 8, 16, 30, 30} {
+		if delay := s.next(resynced); !within(delay, expected*time.Second) {
+			t.Errorf("expected a recheck within %vs, got %v", expected, delay)
+		}
+	}
+
+	for _, expected := range []time.Duration{30, 60, 120, 240, 300, 300} {
+		if delay := s.next(peerDown); !within(delay, expected*time.Second) {
+			t.Errorf("expected a peer that's down to be checked again after %vs, got %v", expected, delay)
+		}
+	}
+
+	if delay := s.next(resynced); !within(delay, 2*time.Second) {
+		t.Errorf("expected a peer back up to be rechecked quickly, got %v", delay)
+	}
+	if delay := s.next(inSync); !within(delay, 30*time.Second) {
+		t.Errorf("expected a peer back in sync to be checked every interval, got %v", delay)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		delay := jitter(10 * time.Second)
+		if delay < 8*time.Second || delay > 12*time.Second {
+			t.Fatalf("expected at most a fifth of jitter, got %v", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected delays to vary")
+	}
+}
+
+// summaryClient serves a peer's keg summaries
+type summaryClient struct {
+	IInternalClient
+	summaries []*pbServer.KegSummary
+}
+
+func (c *summaryClient) GetID() string {
+	return "peer"
+}
+
+func (c *summaryClient) GetKegSummaries() ([]*pbServer.KegSummary, error) {
+	return c.summaries, nil
+}
+
+func TestKegsDiffer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sync")
+	defer os.RemoveAll(dir)
+	config.C = &config.Config{
+		DataRoot:        dir,
+		LiquidExtension: "liquid",
+		KegFile:         ".keg",
+	}
+
+	states := state.NewStateService()
+	ss := &SyncService{
+		id:   "self",
+		ring: placement.NewRing(ringVirtualNodes),
+		ss:   states,
+	}
+	ss.ring.Add("self", "")
+	ss.ring.Add("peer", "")
+
+	// One keg both nodes own and one only one of them does
+	var shared, single keg.IKeg
+	for _, replicas := range []int64{2, 1} {
+		options := keg.NewOptions()
+		options.SetName(fmt.Sprint(replicas))
+		options.SetPath(fmt.Sprint(replicas))
+		options.SetReplicas(replicas)
+		k, err := states.CreateKeg(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replicas == 2 {
+			shared = k
+		} else {
+			single = k
+		}
+	}
+
+	summary := func(k keg.IKeg, treeHash []byte) *pbServer.KegSummary {
+		pb := k.ToProto()
+		pb.Tree = nil
+		return &pbServer.KegSummary{Keg: pb, TreeHash: treeHash}
+	}
+	other := []byte("other")
+
+	for _, c := range []struct {
+		summaries []*pbServer.KegSummary
+		differ    bool
+	}{
+		{[]*pbServer.KegSummary{summary(shared, shared.GetTree().Hash()), summary(single, other)}, false},
+		{[]*pbServer.KegSummary{summary(shared, other), summary(single, single.GetTree().Hash())}, true},
+	} {
+		differ, err := ss.kegsDiffer(&summaryClient{summaries: c.summaries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if differ != c.differ {
+			t.Errorf("expected kegs to differ %v, got %v", c.differ, differ)
+		}
+	}
+
+	// A keg we don't know of is a difference too
+	unknown := keg.NewKegWithID("unknown", keg.NewOptions())
+	differ, _ := ss.kegsDiffer(&summaryClient{summaries: []*pbServer.KegSummary{summary(unknown, nil)}})
+	if !differ {
+		t.Error("expected an unknown keg to be a difference")
+	}
+}
@@ -1,48 +1,123 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	pbKeg "kegr.io/protobuf/model/storage/keg"
+	pbReplication "kegr.io/protobuf/model/storage/replication"
 	pbModel "kegr.io/protobuf/model/storage/server"
+	pbServer "kegr.io/protobuf/server/storage"
 	"kegr.io/storage_controller/config"
+	"kegr.io/storage_controller/membership"
+	"kegr.io/storage_controller/merkle"
+	"kegr.io/storage_controller/model/keg"
+	"kegr.io/storage_controller/model/liquid"
+	"kegr.io/storage_controller/model/release"
+	"kegr.io/storage_controller/placement"
 	"kegr.io/storage_controller/state"
 )
 
+const (
+	minFollowBackoff = 1 * time.Second
+	maxFollowBackoff = 30 * time.Second
+
+	minJoinBackoff = 1 * time.Second
+	maxJoinBackoff = 1 * time.Minute
+)
+
 // SyncService holds information of all the connected clients
 // in the cluster
 type SyncService struct {
 	ISyncService
 
 	id      string
+	address string
+	mu      sync.RWMutex
 	clients map[string]IInternalClient
+	fetcher *fetcher
+	resyncs chan struct{}
+	peersMu sync.Mutex
+	members membership.IMembership
+	ring    placement.IRing
 	ss      state.IStateService
+	stop    chan struct{}
 }
 
 // ISyncService is the SyncService interface
 type ISyncService interface {
 	GetID() string
-	Register(clusterMember string)
-	GetClientAddresses() []*pbModel.ServerInfo
-	AddClient(id, address string)
+	Register(clusterMember string) error
+	Leave(ctx context.Context)
+
+	// Membership
+	GetMembers() []*pbModel.ServerInfo
+	Join(id, address, zone string, incarnation uint64) uint64
+	Probe(updates []*pbModel.ServerInfo) []*pbModel.ServerInfo
+	IndirectProbe(ctx context.Context, target *pbModel.ServerInfo, updates []*pbModel.ServerInfo) ([]*pbModel.ServerInfo, error)
+
+	// Administration
+	Status() []*pbServer.PeerStatus
+	ForceSync(peerID string) error
+	EvictPeer(peerID string) error
+	DiffWithPeer(peerID string) ([]*pbServer.KegTransfer, error)
+
+	// Placement
+	Owners(k keg.IKeg) []string
+	IsOwner(k keg.IKeg) bool
+	GetLiquidFromOwners(k keg.IKeg, liquidID string) (liquid.ILiquid, error)
+	RepairLiquid(k keg.IKeg, liquidID string) (liquid.ILiquid, error)
+	GetKegLiquidsFromOwners(k keg.IKeg, req *pbServer.GetKegLiquidsRequest) (*pbServer.GetKegLiquidsResponse, error)
+	ListLiquidsFromOwners(k keg.IKeg, req *pbServer.ListLiquidsRequest) (*pbServer.ListLiquidsResponse, error)
+	GetLiquidProofFromOwners(k keg.IKeg, req *pbServer.GetLiquidProofRequest) (*pbServer.GetLiquidProofResponse, error)
+	UpdateLiquidOptionsOnOwners(k keg.IKeg, req *pbServer.UpdateLiquidOptionsRequest) (*pbServer.UpdateLiquidOptionsResponse, error)
+	DeleteLiquidOnOwners(k keg.IKeg, req *pbServer.DeleteLiquidRequest) (*pbServer.DeleteLiquidResponse, error)
+	CreateKegSnapshotOnOwners(k keg.IKeg, req *pbServer.CreateKegSnapshotRequest) (*pbServer.CreateKegSnapshotResponse, error)
+	ListKegSnapshotsFromOwners(k keg.IKeg, req *pbServer.ListKegSnapshotsRequest) (*pbServer.ListKegSnapshotsResponse, error)
+	RestoreKegSnapshotOnOwners(k keg.IKeg, req *pbServer.RestoreKegSnapshotRequest) (*pbServer.RestoreKegSnapshotResponse, error)
+
+	// Consistency
+	StoreOnOwners(k keg.IKeg, l liquid.ILiquid, level pbKeg.Consistency) error
+	ReadFromOwners(k keg.IKeg, liquidID string, level pbKeg.Consistency) (liquid.ILiquid, error)
+	StoreReplica(kegID string, l liquid.ILiquid) error
 }
 
-// NewSyncService takes a single cluster member and registers
-// recursively with it and any other cluster member it
-// gets.
+// NewSyncService joins the cluster through the configured seeds or
+// the members known before a restart. The rest of the members are
+// learned from them and kept track of by the membership protocol.
 func NewSyncService(ss state.IStateService) *SyncService {
 	serv := &SyncService{
 		id:      config.C.MachineName,
+		address: fmt.Sprintf("%v:%v", config.C.Address, config.C.InternalGrpcPort),
 		ss:      ss,
 		clients: make(map[string]IInternalClient),
+		fetcher: newFetcher(config.C.FetchConcurrency, config.C.FetchRetries, config.C.FetchBackoff, config.C.PeerBandwidth),
+		resyncs: make(chan struct{}, resyncConcurrency(config.C.ResyncConcurrency)),
+		ring:    placement.NewRing(ringVirtualNodes),
+		stop:    make(chan struct{}),
 	}
+	serv.ring.Add(serv.id, config.C.Zone)
+	serv.members = membership.New(
+		serv.id,
+		serv.address,
+		config.C.Zone,
+		membership.Config{
+			ProbeInterval:    config.C.ProbeInterval,
+			ProbeTimeout:     config.C.ProbeTimeout,
+			IndirectProbes:   config.C.IndirectProbes,
+			SuspicionTimeout: config.C.SuspicionTimeout,
+			RemovalTimeout:   config.C.DeadMemberTimeout,
+		},
+		&transport{ss: serv},
+		serv,
+	)
 
-	go serv.monitor()
-
-	if len(config.C.Other) > 0 {
-		serv.Register(config.C.Other)
-	}
+	go serv.members.Run(serv.stop)
+	go serv.bootstrap()
 
 	return serv
 }
@@ -52,78 +127,215 @@ func (ss *SyncService) GetID() string {
 	return ss.id
 }
 
-// Register initialises connection with a cluster
-func (ss *SyncService) Register(clusterMember string) {
-	var instances []*pbModel.ServerInfo
-
+// Register joins the cluster through one of its members, which answers
+// with every member it knows of
+func (ss *SyncService) Register(clusterMember string) error {
 	log.Printf("trying to connect to cluster %v\n", clusterMember)
 
-	client := NewInternalClient(clusterMember)
-	instances, _ = client.Register(ss.id, config.C.Address, config.C.InternalGrpcPort)
+	client, err := NewInternalClient(clusterMember)
+	if err != nil {
+		return err
+	}
+	defer client.Shutdown()
+
+	res, err := client.Register(ss.id, config.C.Address, config.C.InternalGrpcPort, config.C.Zone, ss.members.GetSelf().Incarnation)
+	if err != nil {
+		return err
+	}
+	if res.GetId() == ss.id {
+		// A seed list or DNS name shared by the whole cluster also lists us
+		return errors.New("Seed is this node")
+	}
+
+	ss.members.SetIncarnation(res.GetIncarnation())
+	ss.members.Apply(res.GetOthers())
+	return nil
+}
 
-	ss.addClientToMap(client.GetID(), client)
+// forceRecheck compares every keg with the peer's. Only the kegs' metadata
+// is fetched up front, their merkle trees are compared branch by branch.
+// Every keg's options are brought up to date before any of their liquids
+// are fetched. At most ResyncConcurrency rechecks run at once.
+func (ss *SyncService) forceRecheck(client IInternalClient) error {
+	if client.GetStatus().GetStatus() == pbServer.PeerStatus_INCOMPATIBLE {
+		return errors.New("Peer hashes its merkle trees with another version")
+	}
 
-	for _, instance := range instances {
-		if _, exist := ss.clients[instance.ID]; !exist {
-			ss.Register(instance.Address)
+	if ss.resyncs != nil {
+		select {
+		case ss.resyncs <- struct{}{}:
+		case <-ss.stop:
+			return errors.New("Sync service stopped")
 		}
+		defer func() { <-ss.resyncs }()
 	}
-}
 
-// GetClientAddresses returns the server information for all clients
-// currently connected
-func (ss *SyncService) GetClientAddresses() []*pbModel.ServerInfo {
-	var others []*pbModel.ServerInfo
-	for _, v := range ss.clients {
-		others = append(others, v.GetServerInfo())
+	log.Printf("forcing recheck with %v at %v\n", client.GetID(), client.GetAddress())
+	summaries, err := client.GetKegSummaries()
+	if err != nil {
+		log.Printf("failed to get kegs from %v: %v\n", client.GetID(), err)
+		return err
 	}
 
-	return others
-}
+	diffs := make(map[string]*keg.KegDiff)
+	for _, summary := range summaries {
+		other := keg.FromProto(summary.GetKeg())
+		kegID := other.GetID()
 
-// AddClient adds
-func (ss *SyncService) AddClient(id, address string) {
-	if _, exist := ss.clients[id]; exist {
-		return
+		// Kegs we don't own are only kept track of, not their liquids
+		var remote merkle.Remote
+		if ss.IsOwner(other) {
+			remote = newRemoteTree(client, kegID)
+		}
+
+		kegDiff, err := ss.ss.DiffRemote(other, summary.GetTreeHash(), remote)
+		if err != nil {
+			log.Printf("failed to compare keg %v with %v: %v\n", kegID, client.GetID(), err)
+			continue
+		}
+
+		if err = ss.ss.ApplyKegHeader(kegID, kegDiff); err != nil {
+			log.Printf("failed to apply keg %v from %v: %v\n", kegID, client.GetID(), err)
+		}
+		diffs[kegID] = kegDiff
 	}
-	client := NewInternalClient(address)
-	client.SetID(id)
-	ss.addClientToMap(id, client)
-}
 
-func (ss *SyncService) addClientToMap(id string, client IInternalClient) {
-	log.Printf("connected to client %v at %v\n", client.GetID(), client.GetAddress())
-	ss.clients[id] = client
-}
+	for _, summary := range summaries {
+		kegID := summary.GetKeg().GetId()
+		kegDiff, exist := diffs[kegID]
+		if !exist {
+			continue
+		}
 
-func (ss *SyncService) monitor() {
-	for range time.Tick(1 * time.Second) {
-		for _, client := range ss.clients {
-			if ok := client.Ping(ss.ss.GetState()); !ok {
-				ss.forceRecheck(client)
-			}
+		local, err := ss.ss.GetKegByID(kegID)
+		if err != nil {
+			continue
+		}
+
+		// Everything fetched for a keg is applied in one go, so a release
+		// committed on the peer also lands here as a single unit
+		advertised := make([]wanted, len(kegDiff.Content))
+		for i, content := range kegDiff.Content {
+			advertised[i] = content
+		}
+		liquids := ss.fetchLiquids(client, local, advertised)
+
+		var releases []release.IRelease
+		if kegDiff.Release > 0 {
+			releases = client.GetReleases(kegID, local.GetRelease())
+		}
+
+		if len(liquids) == 0 && len(releases) == 0 {
+			continue
+		}
+
+		if err = ss.ss.ApplyReplicated(kegID, liquids, releases); err != nil {
+			log.Printf("failed to apply changes to keg %v from %v: %v\n", kegID, client.GetID(), err)
 		}
 	}
+
+	client.SetSynced(time.Now())
+	return nil
 }
 
-func (ss *SyncService) forceRecheck(client IInternalClient) {
-	otherState := client.GetState()
-	diff := ss.ss.Diff(otherState)
+// follow subscribes to a peer's change stream and applies every event it
+// pushes, reconnecting with backoff whenever the stream breaks. Whenever we
+// can't tell which events we missed a full recheck is run first.
+func (ss *SyncService) follow(client IInternalClient) {
+	var epoch int64
+	var last uint64
+	backoff := minFollowBackoff
 
-	for kegID, kegDiff := range diff {
-		if kegDiff.Options != nil {
-			ss.ss.UpdateKeg(kegID, kegDiff.Options)
+	for ss.isConnected(client) {
+		from := uint64(0)
+		if last > 0 {
+			from = last + 1
 		}
 
-		for _, liquidInfo := range kegDiff.Content {
-			liquid := client.GetLiquid(kegID, string(liquidInfo.GetID()))
+		stream, err := client.Subscribe(context.Background(), ss.id, epoch, from)
+		if err == nil {
+			if from == 0 {
+				ss.forceRecheck(client)
+			}
 
-			if keg, err := ss.ss.GetKegByID(kegID); err == nil {
-				path := fmt.Sprintf("%s/%s", config.C.DataRoot, kegID)
-				if err = liquid.ToFile(path); err == nil {
-					keg.AddLiquid(liquid.GetLiquidInfo())
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					break
 				}
+				backoff = minFollowBackoff
+				if event.GetEpoch() != epoch {
+					// The peer restarted and numbers its events from scratch,
+					// so last starts over from this event. Anything we didn't
+					// see from its last run needs a recheck.
+					if epoch != 0 && event.GetType() != pbReplication.ChangeEvent_RESYNC {
+						ss.forceRecheck(client)
+					}
+					epoch = event.GetEpoch()
+				}
+				ss.applyEvent(client, event)
+				last = event.GetSequence()
 			}
 		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxFollowBackoff {
+			backoff = maxFollowBackoff
+		}
+	}
+}
+
+func (ss *SyncService) applyEvent(client IInternalClient, event *pbReplication.ChangeEvent) {
+	switch event.GetType() {
+	case pbReplication.ChangeEvent_RESYNC:
+		ss.forceRecheck(client)
+	case pbReplication.ChangeEvent_KEG:
+		ss.applyKegEvent(event)
+	case pbReplication.ChangeEvent_LIQUIDS:
+		ss.applyLiquidsEvent(client, event)
+	}
+}
+
+func (ss *SyncService) applyKegEvent(event *pbReplication.ChangeEvent) {
+	other := keg.FromProto(event.GetKeg())
+	diff := ss.ss.Diff(map[string]keg.IKeg{other.GetID(): other})
+
+	if kegDiff, exist := diff[other.GetID()]; exist {
+		if err := ss.ss.ApplyKegHeader(other.GetID(), kegDiff); err != nil {
+			log.Printf("failed to apply keg %v: %v\n", other.GetID(), err)
+		}
+	}
+}
+
+func (ss *SyncService) applyLiquidsEvent(client IInternalClient, event *pbReplication.ChangeEvent) {
+	kegID := event.GetKegId()
+	keg, err := ss.ss.GetKegByID(kegID)
+	if err != nil {
+		// We missed the keg's creation, so compare state to pick it up
+		ss.forceRecheck(client)
+		return
+	}
+
+	if !ss.IsOwner(keg) {
+		return
+	}
+
+	advertised := make([]wanted, len(event.GetLiquids()))
+	for i, content := range event.GetLiquids() {
+		advertised[i] = content
+	}
+	liquids := ss.fetchLiquids(client, keg, advertised)
+
+	var releases []release.IRelease
+	if event.GetRelease() > keg.GetRelease() {
+		releases = client.GetReleases(kegID, keg.GetRelease())
+	}
+
+	if len(liquids) == 0 && len(releases) == 0 {
+		return
+	}
+
+	if err = ss.ss.ApplyReplicated(kegID, liquids, releases); err != nil {
+		log.Printf("failed to apply event %v from %v: %v\n", event.GetSequence(), client.GetID(), err)
 	}
 }
@@ -15,14 +15,21 @@ func ID() string {
 	return xid.New().String()
 }
 
-// GetFileHash reads a file from the FS and
-// computes the md5 hash based on the file contents
+// GetFileHash reads a file to its end and
+// computes the sha1 hash based on the file contents
 func GetFileHash(file io.Reader) []byte {
 	hash := sha1.New()
-	io.TeeReader(file, hash)
+	io.Copy(hash, file)
 	return hash.Sum(nil)
 }
 
+// GetContentHash computes the same hash as GetFileHash for content
+// already held in memory
+func GetContentHash(content []byte) []byte {
+	hash := sha1.Sum(content)
+	return hash[:]
+}
+
 // GetBitFromByteArray returns the bit found FROM THE BACK in that position
 // from the byte array.
 func GetBitFromByteArray(pos int, array []byte) (byte, error) {